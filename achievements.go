@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+//metrics an achievement can be based on. Every metric is a query counting something for the user $1, admins
+//only choose the metric and the threshold which has to be reached.
+var achievementMetrics = map[string]string{
+	"clicked-images":       "SELECT count(DISTINCT image_id) FROM clicked_images WHERE user_id=$1",
+	"clicked-arguments":    "SELECT count(DISTINCT row_id) FROM clicked_arguments WHERE user_id=$1",
+	"error-images-created": "SELECT count(*) FROM error_images WHERE user_id=$1 AND moderation_status = 'approved'",
+	"pages-answered":       "SELECT count(DISTINCT page_id) FROM page_results WHERE user_id=$1",
+	"units-finished":       "SELECT count(*) FROM unit_results AS decided WHERE decided.user_id=$1 AND " + unitFinished("$1", "decided.unit_id"),
+	"points":               "SELECT COALESCE(points, 0) FROM users WHERE user_id=$1",
+	//only approved and published images of other users count, like the points for finding their errors
+	"error-images-solved": `SELECT count(DISTINCT error_image_attempts.error_image_id) FROM error_image_attempts
+		JOIN error_images ON error_images.error_image_id = error_image_attempts.error_image_id
+		WHERE error_image_attempts.user_id=$1 AND error_image_attempts.completed_at IS NOT NULL
+			AND error_images.published AND error_images.moderation_status = 'approved' AND error_images.user_id IS DISTINCT FROM error_image_attempts.user_id`,
+	//editors and admins can see the age, see canSeeImageAge
+	"images-dated": `SELECT count(DISTINCT dating_answers.image_id) FROM dating_answers
+		JOIN dating_rounds ON dating_rounds.dating_round_id = dating_answers.dating_round_id
+		WHERE dating_rounds.user_id=$1 AND dating_answers.score = 1 AND NOT EXISTS (
+			SELECT 1 FROM user_groups JOIN groups ON groups.group_id = user_groups.group_id
+			WHERE user_groups.user_id = dating_rounds.user_id AND groups.group_name IN ('editor', 'admin'))`,
+	"courses-completed": `SELECT count(*) FROM course_members WHERE user_id=$1
+		AND EXISTS (SELECT 1 FROM course_units WHERE course_units.course_id = course_members.course_id)
+		AND NOT EXISTS (SELECT 1 FROM course_units WHERE course_units.course_id = course_members.course_id
+			AND NOT ` + unitFinished("$1", "course_units.unit_id") + `)`,
+}
+
+type Achievement struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Metric      string `json:"metric"`
+	Threshold   int    `json:"threshold"`
+	Id          int    `json:"id"`
+}
+
+type UserAchievement struct {
+	AchievementId int       `json:"achievement"`
+	Title         string    `json:"title"`
+	Description   string    `json:"description"`
+	AwardedAt     time.Time `json:"awardedAt"`
+}
+
+func validateAchievement(achievement Achievement) error {
+	if len(strings.TrimSpace(achievement.Title)) == 0 {
+		return errors.New("empty title")
+	}
+	if _, ok := achievementMetrics[achievement.Metric]; !ok {
+		return fmt.Errorf("unknown metric %s", achievement.Metric)
+	}
+	if achievement.Threshold < 1 {
+		return errors.New("threshold must be at least 1")
+	}
+	return nil
+}
+
+//evaluateAchievements awards all achievements the user reached after an event. Achievements are a side
+//effect of the event, so failures are only logged.
+func evaluateAchievements(userId int) {
+	if userId <= 0 {
+		return
+	}
+	if awarded, err := EvaluateAchievements(userId); err != nil {
+		log.Println(err)
+	} else if len(awarded) > 0 {
+		log.Printf("user %d got %d achievements", userId, len(awarded))
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/csv"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+)
+
+//buckets for date_trunc which can be used for trends
+var trendBuckets = []string{"day", "week", "month"}
+
+type RowDecisions struct {
+	PageId    int            `json:"page"`
+	RowId     int            `json:"row"`
+	Decisions map[string]int `json:"decisions"`
+	Total     int            `json:"total"`
+}
+
+type PageCompletion struct {
+	PageId         int     `json:"page"`
+	Title          string  `json:"title"`
+	Started        int     `json:"started"`
+	Completed      int     `json:"completed"`
+	Participants   int     `json:"participants"`
+	CompletionRate float64 `json:"completionRate"`
+}
+
+//DecisionShift counts the users whose first decision on a row was From and whose last one is To
+type DecisionShift struct {
+	PageId int    `json:"page"`
+	RowId  int    `json:"row"`
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Count  int    `json:"count"`
+}
+
+type DecisionTrend struct {
+	Bucket   time.Time `json:"bucket"`
+	Decision string    `json:"decision"`
+	Count    int       `json:"count"`
+	Users    int       `json:"users"`
+}
+
+//csvTable is the CSV representation of analytics, one observation per record so it can be read directly by
+//statistics software
+type csvTable struct {
+	header  []string
+	records [][]string
+}
+
+func (table csvTable) write(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(table.header); err != nil {
+		return err
+	}
+	if err := writer.WriteAll(table.records); err != nil {
+		return err
+	}
+	return writer.Error()
+}
+
+func rowDecisionsTable(stats []RowDecisions) csvTable {
+	table := csvTable{header: []string{"page", "row", "decision", "count"}}
+	for _, row := range stats {
+		decisions := make([]string, 0, len(row.Decisions))
+		for decision := range row.Decisions {
+			decisions = append(decisions, decision)
+		}
+		sort.Strings(decisions)
+		for _, decision := range decisions {
+			table.records = append(table.records, []string{strconv.Itoa(row.PageId), strconv.Itoa(row.RowId), decision, strconv.Itoa(row.Decisions[decision])})
+		}
+	}
+	return table
+}
+
+func pageCompletionTable(completion []PageCompletion) csvTable {
+	table := csvTable{header: []string{"page", "title", "started", "completed", "participants", "completion_rate"}}
+	for _, page := range completion {
+		table.records = append(table.records, []string{strconv.Itoa(page.PageId), page.Title, strconv.Itoa(page.Started), strconv.Itoa(page.Completed),
+			strconv.Itoa(page.Participants), strconv.FormatFloat(page.CompletionRate, 'f', 4, 64)})
+	}
+	return table
+}
+
+func decisionShiftTable(shifts []DecisionShift) csvTable {
+	table := csvTable{header: []string{"page", "row", "first_decision", "last_decision", "count"}}
+	for _, shift := range shifts {
+		table.records = append(table.records, []string{strconv.Itoa(shift.PageId), strconv.Itoa(shift.RowId), shift.From, shift.To, strconv.Itoa(shift.Count)})
+	}
+	return table
+}
+
+func decisionTrendTable(trends []DecisionTrend) csvTable {
+	table := csvTable{header: []string{"bucket", "decision", "count", "users"}}
+	for _, trend := range trends {
+		table.records = append(table.records, []string{trend.Bucket.Format(time.RFC3339), trend.Decision, strconv.Itoa(trend.Count), strconv.Itoa(trend.Users)})
+	}
+	return table
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"time"
+)
+
+//states of a student in an assignment
+const (
+	assignmentNotStarted = "not-started"
+	assignmentInProgress = "in-progress"
+	assignmentSubmitted  = "submitted"
+	assignmentLate       = "late"
+)
+
+//Assignment asks the members of a course to complete a unit and decide every argument by the due date. Status,
+//SubmittedAt and Overdue are only set for students.
+type Assignment struct {
+	CourseId    int        `json:"course"`
+	UnitId      int        `json:"unit"`
+	Title       string     `json:"title"`
+	Description string     `json:"description"`
+	DueAt       time.Time  `json:"dueAt"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	Status      string     `json:"status,omitempty"`
+	SubmittedAt *time.Time `json:"submittedAt,omitempty"`
+	Overdue     bool       `json:"overdue,omitempty"`
+	Id          int        `json:"id"`
+}
+
+//AssignmentSubmission is the state of one student in the grading view, with all decisions in the unit
+type AssignmentSubmission struct {
+	UserId      int                  `json:"user"`
+	Username    string               `json:"name"`
+	Status      string               `json:"status"`
+	SubmittedAt *time.Time           `json:"submittedAt"`
+	Overdue     bool                 `json:"overdue"`
+	Decisions   []AssignmentDecision `json:"decisions"`
+}
+
+type AssignmentDecision struct {
+	PageId   int    `json:"page"`
+	RowId    int    `json:"row"`
+	Decision string `json:"decision"`
+}
+
+//assignmentStatus derives the status of a student. A submission after the due date is late, a missing one
+//is overdue once the due date has passed.
+func assignmentStatus(started bool, submittedAt *time.Time, dueAt, now time.Time) (string, bool) {
+	switch {
+	case submittedAt != nil && submittedAt.After(dueAt):
+		return assignmentLate, false
+	case submittedAt != nil:
+		return assignmentSubmitted, false
+	case started:
+		return assignmentInProgress, now.After(dueAt)
+	}
+	return assignmentNotStarted, now.After(dueAt)
+}
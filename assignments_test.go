@@ -0,0 +1,28 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAssignmentStatus(t *testing.T) {
+	due := time.Date(2026, time.October, 19, 8, 0, 0, 0, time.UTC)
+	early, late := due.Add(-time.Minute), due.Add(time.Minute)
+	check := func(name string, started bool, submittedAt *time.Time, now time.Time, status string, overdue bool) {
+		t.Helper()
+		gotStatus, gotOverdue := assignmentStatus(started, submittedAt, due, now)
+		if gotStatus != status || gotOverdue != overdue {
+			t.Errorf("%s: got %s (overdue %v), want %s (overdue %v)", name, gotStatus, gotOverdue, status, overdue)
+		}
+	}
+	check("nothing done yet", false, nil, early, assignmentNotStarted, false)
+	check("nothing done at the due date", false, nil, due, assignmentNotStarted, false)
+	check("nothing done after the due date", false, nil, late, assignmentNotStarted, true)
+	check("started", true, nil, early, assignmentInProgress, false)
+	check("started but not submitted in time", true, nil, late, assignmentInProgress, true)
+	//a submission is judged by when it was made, not by when the teacher looks at it
+	check("submitted in time", true, &early, late.Add(24*time.Hour), assignmentSubmitted, false)
+	check("submitted exactly at the due date", true, &due, late, assignmentSubmitted, false)
+	check("submitted late", true, &late, late, assignmentLate, false)
+	check("submitted without a recorded start", false, &early, early, assignmentSubmitted, false)
+}
@@ -2,13 +2,24 @@ package main
 
 import (
 	"bytes"
+	"crypto/ed25519"
 	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"database/sql"
 	"encoding/base64"
 	"encoding/json"
+	"encoding/pem"
 	"errors"
+	"fmt"
+	"io/ioutil"
 	"log"
+	"math/big"
 	"net/http"
+	"os"
 	"text/template"
+	"time"
 
 	gomail "gopkg.in/gomail.v2"
 
@@ -22,9 +33,15 @@ import (
 const registerMailTemplate = `Bitte klicken Sie auf den Folgenden Link um Ihre Registrierung abzuschließen:
 {{.TokenLink}}`
 
-const pwRecoveryTemplate = `Folgender Link loggt Sie ein. Anschließend können sie Ihr Passwort ändern. Dieser Link ist für 12 Stunden gültig.
+const pwRecoveryTemplate = `Über folgenden Link können Sie ein neues Passwort setzen. Dieser Link ist für 12 Stunden gültig und kann nur einmal verwendet werden.
 {{.TokenLink}}`
 
+//purposes of the single-use tokens sent by mail. They are never accepted as login.
+const (
+	purposeConfirmMail   = "confirm-mail"
+	purposePasswordReset = "password-reset"
+)
+
 type User struct {
 	Username         string   `json:"name" db:"username"`
 	Groups           []string `json:"groups" db:"groups"`
@@ -34,12 +51,49 @@ type User struct {
 	pwHash           string
 	Active           bool `json:"active"`
 	mailHash         string
-	Points           uint   `json:"points"`
-	Rank             uint   `json:"rank"`
-	NewPw            string `json:"newPw"`
-	ClickedImages    []int  `json:"clickedImages"`
-	ClickedArguments []int  `json:"clickedArguments"`
-	ErrorImages      []int  `json:"errorImages"`
+	Points           uint              `json:"points"`
+	Rank             uint              `json:"rank"`
+	NewPw            string            `json:"newPw"`
+	ClickedImages    []int             `json:"clickedImages"`
+	ClickedArguments []int             `json:"clickedArguments"`
+	ErrorImages      []int             `json:"errorImages"`
+	Achievements     []UserAchievement `json:"achievements,omitempty"`
+}
+
+type Group struct {
+	Name string `json:"name" db:"group_name"`
+	ID   int    `json:"id" db:"group_id"`
+}
+
+//groups that are created on startup and can neither be renamed nor deleted
+var builtinGroups = []string{"student", "editor", "admin"}
+
+//roles a user can have as member of a unit
+const (
+	roleOwner    = "owner"
+	roleCoAuthor = "co-author"
+	roleReviewer = "reviewer"
+	roleViewer   = "viewer"
+)
+
+type unitAction int
+
+const (
+	//see the unit and its pages, rows and images while unpublished
+	unitView unitAction = iota
+	//change the unit, its pages, rows and images
+	unitEdit
+	//review the unit
+	unitReview
+	//invite, remove and change members
+	unitManage
+)
+
+var unitRoleActions = map[string][]unitAction{
+	roleOwner:    {unitView, unitEdit, unitManage},
+	roleCoAuthor: {unitView, unitEdit},
+	roleReviewer: {unitView, unitReview},
+	roleViewer:   {unitView},
 }
 
 type LoginStruct struct {
@@ -48,6 +102,21 @@ type LoginStruct struct {
 	Email    string `json:"email" db:"email"`
 }
 
+type RefreshStruct struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+type PasswordReset struct {
+	Password string `json:"password"`
+}
+
+type Session struct {
+	ID        int
+	UserId    int
+	ExpiresAt time.Time
+	Revoked   bool
+}
+
 type MailTemplate struct {
 	Recipient string
 	TokenLink string
@@ -58,14 +127,66 @@ type RequireRole struct {
 	role    string
 }
 
+type RequireSession struct {
+	handler http.Handler
+}
+
 func (u User) isInGroup(group string) bool {
 	return stringInSlice(group, u.Groups)
 }
 
+func isUnitRole(role string) bool {
+	_, ok := unitRoleActions[role]
+	return ok
+}
+
+//mayAccessUnit checks whether the user may perform the action on the unit. Admins may do everything,
+//everybody else depends on the role as member of the unit.
+func mayAccessUnit(user User, unitId int, action unitAction) (bool, error) {
+	if user.isInGroup("admin") {
+		return true, nil
+	}
+	if user.ID <= 0 {
+		return false, nil
+	}
+	role, err := GetUnitMemberRole(unitId, user.ID)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	for _, allowed := range unitRoleActions[role] {
+		if allowed == action {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+//requireUnitAccess answers the request with an error and returns false if the user may not perform the action on the unit
+func requireUnitAccess(w http.ResponseWriter, r *http.Request, user User, unitId int, action unitAction) bool {
+	allowed, err := mayAccessUnit(user, unitId, action)
+	if err != nil {
+		internalError(w, r, err)
+		return false
+	}
+	if !allowed {
+		log.Println("user", user.ID, "may not access unit", unitId)
+		unauthorized(w, r)
+		return false
+	}
+	return true
+}
+
 func NewRequireRole(handler http.Handler, role string) *RequireRole {
 	return &RequireRole{handler, role}
 }
 
+func NewRequireSession(handler http.Handler) *RequireSession {
+	return &RequireSession{handler}
+}
+
 func getUserFromRequest(r *http.Request) (User, error) {
 	userJWT := context.Get(r, "user")
 	if userJWT == nil {
@@ -144,6 +265,43 @@ func GetClaimGroups(claims jwt.MapClaims) ([]string, error) {
 	return groups, nil
 }
 
+//this checks that the session of the token was not revoked and its user is still active.
+//the jwt has to be checked before this, requests without token are passed through.
+func (rs *RequireSession) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	userJWT := context.Get(r, "user")
+	if userJWT == nil {
+		rs.handler.ServeHTTP(w, r)
+		return
+	}
+	claims, ok := userJWT.(*jwt.Token).Claims.(jwt.MapClaims)
+	if !ok {
+		internalError(w, r, errors.New("could not read claims"))
+		return
+	}
+	sessionIdF, ok := claims["sid"].(float64)
+	if !ok {
+		log.Println("token has no session")
+		unauthorized(w, r)
+		return
+	}
+	userIdF, ok := claims["uid"].(float64)
+	if !ok {
+		internalError(w, r, errors.New("could not cast uid to int"))
+		return
+	}
+	valid, err := IsSessionValid(int(sessionIdF), int(userIdF))
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	if !valid {
+		log.Println("session revoked, expired or user inactive")
+		unauthorized(w, r)
+		return
+	}
+	rs.handler.ServeHTTP(w, r)
+}
+
 //this only checks if user is in given role, the jwt has to be checked before this
 func (rr *RequireRole) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	//check if is in role
@@ -167,21 +325,415 @@ func (rr *RequireRole) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-var mySigningKey = []byte("secret")
+// signingMethodEdDSA implements jwt.SigningMethod for Ed25519 keys, which
+// jwt-go does not support out of the box.
+type signingMethodEdDSA struct{}
+
+var SigningMethodEdDSA = &signingMethodEdDSA{}
+
+func init() {
+	jwt.RegisterSigningMethod(SigningMethodEdDSA.Alg(), func() jwt.SigningMethod {
+		return SigningMethodEdDSA
+	})
+}
+
+func (m *signingMethodEdDSA) Alg() string {
+	return "EdDSA"
+}
+
+func (m *signingMethodEdDSA) Verify(signingString, signature string, key interface{}) error {
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return jwt.ErrInvalidKeyType
+	}
+	sig, err := jwt.DecodeSegment(signature)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(publicKey, []byte(signingString), sig) {
+		return jwt.ErrSignatureInvalid
+	}
+	return nil
+}
+
+func (m *signingMethodEdDSA) Sign(signingString string, key interface{}) (string, error) {
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return "", jwt.ErrInvalidKeyType
+	}
+	return jwt.EncodeSegment(ed25519.Sign(privateKey, []byte(signingString))), nil
+}
+
+type signingKey struct {
+	id        string
+	method    jwt.SigningMethod
+	signKey   interface{}
+	verifyKey interface{}
+}
+
+//keyring holds all keys tokens are validated against. Only the current key is used for signing,
+//the others are kept so tokens issued before a key rotation stay valid until they expire.
+type keyring struct {
+	current *signingKey
+	keys    map[string]*signingKey
+}
+
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+var signingKeys *keyring
+
+func loadKeyring(jwtConf JWTConfig) (*keyring, error) {
+	if len(jwtConf.Keys) == 0 {
+		return nil, errors.New("no JWT signing keys configured")
+	}
+	k := &keyring{keys: make(map[string]*signingKey)}
+	for _, keyConf := range jwtConf.Keys {
+		key, err := loadSigningKey(keyConf)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %v", keyConf.ID, err)
+		}
+		if _, exists := k.keys[key.id]; exists {
+			return nil, fmt.Errorf("key id %q configured twice", key.id)
+		}
+		k.keys[key.id] = key
+	}
+	current, ok := k.keys[jwtConf.CurrentKey]
+	if !ok {
+		return nil, fmt.Errorf("current key %q is not configured", jwtConf.CurrentKey)
+	}
+	if current.signKey == nil {
+		return nil, fmt.Errorf("current key %q has no private key", jwtConf.CurrentKey)
+	}
+	k.current = current
+	return k, nil
+}
+
+func loadSigningKey(keyConf SigningKeyConfig) (*signingKey, error) {
+	if len(keyConf.ID) == 0 {
+		return nil, errors.New("missing key id")
+	}
+	key := &signingKey{id: keyConf.ID}
+	switch keyConf.Algorithm {
+	case "", jwt.SigningMethodHS256.Alg():
+		secret := keyConf.Secret
+		if len(keyConf.SecretEnv) != 0 {
+			secret = os.Getenv(keyConf.SecretEnv)
+		}
+		if len(secret) == 0 {
+			return nil, errors.New("empty secret")
+		}
+		key.method = jwt.SigningMethodHS256
+		key.signKey = []byte(secret)
+		key.verifyKey = key.signKey
+	case jwt.SigningMethodRS256.Alg():
+		key.method = jwt.SigningMethodRS256
+		if len(keyConf.PrivateKeyFile) != 0 {
+			pemBytes, err := ioutil.ReadFile(keyConf.PrivateKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			privateKey, err := jwt.ParseRSAPrivateKeyFromPEM(pemBytes)
+			if err != nil {
+				return nil, err
+			}
+			key.signKey = privateKey
+			key.verifyKey = &privateKey.PublicKey
+		}
+		if len(keyConf.PublicKeyFile) != 0 {
+			pemBytes, err := ioutil.ReadFile(keyConf.PublicKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			publicKey, err := jwt.ParseRSAPublicKeyFromPEM(pemBytes)
+			if err != nil {
+				return nil, err
+			}
+			key.verifyKey = publicKey
+		}
+	case SigningMethodEdDSA.Alg():
+		key.method = SigningMethodEdDSA
+		if len(keyConf.PrivateKeyFile) != 0 {
+			parsed, err := parsePEMFile(keyConf.PrivateKeyFile, x509.ParsePKCS8PrivateKey)
+			if err != nil {
+				return nil, err
+			}
+			privateKey, ok := parsed.(ed25519.PrivateKey)
+			if !ok {
+				return nil, errors.New("private key is not an Ed25519 key")
+			}
+			key.signKey = privateKey
+			key.verifyKey = privateKey.Public()
+		}
+		if len(keyConf.PublicKeyFile) != 0 {
+			parsed, err := parsePEMFile(keyConf.PublicKeyFile, x509.ParsePKIXPublicKey)
+			if err != nil {
+				return nil, err
+			}
+			publicKey, ok := parsed.(ed25519.PublicKey)
+			if !ok {
+				return nil, errors.New("public key is not an Ed25519 key")
+			}
+			key.verifyKey = publicKey
+		}
+	default:
+		return nil, fmt.Errorf("unsupported algorithm %q", keyConf.Algorithm)
+	}
+	if key.verifyKey == nil {
+		return nil, errors.New("neither private nor public key file given")
+	}
+	return key, nil
+}
+
+func parsePEMFile(path string, parse func([]byte) (interface{}, error)) (interface{}, error) {
+	pemBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, errors.New("no PEM block found in " + path)
+	}
+	return parse(block.Bytes)
+}
+
+//sign signs the claims with the current key and sets the kid header accordingly
+func (k *keyring) sign(claims jwt.MapClaims) (string, error) {
+	token := jwt.NewWithClaims(k.current.method, claims)
+	token.Header["kid"] = k.current.id
+	return token.SignedString(k.current.signKey)
+}
+
+//keyFunc looks up the validation key by the kid header. Tokens are only accepted if they were
+//signed with the algorithm configured for that key.
+func (k *keyring) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, ok := token.Header["kid"].(string)
+	if !ok {
+		return nil, errors.New("token has no kid header")
+	}
+	key, ok := k.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %s for key %q", token.Method.Alg(), kid)
+	}
+	return key.verifyKey, nil
+}
+
+func (k *keyring) algorithms() []string {
+	algs := make([]string, 0)
+	for _, key := range k.keys {
+		if !stringInSlice(key.method.Alg(), algs) {
+			algs = append(algs, key.method.Alg())
+		}
+	}
+	return algs
+}
+
+//publicKeys returns all asymmetric keys as JWKs. HMAC secrets are never published.
+func (k *keyring) publicKeys() []JSONWebKey {
+	jwks := make([]JSONWebKey, 0)
+	for _, key := range k.keys {
+		switch verifyKey := key.verifyKey.(type) {
+		case *rsa.PublicKey:
+			jwks = append(jwks, JSONWebKey{
+				Kty: "RSA",
+				Kid: key.id,
+				Alg: key.method.Alg(),
+				Use: "sig",
+				N:   base64.RawURLEncoding.EncodeToString(verifyKey.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(verifyKey.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			jwks = append(jwks, JSONWebKey{
+				Kty: "OKP",
+				Kid: key.id,
+				Alg: key.method.Alg(),
+				Use: "sig",
+				Crv: "Ed25519",
+				X:   base64.RawURLEncoding.EncodeToString(verifyKey),
+			})
+		}
+	}
+	return jwks
+}
+
+func accessTokenLifetime() time.Duration {
+	if conf.JWT.AccessTokenMinutes > 0 {
+		return time.Duration(conf.JWT.AccessTokenMinutes) * time.Minute
+	}
+	return 15 * time.Minute
+}
+
+func refreshTokenLifetime() time.Duration {
+	if conf.JWT.RefreshTokenHours > 0 {
+		return time.Duration(conf.JWT.RefreshTokenHours) * time.Hour
+	}
+	return 30 * 24 * time.Hour
+}
+
+func randomToken() (string, error) {
+	tokenBytes := make([]byte, 32)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(tokenBytes), nil
+}
+
+//only a hash of the refresh token is stored, so a leaked sessions table can not be used to log in
+func hashRefreshToken(refreshToken string) string {
+	hash := sha256.Sum256([]byte(refreshToken))
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+func signAccessToken(user User, sessionId int) (string, error) {
+	claims := make(jwt.MapClaims)
+
+	claims["groups"] = user.Groups
+	claims["name"] = user.Username
+	claims["exp"] = time.Now().Add(accessTokenLifetime()).Unix()
+	claims["uid"] = user.ID
+	claims["sid"] = sessionId
+
+	return signToken(claims)
+}
+
+//createSession persists a new session for the user and returns a short-lived access token and the refresh token for it
+func createSession(user User) (accessToken string, refreshToken string, err error) {
+	refreshToken, err = randomToken()
+	if err != nil {
+		return "", "", err
+	}
+	sessionId, err := InsertSession(user.ID, hashRefreshToken(refreshToken), time.Now().Add(refreshTokenLifetime()))
+	if err != nil {
+		return "", "", err
+	}
+	accessToken, err = signAccessToken(user, sessionId)
+	if err != nil {
+		return "", "", err
+	}
+	return accessToken, refreshToken, nil
+}
+
+//refreshSession exchanges a refresh token for a new access token and a new refresh token. The old refresh token
+//becomes invalid. ok is false if the refresh token is unknown, revoked, expired or the user is inactive.
+func refreshSession(oldRefreshToken string) (accessToken string, refreshToken string, ok bool, err error) {
+	oldHash := hashRefreshToken(oldRefreshToken)
+	session, err := GetSessionByRefreshHash(oldHash)
+	if err == sql.ErrNoRows {
+		return "", "", false, nil
+	}
+	if err != nil {
+		return "", "", false, err
+	}
+	if session.Revoked || session.ExpiresAt.Before(time.Now()) {
+		return "", "", false, nil
+	}
+	user, err := GetUserById(session.UserId)
+	if err != nil {
+		return "", "", false, err
+	}
+	if !user.Active {
+		return "", "", false, RevokeSession(session.ID)
+	}
+	refreshToken, err = randomToken()
+	if err != nil {
+		return "", "", false, err
+	}
+	rotated, err := RotateRefreshHash(session.ID, oldHash, hashRefreshToken(refreshToken), time.Now().Add(refreshTokenLifetime()))
+	if err != nil || !rotated {
+		return "", "", false, err
+	}
+	accessToken, err = signAccessToken(user, session.ID)
+	if err != nil {
+		return "", "", false, err
+	}
+	return accessToken, refreshToken, true, nil
+}
+
+//issuePurposeToken creates a signed token that can be consumed once for the given purpose
+func issuePurposeToken(userId int, purpose string, lifetime time.Duration) (string, error) {
+	jti, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+	expiresAt := time.Now().Add(lifetime)
+	if err := InsertUserToken(userId, purpose, jti, expiresAt); err != nil {
+		return "", err
+	}
+	claims := make(jwt.MapClaims)
+
+	claims["uid"] = userId
+	claims["purpose"] = purpose
+	claims["jti"] = jti
+	claims["exp"] = expiresAt.Unix()
+
+	return signToken(claims)
+}
+
+//consumePurposeToken checks signature and purpose of the token and marks it as used. ok is false if the
+//token is invalid, was issued for another purpose or was already used.
+func consumePurposeToken(tokenString string, purpose string) (userId int, ok bool, err error) {
+	token, err := parseToken(tokenString)
+	if err != nil {
+		log.Println(err)
+		return 0, false, nil
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return 0, false, nil
+	}
+	if claimPurpose, _ := claims["purpose"].(string); claimPurpose != purpose {
+		return 0, false, nil
+	}
+	jti, ok := claims["jti"].(string)
+	if !ok {
+		return 0, false, nil
+	}
+	return ConsumeUserToken(jti, purpose)
+}
+
+func getSessionIdFromRequest(r *http.Request) (int, error) {
+	claims, err := GetJWTClaims(r)
+	if err != nil {
+		return 0, err
+	}
+	sessionIdF, ok := claims["sid"].(float64)
+	if !ok {
+		return 0, errors.New("token has no session")
+	}
+	return int(sessionIdF), nil
+}
+
+func signToken(claims jwt.MapClaims) (string, error) {
+	return signingKeys.sign(claims)
+}
+
+func parseToken(tokenString string) (*jwt.Token, error) {
+	parser := jwt.Parser{ValidMethods: signingKeys.algorithms()}
+	return parser.Parse(tokenString, signingKeys.keyFunc)
+}
 
 var jwtRequiredMiddleware = jwtmiddleware.New(jwtmiddleware.Options{
 	ValidationKeyGetter: func(token *jwt.Token) (interface{}, error) {
-		return mySigningKey, nil
+		return signingKeys.keyFunc(token)
 	},
-	SigningMethod: jwt.SigningMethodHS256,
 })
 
 var jwtOptionalMiddleware = jwtmiddleware.New(jwtmiddleware.Options{
 	ValidationKeyGetter: func(token *jwt.Token) (interface{}, error) {
-		return mySigningKey, nil
+		return signingKeys.keyFunc(token)
 	},
 	CredentialsOptional: true,
-	SigningMethod:       jwt.SigningMethodHS256,
 })
 
 func HashPWWithSalt(pw, saltBytes []byte) ([]byte, error) {
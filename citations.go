@@ -0,0 +1,711 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+//citeReference matches [abbrev] in markdown, but not the text of links and images which is followed by (url)
+var citeReference = regexp.MustCompile(`\[([^\[\]]+)\](\()?`)
+
+type BibliographyEntry struct {
+	Number    int    `json:"number"`
+	Cite      Cite   `json:"cite"`
+	Formatted string `json:"formatted"`
+}
+
+func validateCite(cite Cite) error {
+	if len(strings.TrimSpace(cite.Abbrev)) == 0 {
+		return errors.New("abbrev must not be empty")
+	}
+	if strings.ContainsAny(cite.Abbrev, "[]") {
+		return errors.New("abbrev must not contain brackets")
+	}
+	return nil
+}
+
+//buildBibliography numbers the cites in the order of their first reference in the markdown and renders them
+//in style. References to unknown abbrevs are ignored, they are most likely just brackets in the text.
+func buildBibliography(cites []Cite, markdown []string, style string) ([]BibliographyEntry, error) {
+	byAbbrev := make(map[string]Cite)
+	for _, cite := range cites {
+		byAbbrev[cite.Abbrev] = cite
+	}
+	entries := make([]BibliographyEntry, 0)
+	seen := make(map[string]bool)
+	for _, text := range markdown {
+		for _, match := range citeReference.FindAllStringSubmatch(text, -1) {
+			if len(match[2]) > 0 {
+				continue
+			}
+			abbrev := strings.TrimSpace(match[1])
+			cite, ok := byAbbrev[abbrev]
+			if !ok || seen[abbrev] {
+				continue
+			}
+			seen[abbrev] = true
+			formatted, err := formatCite(cite, style)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, BibliographyEntry{Number: len(entries) + 1, Cite: cite, Formatted: formatted})
+		}
+	}
+	return entries, nil
+}
+
+const (
+	styleAPA = "apa"
+	styleDIN = "din-1505-2"
+)
+
+var citationStyles = []string{styleAPA, styleDIN}
+
+const (
+	formatBibTeX  = "bibtex"
+	formatCSLJSON = "csl-json"
+)
+
+//bibtexToCSL maps BibTeX entry types to the CSL types stored in cites, unknown types become document
+var bibtexToCSL = map[string]string{
+	"article":       "article-journal",
+	"book":          "book",
+	"inbook":        "chapter",
+	"incollection":  "chapter",
+	"inproceedings": "paper-conference",
+	"conference":    "paper-conference",
+	"phdthesis":     "thesis",
+	"mastersthesis": "thesis",
+	"techreport":    "report",
+	"online":        "webpage",
+	"electronic":    "webpage",
+	"www":           "webpage",
+	"misc":          "document",
+}
+
+var cslToBibTeX = map[string]string{
+	"article":           "article",
+	"article-journal":   "article",
+	"article-magazine":  "article",
+	"article-newspaper": "article",
+	"book":              "book",
+	"chapter":           "incollection",
+	"paper-conference":  "inproceedings",
+	"thesis":            "phdthesis",
+	"report":            "techreport",
+	"webpage":           "online",
+	"post-weblog":       "online",
+	"document":          "misc",
+}
+
+//hasStructure is false for cites which only consist of a hand written text
+func (cite Cite) hasStructure() bool {
+	return len(cite.Title) > 0 || len(cite.Authors) > 0
+}
+
+//splitName splits a stored author name "Family, Given" into its parts. Names without a comma, e.g.
+//institutions, are returned as family name only.
+func splitName(name string) (family, given string) {
+	if idx := strings.Index(name, ","); idx >= 0 {
+		return strings.TrimSpace(name[:idx]), strings.TrimSpace(name[idx+1:])
+	}
+	return strings.TrimSpace(name), ""
+}
+
+func joinName(family, given string) string {
+	if len(given) == 0 {
+		return family
+	}
+	return family + ", " + given
+}
+
+func letters(text string) []rune {
+	result := make([]rune, 0, len(text))
+	for _, r := range text {
+		if unicode.IsLetter(r) {
+			result = append(result, r)
+		}
+	}
+	return result
+}
+
+//generateAbbrev builds an abbreviation like the BibTeX alpha style: the first three letters of a single
+//author or the initials of up to three authors, followed by the last two digits of the year. Cites without
+//letters in their authors or title are Anon. Abbreviations in taken get a letter appended.
+func generateAbbrev(cite Cite, taken map[string]bool) string {
+	var prefix []rune
+	switch len(cite.Authors) {
+	case 0:
+		prefix = letters(cite.Title)
+		if len(prefix) > 3 {
+			prefix = prefix[:3]
+		}
+	case 1:
+		family, _ := splitName(cite.Authors[0])
+		prefix = letters(family)
+		if len(prefix) > 3 {
+			prefix = prefix[:3]
+		}
+	default:
+		for i, author := range cite.Authors {
+			if i == 3 {
+				prefix = append(prefix, '+')
+				break
+			}
+			family, _ := splitName(author)
+			if l := letters(family); len(l) > 0 {
+				prefix = append(prefix, unicode.ToUpper(l[0]))
+			}
+		}
+	}
+	if len(prefix) == 0 || prefix[0] == '+' {
+		prefix = []rune("Anon")
+	}
+	abbrev := string(prefix)
+	if cite.Year > 0 {
+		abbrev += fmt.Sprintf("%02d", cite.Year%100)
+	}
+	if !taken[abbrev] {
+		return abbrev
+	}
+	for suffix := 'a'; suffix <= 'z'; suffix++ {
+		if candidate := abbrev + string(suffix); !taken[candidate] {
+			return candidate
+		}
+	}
+	for i := 2; ; i++ {
+		if candidate := abbrev + strconv.Itoa(i); !taken[candidate] {
+			return candidate
+		}
+	}
+}
+
+//formatCite renders the cite in one of the citationStyles. Cites without structured data keep their text.
+func formatCite(cite Cite, style string) (string, error) {
+	if !stringInSlice(style, citationStyles) {
+		return "", fmt.Errorf("unknown citation style %s", style)
+	}
+	if !cite.hasStructure() {
+		return cite.Text, nil
+	}
+	if style == styleDIN {
+		return formatDIN(cite), nil
+	}
+	return formatAPA(cite), nil
+}
+
+func initials(given string) string {
+	parts := make([]string, 0)
+	for _, name := range strings.Fields(given) {
+		hyphenated := make([]string, 0)
+		for _, part := range strings.Split(name, "-") {
+			if l := []rune(part); len(l) > 0 {
+				hyphenated = append(hyphenated, string(l[0])+".")
+			}
+		}
+		parts = append(parts, strings.Join(hyphenated, "-"))
+	}
+	return strings.Join(parts, " ")
+}
+
+func withPeriod(text string) string {
+	if strings.HasSuffix(text, ".") || strings.HasSuffix(text, "?") || strings.HasSuffix(text, "!") {
+		return text
+	}
+	return text + "."
+}
+
+//formatAPA renders Family, G., & Family, G. (Year). Title. Publisher. https://doi.org/DOI
+func formatAPA(cite Cite) string {
+	names := make([]string, len(cite.Authors))
+	for i, author := range cite.Authors {
+		family, given := splitName(author)
+		names[i] = joinName(family, initials(given))
+	}
+	year := "n.d."
+	if cite.Year > 0 {
+		year = strconv.Itoa(cite.Year)
+	}
+	var b strings.Builder
+	switch len(names) {
+	case 0:
+		b.WriteString(withPeriod(cite.Title))
+		fmt.Fprintf(&b, " (%s).", year)
+	default:
+		if len(names) == 1 {
+			b.WriteString(names[0])
+		} else {
+			b.WriteString(strings.Join(names[:len(names)-1], ", "))
+			b.WriteString(", & ")
+			b.WriteString(names[len(names)-1])
+		}
+		fmt.Fprintf(&b, " (%s).", year)
+		if len(cite.Title) > 0 {
+			b.WriteString(" " + withPeriod(cite.Title))
+		}
+	}
+	if len(cite.Publisher) > 0 {
+		b.WriteString(" " + withPeriod(cite.Publisher))
+	}
+	if len(cite.DOI) > 0 {
+		b.WriteString(" https://doi.org/" + cite.DOI)
+	} else if len(cite.URL) > 0 {
+		b.WriteString(" " + cite.URL)
+	}
+	return b.String()
+}
+
+//formatDIN renders FAMILY, Given ; FAMILY, Given: Title. Publisher, Year. DOI: DOI
+func formatDIN(cite Cite) string {
+	names := make([]string, len(cite.Authors))
+	for i, author := range cite.Authors {
+		family, given := splitName(author)
+		names[i] = joinName(strings.ToUpper(family), given)
+	}
+	var b strings.Builder
+	if len(names) > 0 {
+		b.WriteString(strings.Join(names, " ; "))
+		b.WriteString(": ")
+	}
+	b.WriteString(cite.Title)
+	place := make([]string, 0, 2)
+	if len(cite.Publisher) > 0 {
+		place = append(place, cite.Publisher)
+	}
+	if cite.Year > 0 {
+		place = append(place, strconv.Itoa(cite.Year))
+	}
+	if len(place) > 0 {
+		b.WriteString(". " + strings.Join(place, ", "))
+	}
+	b.WriteString(".")
+	if len(cite.DOI) > 0 {
+		b.WriteString(" DOI: " + cite.DOI)
+	} else if len(cite.URL) > 0 {
+		b.WriteString(" URL: " + cite.URL)
+	}
+	return b.String()
+}
+
+//parseYear reads the year from the beginning of a BibTeX year or an ISO date
+func parseYear(text string) int {
+	digits := strings.TrimSpace(text)
+	end := 0
+	for end < len(digits) && end < 4 && digits[end] >= '0' && digits[end] <= '9' {
+		end++
+	}
+	year, _ := strconv.Atoi(digits[:end])
+	return year
+}
+
+type bibEntry struct {
+	Type   string
+	Key    string
+	Fields map[string]string
+}
+
+func isBibIdent(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.IndexByte("_-:./+'", c) >= 0
+}
+
+func skipSpace(data string, pos int) int {
+	for pos < len(data) && unicode.IsSpace(rune(data[pos])) {
+		pos++
+	}
+	return pos
+}
+
+//matchingClose returns the position of the bracket closing the one at pos. Escaped brackets are skipped.
+func matchingClose(data string, pos int, open, close byte) (int, error) {
+	depth := 0
+	for i := pos; i < len(data); i++ {
+		if data[i] == '\\' {
+			i++
+			continue
+		}
+		if data[i] == open {
+			depth++
+		} else if data[i] == close {
+			depth--
+			if depth == 0 {
+				return i, nil
+			}
+		}
+	}
+	return -1, fmt.Errorf("missing %c for %c at %d", close, open, pos)
+}
+
+//readBibValue reads a field value made of {braced} and "quoted" parts or plain words concatenated with #
+func readBibValue(data string, pos int) (string, int, error) {
+	var value strings.Builder
+	for {
+		pos = skipSpace(data, pos)
+		if pos >= len(data) {
+			return "", pos, errors.New("unexpected end of BibTeX")
+		}
+		switch data[pos] {
+		case '{':
+			end, err := matchingClose(data, pos, '{', '}')
+			if err != nil {
+				return "", pos, err
+			}
+			value.WriteString(data[pos+1 : end])
+			pos = end + 1
+		case '"':
+			depth, end := 0, -1
+			for i := pos + 1; i < len(data) && end < 0; i++ {
+				switch data[i] {
+				case '\\':
+					i++
+				case '{':
+					depth++
+				case '}':
+					depth--
+				case '"':
+					if depth == 0 {
+						end = i
+					}
+				}
+			}
+			if end < 0 {
+				return "", pos, fmt.Errorf("unterminated string at %d", pos)
+			}
+			value.WriteString(data[pos+1 : end])
+			pos = end + 1
+		default:
+			start := pos
+			for pos < len(data) && isBibIdent(data[pos]) {
+				pos++
+			}
+			if start == pos {
+				return "", pos, fmt.Errorf("unexpected %c at %d", data[pos], pos)
+			}
+			value.WriteString(data[start:pos])
+		}
+		pos = skipSpace(data, pos)
+		if pos < len(data) && data[pos] == '#' {
+			pos++
+			continue
+		}
+		return value.String(), pos, nil
+	}
+}
+
+//parseBibTeX reads all entries of a BibTeX file. @comment, @preamble and @string are skipped, string macros
+//are not expanded.
+func parseBibTeX(data string) ([]bibEntry, error) {
+	entries := make([]bibEntry, 0)
+	pos := 0
+	for {
+		at := strings.IndexByte(data[pos:], '@')
+		if at < 0 {
+			return entries, nil
+		}
+		pos += at + 1
+		start := pos
+		for pos < len(data) && isBibIdent(data[pos]) {
+			pos++
+		}
+		entryType := strings.ToLower(data[start:pos])
+		pos = skipSpace(data, pos)
+		if pos >= len(data) || (data[pos] != '{' && data[pos] != '(') {
+			return nil, fmt.Errorf("expected { after @%s", entryType)
+		}
+		open, close := data[pos], byte('}')
+		if open == '(' {
+			close = ')'
+		}
+		if entryType == "comment" || entryType == "preamble" || entryType == "string" {
+			end, err := matchingClose(data, pos, open, close)
+			if err != nil {
+				return nil, err
+			}
+			pos = end + 1
+			continue
+		}
+		pos++
+		keyEnd := strings.IndexByte(data[pos:], ',')
+		if keyEnd < 0 {
+			return nil, fmt.Errorf("missing key in @%s at %d", entryType, start)
+		}
+		entry := bibEntry{Type: entryType, Key: strings.TrimSpace(data[pos : pos+keyEnd]), Fields: make(map[string]string)}
+		pos += keyEnd + 1
+		for {
+			pos = skipSpace(data, pos)
+			if pos >= len(data) {
+				return nil, fmt.Errorf("unterminated entry %s", entry.Key)
+			}
+			if data[pos] == close {
+				pos++
+				break
+			}
+			if data[pos] == ',' {
+				pos++
+				continue
+			}
+			nameStart := pos
+			for pos < len(data) && isBibIdent(data[pos]) {
+				pos++
+			}
+			name := strings.ToLower(data[nameStart:pos])
+			pos = skipSpace(data, pos)
+			if len(name) == 0 || pos >= len(data) || data[pos] != '=' {
+				return nil, fmt.Errorf("expected field in entry %s at %d", entry.Key, nameStart)
+			}
+			value, next, err := readBibValue(data, pos+1)
+			if err != nil {
+				return nil, err
+			}
+			entry.Fields[name] = value
+			pos = next
+		}
+		entries = append(entries, entry)
+	}
+}
+
+var latexReplacer = newLatexReplacer()
+
+func newLatexReplacer() *strings.Replacer {
+	accents := []struct {
+		command string
+		plain   string
+		accent  string
+	}{
+		{`"`, "aouAOUeiEI", "äöüÄÖÜëïËÏ"},
+		{`'`, "aeiouAEIOUyc", "áéíóúÁÉÍÓÚýć"},
+		{"`", "aeiouAEIOU", "àèìòùÀÈÌÒÙ"},
+		{`^`, "aeiouAEIOU", "âêîôûÂÊÎÔÛ"},
+		{`~`, "nNaoAO", "ñÑãõÃÕ"},
+	}
+	pairs := []string{`{\ss}`, "ß", `\ss{}`, "ß", `\ss`, "ß", `\&`, "&", `\%`, "%", `\_`, "_", `\$`, "$", `\#`, "#", "---", "—", "--", "–"}
+	for _, a := range accents {
+		accented := []rune(a.accent)
+		for i, c := range a.plain {
+			letter := string(c)
+			target := string(accented[i])
+			pairs = append(pairs, `{\`+a.command+letter+`}`, target, `\`+a.command+`{`+letter+`}`, target, `\`+a.command+letter, target)
+		}
+	}
+	return strings.NewReplacer(pairs...)
+}
+
+//decodeLaTeX turns the usual LaTeX accents into unicode and drops the remaining protecting braces
+func decodeLaTeX(text string) string {
+	text = latexReplacer.Replace(text)
+	text = strings.NewReplacer("{", "", "}", "").Replace(text)
+	return strings.Join(strings.Fields(text), " ")
+}
+
+var bibtexEscaper = strings.NewReplacer(`&`, `\&`, `%`, `\%`, `$`, `\$`, `#`, `\#`, `_`, `\_`)
+
+//splitBibAuthors splits an author field at "and" outside of braces
+func splitBibAuthors(field string) []string {
+	names := make([]string, 0)
+	depth, start := 0, 0
+	lower := strings.ToLower(field)
+	for i := 0; i < len(field); i++ {
+		switch field[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+		default:
+			if depth == 0 && strings.HasPrefix(lower[i:], " and ") {
+				names = append(names, field[start:i])
+				start = i + len(" and ")
+				i = start - 1
+			}
+		}
+	}
+	names = append(names, field[start:])
+	authors := make([]string, 0, len(names))
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		//names in braces are institutions and must not be split
+		institution := strings.HasPrefix(name, "{") && strings.HasSuffix(name, "}")
+		name = decodeLaTeX(name)
+		//parts of broken fields like "A and }{" can be left without any name
+		if len(strings.Trim(name, ", ")) == 0 {
+			continue
+		}
+		if institution {
+			authors = append(authors, name)
+			continue
+		}
+		if strings.Contains(name, ",") {
+			family, given := splitName(name)
+			authors = append(authors, joinName(family, given))
+			continue
+		}
+		parts := strings.Fields(name)
+		authors = append(authors, joinName(parts[len(parts)-1], strings.Join(parts[:len(parts)-1], " ")))
+	}
+	return authors
+}
+
+func importBibTeX(data string) ([]Cite, error) {
+	entries, err := parseBibTeX(data)
+	if err != nil {
+		return nil, err
+	}
+	cites := make([]Cite, 0, len(entries))
+	for _, entry := range entries {
+		cite := Cite{Authors: make([]string, 0)}
+		if citeType, ok := bibtexToCSL[entry.Type]; ok {
+			cite.Type = citeType
+		} else {
+			cite.Type = "document"
+		}
+		if authors, ok := entry.Fields["author"]; ok {
+			cite.Authors = splitBibAuthors(authors)
+		} else if editors, ok := entry.Fields["editor"]; ok {
+			cite.Authors = splitBibAuthors(editors)
+		}
+		cite.Title = decodeLaTeX(entry.Fields["title"])
+		if year, ok := entry.Fields["year"]; ok {
+			cite.Year = parseYear(year)
+		} else {
+			cite.Year = parseYear(entry.Fields["date"])
+		}
+		for _, field := range []string{"publisher", "institution", "school", "organization"} {
+			if value, ok := entry.Fields[field]; ok {
+				cite.Publisher = decodeLaTeX(value)
+				break
+			}
+		}
+		cite.URL = strings.TrimSpace(entry.Fields["url"])
+		cite.DOI = strings.TrimSpace(entry.Fields["doi"])
+		cite.Text = decodeLaTeX(entry.Fields["note"])
+		cites = append(cites, cite)
+	}
+	return cites, nil
+}
+
+func exportBibTeX(cites []Cite) string {
+	var b strings.Builder
+	for _, cite := range cites {
+		entryType, ok := cslToBibTeX[cite.Type]
+		if !ok {
+			entryType = "misc"
+		}
+		fmt.Fprintf(&b, "@%s{%s,\n", entryType, cite.Abbrev)
+		field := func(name, value string) {
+			if len(value) > 0 {
+				fmt.Fprintf(&b, "  %s = {%s},\n", name, value)
+			}
+		}
+		field("author", bibtexEscaper.Replace(strings.Join(cite.Authors, " and ")))
+		field("title", bibtexEscaper.Replace(cite.Title))
+		if cite.Year > 0 {
+			field("year", strconv.Itoa(cite.Year))
+		}
+		field("publisher", bibtexEscaper.Replace(cite.Publisher))
+		field("url", cite.URL)
+		field("doi", cite.DOI)
+		if !cite.hasStructure() {
+			field("note", bibtexEscaper.Replace(cite.Text))
+		}
+		b.WriteString("}\n\n")
+	}
+	return b.String()
+}
+
+type cslName struct {
+	Family  string `json:"family,omitempty"`
+	Given   string `json:"given,omitempty"`
+	Literal string `json:"literal,omitempty"`
+}
+
+type cslDate struct {
+	DateParts [][]interface{} `json:"date-parts,omitempty"`
+	Raw       string          `json:"raw,omitempty"`
+}
+
+type cslItem struct {
+	ID        interface{} `json:"id"`
+	Type      string      `json:"type"`
+	Title     string      `json:"title,omitempty"`
+	Author    []cslName   `json:"author,omitempty"`
+	Issued    *cslDate    `json:"issued,omitempty"`
+	Publisher string      `json:"publisher,omitempty"`
+	URL       string      `json:"URL,omitempty"`
+	DOI       string      `json:"DOI,omitempty"`
+	Note      string      `json:"note,omitempty"`
+}
+
+func (date *cslDate) year() int {
+	if date == nil {
+		return 0
+	}
+	if len(date.DateParts) > 0 && len(date.DateParts[0]) > 0 {
+		switch year := date.DateParts[0][0].(type) {
+		case float64:
+			return int(year)
+		case string:
+			return parseYear(year)
+		}
+	}
+	return parseYear(date.Raw)
+}
+
+//importCSLJSON reads a CSL-JSON array as exported by Zotero. A single item is accepted as well.
+func importCSLJSON(data []byte) ([]Cite, error) {
+	var items []cslItem
+	if trimmed := strings.TrimSpace(string(data)); strings.HasPrefix(trimmed, "{") {
+		var item cslItem
+		if err := json.Unmarshal(data, &item); err != nil {
+			return nil, err
+		}
+		items = []cslItem{item}
+	} else if err := json.Unmarshal(data, &items); err != nil {
+		return nil, err
+	}
+	cites := make([]Cite, 0, len(items))
+	for _, item := range items {
+		cite := Cite{Type: item.Type, Title: item.Title, Publisher: item.Publisher, URL: item.URL, DOI: item.DOI, Text: item.Note, Authors: make([]string, 0)}
+		if len(cite.Type) == 0 {
+			cite.Type = "document"
+		}
+		for _, name := range item.Author {
+			if len(name.Literal) > 0 {
+				cite.Authors = append(cite.Authors, name.Literal)
+			} else {
+				cite.Authors = append(cite.Authors, joinName(name.Family, name.Given))
+			}
+		}
+		cite.Year = item.Issued.year()
+		cites = append(cites, cite)
+	}
+	return cites, nil
+}
+
+func exportCSLJSON(cites []Cite) ([]byte, error) {
+	items := make([]cslItem, 0, len(cites))
+	for _, cite := range cites {
+		item := cslItem{ID: cite.Abbrev, Type: cite.Type, Title: cite.Title, Publisher: cite.Publisher, URL: cite.URL, DOI: cite.DOI}
+		if len(item.Type) == 0 {
+			item.Type = "document"
+		}
+		for _, author := range cite.Authors {
+			family, given := splitName(author)
+			if len(given) == 0 {
+				item.Author = append(item.Author, cslName{Literal: family})
+			} else {
+				item.Author = append(item.Author, cslName{Family: family, Given: given})
+			}
+		}
+		if cite.Year > 0 {
+			item.Issued = &cslDate{DateParts: [][]interface{}{{cite.Year}}}
+		}
+		if !cite.hasStructure() {
+			item.Note = cite.Text
+		}
+		items = append(items, item)
+	}
+	return json.MarshalIndent(items, "", "  ")
+}
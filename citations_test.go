@@ -0,0 +1,177 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseBibTeX(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		entries []bibEntry
+		wantErr bool
+	}{
+		{
+			name:    "nested braces are kept",
+			data:    `@Book{k1, title = {The {DNA} Book}, year = 2001}`,
+			entries: []bibEntry{{Type: "book", Key: "k1", Fields: map[string]string{"title": "The {DNA} Book", "year": "2001"}}},
+		},
+		{
+			name:    "quoted parts are concatenated",
+			data:    `@article{k2, title = "Part " # {One}, author = "A and {B}"}`,
+			entries: []bibEntry{{Type: "article", Key: "k2", Fields: map[string]string{"title": "Part One", "author": "A and {B}"}}},
+		},
+		{
+			name:    "parentheses and comments",
+			data:    "@comment{ignored}\n@misc(k3, note = {x},)",
+			entries: []bibEntry{{Type: "misc", Key: "k3", Fields: map[string]string{"note": "x"}}},
+		},
+		{
+			name:    "unterminated entry",
+			data:    `@article{k4, title = {T}`,
+			wantErr: true,
+		},
+		{
+			name:    "unbalanced braces",
+			data:    `@article{k5, title = {T}}}{`,
+			entries: []bibEntry{{Type: "article", Key: "k5", Fields: map[string]string{"title": "T"}}},
+		},
+		{
+			name:    "missing key",
+			data:    `@article{title}`,
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		entries, err := parseBibTeX(test.data)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: got error %v", test.name, err)
+			continue
+		}
+		if !test.wantErr && !reflect.DeepEqual(entries, test.entries) {
+			t.Errorf("%s: got %v, want %v", test.name, entries, test.entries)
+		}
+	}
+}
+
+func TestSplitBibAuthors(t *testing.T) {
+	tests := []struct {
+		field   string
+		authors []string
+	}{
+		{"Doe, John and Jane Roe", []string{"Doe, John", "Roe, Jane"}},
+		{"Doe, John AND Roe, Jane", []string{"Doe, John", "Roe, Jane"}},
+		{"{Smith and Sons} and Max Mustermann", []string{"Smith and Sons", "Mustermann, Max"}},
+		{`M{\"u}ller, Hans and J. R. R. Tolkien`, []string{"Müller, Hans", "Tolkien, J. R. R."}},
+		{"Alexander", []string{"Alexander"}},
+		{"A and }{", []string{"A"}},
+		{"{} and , and  and Doe", []string{"Doe"}},
+		{" and ", []string{}},
+		{"", []string{}},
+	}
+	for _, test := range tests {
+		if authors := splitBibAuthors(test.field); !reflect.DeepEqual(authors, test.authors) {
+			t.Errorf("splitBibAuthors(%q) = %q, want %q", test.field, authors, test.authors)
+		}
+	}
+}
+
+func TestDecodeLaTeX(t *testing.T) {
+	tests := []struct {
+		text    string
+		decoded string
+	}{
+		{`Schr\"odinger`, "Schrödinger"},
+		{`Stra{\ss}e`, "Straße"},
+		{`\'{e}t\'e`, "été"},
+		{`{\~n}and{\'u}`, "ñandú"},
+		{`R\&D with 10\% \_ \$ \#`, "R&D with 10% _ $ #"},
+		{"pages 1--2 --- done", "pages 1–2 — done"},
+		{"{Big}   {Data}\n", "Big Data"},
+	}
+	for _, test := range tests {
+		if decoded := decodeLaTeX(test.text); decoded != test.decoded {
+			t.Errorf("decodeLaTeX(%q) = %q, want %q", test.text, decoded, test.decoded)
+		}
+	}
+}
+
+func TestFormatCite(t *testing.T) {
+	book := Cite{Authors: []string{"Doe, John", "Roe, Jane Ann"}, Title: "A title", Year: 2020, Publisher: "Pub", DOI: "10.1/x"}
+	tests := []struct {
+		name      string
+		cite      Cite
+		style     string
+		formatted string
+		wantErr   bool
+	}{
+		{"apa", book, styleAPA, "Doe, J., & Roe, J. A. (2020). A title. Pub. https://doi.org/10.1/x", false},
+		{"din", book, styleDIN, "DOE, John ; ROE, Jane Ann: A title. Pub, 2020. DOI: 10.1/x", false},
+		{"apa without authors and year", Cite{Title: "Why?", URL: "http://x"}, styleAPA, "Why? (n.d.). http://x", false},
+		{"din institution", Cite{Authors: []string{"Oikos"}, Title: "Report", URL: "http://x"}, styleDIN, "OIKOS: Report. URL: http://x", false},
+		{"text only", Cite{Text: "Hand written"}, styleAPA, "Hand written", false},
+		{"unknown style", book, "mla", "", true},
+	}
+	for _, test := range tests {
+		formatted, err := formatCite(test.cite, test.style)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: got error %v", test.name, err)
+			continue
+		}
+		if formatted != test.formatted {
+			t.Errorf("%s: got %q, want %q", test.name, formatted, test.formatted)
+		}
+	}
+}
+
+func TestBibTeXRoundTrip(t *testing.T) {
+	cite := Cite{Type: "book", Abbrev: "Doe20", Authors: []string{"Doe, John", "Oikos"}, Title: "R&D with 50% #1", Year: 2020, Publisher: "A_B"}
+	cites, err := importBibTeX(exportBibTeX([]Cite{cite}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cite.Abbrev = ""
+	if len(cites) != 1 || !reflect.DeepEqual(cites[0], cite) {
+		t.Errorf("got %+v, want %+v", cites, cite)
+	}
+}
+
+func TestGenerateAbbrevNeverEmpty(t *testing.T) {
+	taken := make(map[string]bool)
+	cites := []Cite{
+		{Authors: []string{"123"}},
+		{Authors: []string{", Given"}},
+		{Authors: []string{"1", "2", "3", "4"}},
+		{Title: "?!"},
+		{},
+	}
+	for _, cite := range cites {
+		abbrev := generateAbbrev(cite, taken)
+		if err := validateCite(Cite{Abbrev: abbrev}); err != nil || taken[abbrev] {
+			t.Errorf("%+v: got abbrev %q", cite, abbrev)
+		}
+		taken[abbrev] = true
+	}
+	if !taken["Anon"] || !taken["Anona"] {
+		t.Errorf("got %v, want Anon with suffixes", taken)
+	}
+	if abbrev := generateAbbrev(Cite{Authors: []string{"Doe, John", "Roe, Jane"}, Year: 1999}, taken); abbrev != "DR99" {
+		t.Errorf("got %q, want DR99", abbrev)
+	}
+}
+
+func TestBuildBibliography(t *testing.T) {
+	cites := []Cite{{Abbrev: "Doe20", Authors: []string{"Doe, John"}, Title: "T", Year: 2020}, {Abbrev: "x", Text: "Hand written"}}
+	markdown := []string{"See [x] and [Doe20], [x] again", "[a link](http://x), [unknown] [Doe20]"}
+	entries, err := buildBibliography(cites, markdown, styleAPA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Cite.Abbrev != "x" || entries[1].Number != 2 || entries[1].Formatted != "Doe, J. (2020). T." {
+		t.Errorf("got %+v", entries)
+	}
+	if _, err := buildBibliography(cites, markdown, "mla"); err == nil {
+		t.Error("an unknown style is not reported")
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"crypto/rand"
+	"time"
+)
+
+//join codes avoid characters that are easily confused like 0/O and 1/I
+const (
+	joinCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	joinCodeLength   = 8
+)
+
+type Course struct {
+	Title       string    `json:"title"`
+	UserId      int       `json:"owner"`
+	JoinCode    string    `json:"joinCode,omitempty"`
+	Units       []int     `json:"units"`
+	MemberCount int       `json:"memberCount"`
+	CreatedAt   time.Time `json:"createdAt"`
+	Id          int       `json:"id"`
+}
+
+type CourseMember struct {
+	UserId   int       `json:"user"`
+	Username string    `json:"name"`
+	JoinedAt time.Time `json:"joinedAt"`
+}
+
+//UnitProgress is what a student did in one unit of a course
+type UnitProgress struct {
+	UnitId        int    `json:"unit"`
+	Pages         int    `json:"pages"`
+	PagesAnswered int    `json:"pagesAnswered"`
+	ClickedImages int    `json:"clickedImages"`
+	Decision      string `json:"decision"`
+	Finished      bool   `json:"finished"`
+}
+
+type StudentProgress struct {
+	UserId        int            `json:"user"`
+	Username      string         `json:"name"`
+	UnitsFinished int            `json:"unitsFinished"`
+	Units         []UnitProgress `json:"units"`
+}
+
+//generateJoinCode returns a random code students use to join a course
+func generateJoinCode() (string, error) {
+	random := make([]byte, joinCodeLength)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	code := make([]byte, joinCodeLength)
+	for i, b := range random {
+		//the alphabet has 32 characters, so the modulo does not favour any of them
+		code[i] = joinCodeAlphabet[int(b)%len(joinCodeAlphabet)]
+	}
+	return string(code), nil
+}
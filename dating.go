@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"time"
+)
+
+const (
+	datingRoundSize    = 10
+	datingRoundMaxSize = 30
+	//estimates this many years beyond the imprecision still get partial credit, at least the imprecision itself
+	datingTolerance = 10
+)
+
+//errAlreadyDated is returned when an image of a round is answered a second time
+var errAlreadyDated = errors.New("image already dated")
+
+//errNoDatingImages is returned when there are no age-known images to build a round from
+var errNoDatingImages = errors.New("no images with known age")
+
+//DatingRound is one play of the dating quiz, the answers are in the order the images are shown
+type DatingRound struct {
+	UserId      int            `json:"user"`
+	UnitId      int            `json:"unit,omitempty"`
+	CourseId    int            `json:"course,omitempty"`
+	CreatedAt   time.Time      `json:"createdAt"`
+	CompletedAt *time.Time     `json:"completedAt"`
+	Score       float64        `json:"score"`
+	MaxScore    int            `json:"maxScore"`
+	Answers     []DatingAnswer `json:"answers,omitempty"`
+	Id          int            `json:"id"`
+}
+
+//DatingAnswer is an image of a round. Age and imprecision are only set after the image was dated.
+type DatingAnswer struct {
+	ImageId     int      `json:"image"`
+	Caption     string   `json:"caption"`
+	Credits     string   `json:"credits"`
+	Estimate    *int     `json:"estimate"`
+	Score       *float64 `json:"score"`
+	Age         *int     `json:"age,omitempty"`
+	Imprecision *int     `json:"imprecision,omitempty"`
+}
+
+//datingScore gives full credit for estimates within age ± imprecision. Beyond that the credit decreases linearly
+//and reaches zero when the estimate is off by another max(imprecision, datingTolerance).
+func datingScore(age, imprecision, estimate int) float64 {
+	if imprecision < 0 {
+		imprecision = 0
+	}
+	off := math.Abs(float64(estimate-age)) - float64(imprecision)
+	if off <= 0 {
+		return 1
+	}
+	span := math.Max(float64(imprecision), datingTolerance)
+	return math.Max(0, 1-off/span)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// TestDatingScoreBoundaries checks where the full credit ends and where no credit is left, on both sides of the
+// age. Small imprecisions still get datingTolerance years to lose the credit.
+func TestDatingScoreBoundaries(t *testing.T) {
+	const age = 1871
+	for _, imprecision := range []int{0, 4, datingTolerance, 30, 100} {
+		span := imprecision
+		if span < datingTolerance {
+			span = datingTolerance
+		}
+		for _, sign := range []int{-1, 1} {
+			checks := []struct {
+				estimate int
+				score    float64
+			}{
+				{age + sign*imprecision, 1},
+				{age + sign*(imprecision+span/2), 0.5},
+				{age + sign*(imprecision+span), 0},
+				{age + sign*(imprecision+span+1), 0},
+			}
+			for _, check := range checks {
+				if score := datingScore(age, imprecision, check.estimate); math.Abs(score-check.score) > 1e-9 {
+					t.Errorf("age %d ± %d, estimate %d: got %v, want %v", age, imprecision, check.estimate, score, check.score)
+				}
+			}
+		}
+	}
+}
+
+func TestDatingScoreNeverIncreasesWithDistance(t *testing.T) {
+	previous := 1.0
+	for estimate := 1900; estimate < 2100; estimate++ {
+		score := datingScore(1900, 7, estimate)
+		if score > previous || score < 0 || score > 1 {
+			t.Fatalf("estimate %d: score %v after %v", estimate, score, previous)
+		}
+		if mirrored := datingScore(1900, 7, 2*1900-estimate); mirrored != score {
+			t.Fatalf("estimate %d: score %v, but %v for the same distance below", estimate, score, mirrored)
+		}
+		previous = score
+	}
+}
+
+func TestDatingScoreIgnoresNegativeImprecision(t *testing.T) {
+	if score := datingScore(1500, -50, 1540); score != 0 {
+		t.Errorf("a negative imprecision widened the full credit: got %v", score)
+	}
+	if score := datingScore(1500, -50, 1500); score != 1 {
+		t.Errorf("the exact age got %v", score)
+	}
+}
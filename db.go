@@ -3,8 +3,11 @@ package main
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"strings"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq"
@@ -14,6 +17,18 @@ var db *sqlx.DB
 
 var emptyArr = "[null]"
 
+//errInvalidOrder is returned if a new order does not contain every row or page exactly once
+var errInvalidOrder = errors.New("the order must contain every id exactly once")
+
+//errAttemptCompleted is returned for clicks on an attempt in which all errors are already found
+var errAttemptCompleted = errors.New("attempt already completed")
+
+//errTooManyMisses is returned for clicks on an attempt that already missed maxMissedClicks times
+var errTooManyMisses = errors.New("too many missed clicks")
+
+//errVersionConflict is returned by updates when the version sent by the client is not the current one
+var errVersionConflict = errors.New("version conflict")
+
 var schema = `
 CREATE TABLE IF NOT EXISTS units (
 	unit_title varchar,
@@ -22,14 +37,47 @@ CREATE TABLE IF NOT EXISTS units (
 	user_id integer,
 	color_scheme integer,
 	unit_id SERIAL PRIMARY KEY,
-	front_image integer
+	front_image integer,
+	status varchar(30) DEFAULT 'draft',
+	publish_at timestamp with time zone,
+	unpublish_at timestamp with time zone,
+	version integer NOT NULL DEFAULT 1,
+	is_template boolean NOT NULL DEFAULT false
+);
+
+CREATE TABLE IF NOT EXISTS unit_status_events (
+	unit_id integer,
+	user_id integer,
+	from_status varchar(30),
+	to_status varchar(30),
+	comment text,
+	created_at timestamp with time zone NOT NULL DEFAULT now(),
+	unit_status_event_id SERIAL PRIMARY KEY
+);
+
+CREATE TABLE IF NOT EXISTS unit_revisions (
+	unit_id integer,
+	user_id integer,
+	action varchar(255),
+	snapshot text,
+	created_at timestamp with time zone NOT NULL DEFAULT now(),
+	unit_revision_id SERIAL PRIMARY KEY
+);
+
+CREATE TABLE IF NOT EXISTS unit_members (
+	unit_id integer,
+	user_id integer,
+	role varchar(30),
+	PRIMARY KEY (unit_id, user_id)
 );
 
 CREATE TABLE IF NOT EXISTS pages (
 	page_title varchar(255),
 	unit_id integer,
 	page_type varchar(255),
-	page_id SERIAL PRIMARY KEY
+	page_id SERIAL PRIMARY KEY,
+	version integer NOT NULL DEFAULT 1,
+	position integer
 );
 
 CREATE TABLE IF NOT EXISTS rows (
@@ -42,14 +90,34 @@ CREATE TABLE IF NOT EXISTS rows (
 	left_is_argument boolean,
 	right_is_argument boolean,
 	page_id integer,
-	row_id SERIAL PRIMARY KEY
+	row_id SERIAL PRIMARY KEY,
+	version integer NOT NULL DEFAULT 1,
+	position integer
+);
+
+CREATE TABLE IF NOT EXISTS page_questions (
+	prompt text,
+	options jsonb NOT NULL DEFAULT '[]',
+	correct jsonb NOT NULL DEFAULT '[]',
+	accepted jsonb NOT NULL DEFAULT '[]',
+	page_id integer,
+	question_id SERIAL PRIMARY KEY,
+	position integer,
+	shuffle_seed bigint NOT NULL DEFAULT floor(random() * 2147483647)
 );
 
 CREATE TABLE IF NOT EXISTS cites (
 	abbrev varchar(255),
 	cite_text text,
 	unit_id integer,
-	cite_id SERIAL PRIMARY KEY
+	cite_id SERIAL PRIMARY KEY,
+	cite_type varchar(50),
+	authors text,
+	title text,
+	year integer,
+	publisher text,
+	url text,
+	doi varchar(255)
 );
 
 CREATE TABLE IF NOT EXISTS rotate_images ( 
@@ -81,6 +149,87 @@ CREATE TABLE IF NOT EXISTS users (
 	points integer DEFAULT 0
 );
 
+CREATE TABLE IF NOT EXISTS courses (
+	title varchar(255),
+	user_id integer,
+	join_code varchar(20) UNIQUE,
+	created_at timestamp with time zone NOT NULL DEFAULT now(),
+	course_id SERIAL PRIMARY KEY
+);
+
+CREATE TABLE IF NOT EXISTS course_members (
+	course_id integer,
+	user_id integer,
+	joined_at timestamp with time zone NOT NULL DEFAULT now(),
+	UNIQUE (course_id, user_id)
+);
+
+CREATE TABLE IF NOT EXISTS course_units (
+	course_id integer,
+	unit_id integer,
+	position integer,
+	UNIQUE (course_id, unit_id)
+);
+
+CREATE TABLE IF NOT EXISTS assignments (
+	course_id integer,
+	unit_id integer,
+	title varchar(255),
+	description text,
+	due_at timestamp with time zone,
+	created_at timestamp with time zone NOT NULL DEFAULT now(),
+	assignment_id SERIAL PRIMARY KEY
+);
+
+CREATE TABLE IF NOT EXISTS assignment_submissions (
+	assignment_id integer,
+	user_id integer,
+	submitted_at timestamp with time zone NOT NULL DEFAULT now(),
+	UNIQUE (assignment_id, user_id)
+);
+
+CREATE TABLE IF NOT EXISTS achievements (
+	title varchar(255),
+	description text,
+	metric varchar(50),
+	threshold integer,
+	achievement_id SERIAL PRIMARY KEY
+);
+
+CREATE TABLE IF NOT EXISTS user_achievements (
+	user_id integer,
+	achievement_id integer,
+	awarded_at timestamp with time zone NOT NULL DEFAULT now(),
+	UNIQUE (user_id, achievement_id)
+);
+
+CREATE TABLE IF NOT EXISTS point_events (
+	user_id integer,
+	event varchar(50),
+	ref_id integer,
+	points integer,
+	created_at timestamp with time zone NOT NULL DEFAULT now(),
+	point_event_id SERIAL PRIMARY KEY
+);
+
+CREATE TABLE IF NOT EXISTS sessions (
+	user_id integer,
+	refresh_hash varchar(255),
+	created_at timestamp with time zone NOT NULL DEFAULT now(),
+	expires_at timestamp with time zone,
+	revoked boolean NOT NULL DEFAULT false,
+	session_id SERIAL PRIMARY KEY
+);
+
+CREATE TABLE IF NOT EXISTS user_tokens (
+	user_id integer,
+	purpose varchar(30),
+	jti varchar(255),
+	expires_at timestamp with time zone,
+	used_at timestamp with time zone,
+	user_token_id SERIAL PRIMARY KEY
+);
+
 CREATE TABLE IF NOT EXISTS groups (
 	group_name varchar(255),
 	group_id SERIAL PRIMARY KEY
@@ -98,6 +247,25 @@ CREATE TABLE IF NOT EXISTS row_results (
 	row_result_id SERIAL PRIMARY KEY
 );
 
+CREATE TABLE IF NOT EXISTS row_result_history (
+	decision varchar(30),
+	row_id integer,
+	page_result_id integer,
+	user_id integer,
+	created_at timestamp with time zone NOT NULL DEFAULT now(),
+	row_result_history_id SERIAL PRIMARY KEY
+);
+
+CREATE TABLE IF NOT EXISTS question_results (
+	choices jsonb NOT NULL DEFAULT '[]',
+	answer_text text NOT NULL DEFAULT '',
+	correct boolean NOT NULL DEFAULT false,
+	question_id integer,
+	page_result_id integer,
+	question_result_id SERIAL PRIMARY KEY,
+	UNIQUE (page_result_id, question_id)
+);
+
 CREATE TABLE IF NOT EXISTS page_results (
 	page_id integer,
 	unit_id integer,
@@ -106,11 +274,10 @@ CREATE TABLE IF NOT EXISTS page_results (
 );
 
 CREATE TABLE IF NOT EXISTS unit_results (
-	pro_count smallint,
-	con_count smallint,
-	undecided_count smallint,
+	decision varchar(30),
 	unit_id integer,
-	user_id integer
+	user_id integer,
+	updated_at timestamp with time zone NOT NULL DEFAULT now()
 );
 
 CREATE TABLE IF NOT EXISTS error_images (
@@ -119,7 +286,21 @@ CREATE TABLE IF NOT EXISTS error_images (
 	scale double precision,
 	user_id integer,
 	error_image_id SERIAL PRIMARY KEY,
-	published boolean NOT NULL DEFAULT false
+	published boolean NOT NULL DEFAULT false,
+	moderation_status varchar(30) DEFAULT 'pending',
+	moderation_reason text,
+	moderated_by integer,
+	moderated_at timestamp with time zone
+);
+
+CREATE TABLE IF NOT EXISTS notifications (
+	user_id integer,
+	kind varchar(50),
+	message text,
+	ref_id integer,
+	created_at timestamp with time zone NOT NULL DEFAULT now(),
+	read_at timestamp with time zone,
+	notification_id SERIAL PRIMARY KEY
 );
 
 CREATE TABLE IF NOT EXISTS error_circles (
@@ -130,6 +311,42 @@ CREATE TABLE IF NOT EXISTS error_circles (
 	error_circle_id SERIAL PRIMARY KEY
 );
 
+CREATE TABLE IF NOT EXISTS error_image_attempts (
+	error_image_id integer,
+	user_id integer,
+	started_at timestamp with time zone NOT NULL DEFAULT now(),
+	completed_at timestamp with time zone,
+	clicks integer NOT NULL DEFAULT 0,
+	error_image_attempt_id SERIAL PRIMARY KEY
+);
+
+CREATE TABLE IF NOT EXISTS dating_rounds (
+	user_id integer,
+	unit_id integer,
+	course_id integer,
+	created_at timestamp with time zone NOT NULL DEFAULT now(),
+	completed_at timestamp with time zone,
+	score double precision NOT NULL DEFAULT 0,
+	dating_round_id SERIAL PRIMARY KEY
+);
+
+CREATE TABLE IF NOT EXISTS dating_answers (
+	dating_round_id integer,
+	image_id integer,
+	position integer,
+	estimate integer,
+	score double precision,
+	answered_at timestamp with time zone,
+	UNIQUE (dating_round_id, image_id)
+);
+
+CREATE TABLE IF NOT EXISTS error_image_hits (
+	error_image_attempt_id integer,
+	error_circle_id integer,
+	created_at timestamp with time zone NOT NULL DEFAULT now(),
+	UNIQUE (error_image_attempt_id, error_circle_id)
+);
+
 CREATE TABLE IF NOT EXISTS clicked_images (
 	user_id integer,
 	image_id integer
@@ -141,6 +358,61 @@ CREATE TABLE IF NOT EXISTS clicked_arguments (
 );
 `
 
+//migrations bring databases created by older versions up to date. All statements have to be idempotent.
+var migrations = `
+ALTER TABLE units ADD COLUMN IF NOT EXISTS status varchar(30);
+UPDATE units SET status = CASE WHEN published THEN 'published' ELSE 'draft' END WHERE status IS NULL;
+ALTER TABLE units ALTER COLUMN status SET DEFAULT 'draft';
+ALTER TABLE units ADD COLUMN IF NOT EXISTS publish_at timestamp with time zone;
+ALTER TABLE units ADD COLUMN IF NOT EXISTS unpublish_at timestamp with time zone;
+ALTER TABLE units ADD COLUMN IF NOT EXISTS version integer NOT NULL DEFAULT 1;
+ALTER TABLE pages ADD COLUMN IF NOT EXISTS version integer NOT NULL DEFAULT 1;
+ALTER TABLE rows ADD COLUMN IF NOT EXISTS version integer NOT NULL DEFAULT 1;
+ALTER TABLE units ADD COLUMN IF NOT EXISTS is_template boolean NOT NULL DEFAULT false;
+ALTER TABLE unit_results ADD COLUMN IF NOT EXISTS decision varchar(30);
+ALTER TABLE unit_results ADD COLUMN IF NOT EXISTS updated_at timestamp with time zone NOT NULL DEFAULT now();
+CREATE UNIQUE INDEX IF NOT EXISTS unit_results_unit_user ON unit_results (unit_id, user_id);
+INSERT INTO row_result_history (decision, row_id, page_result_id, user_id)
+	SELECT row_results.decision, row_results.row_id, row_results.page_result_id, page_results.user_id FROM row_results
+	JOIN page_results ON page_results.page_result_id = row_results.page_result_id
+	WHERE NOT EXISTS (SELECT 1 FROM row_result_history WHERE row_result_history.page_result_id = row_results.page_result_id AND row_result_history.row_id = row_results.row_id);
+CREATE UNIQUE INDEX IF NOT EXISTS point_events_user_event_ref ON point_events (user_id, event, ref_id);
+INSERT INTO point_events (user_id, event, ref_id, points, created_at)
+	SELECT user_id, 'legacy', 0, points, 'epoch' FROM users WHERE points > 0
+	ON CONFLICT (user_id, event, ref_id) DO NOTHING;
+ALTER TABLE error_images ADD COLUMN IF NOT EXISTS moderation_status varchar(30);
+UPDATE error_images SET moderation_status = CASE WHEN published THEN 'approved' ELSE 'pending' END WHERE moderation_status IS NULL;
+ALTER TABLE error_images ALTER COLUMN moderation_status SET DEFAULT 'pending';
+ALTER TABLE error_images ADD COLUMN IF NOT EXISTS moderation_reason text;
+ALTER TABLE error_images ADD COLUMN IF NOT EXISTS moderated_by integer;
+ALTER TABLE error_images ADD COLUMN IF NOT EXISTS moderated_at timestamp with time zone;
+ALTER TABLE dating_rounds ADD COLUMN IF NOT EXISTS course_id integer;
+ALTER TABLE pages ADD COLUMN IF NOT EXISTS position integer;
+ALTER TABLE rows ADD COLUMN IF NOT EXISTS position integer;
+ALTER TABLE page_questions ADD COLUMN IF NOT EXISTS position integer;
+ALTER TABLE page_questions ADD COLUMN IF NOT EXISTS shuffle_seed bigint NOT NULL DEFAULT floor(random() * 2147483647);
+UPDATE pages SET position = numbered.position
+	FROM (SELECT page_id, row_number() OVER (PARTITION BY unit_id ORDER BY page_id) - 1 AS position FROM pages) numbered
+	WHERE pages.page_id = numbered.page_id AND pages.position IS NULL;
+UPDATE rows SET position = numbered.position
+	FROM (SELECT row_id, row_number() OVER (PARTITION BY page_id ORDER BY row_id) - 1 AS position FROM rows) numbered
+	WHERE rows.row_id = numbered.row_id AND rows.position IS NULL;
+UPDATE page_questions SET position = numbered.position
+	FROM (SELECT question_id, row_number() OVER (PARTITION BY page_id ORDER BY question_id) - 1 AS position FROM page_questions) numbered
+	WHERE page_questions.question_id = numbered.question_id AND page_questions.position IS NULL;
+ALTER TABLE cites ADD COLUMN IF NOT EXISTS cite_type varchar(50);
+ALTER TABLE cites ADD COLUMN IF NOT EXISTS authors text;
+ALTER TABLE cites ADD COLUMN IF NOT EXISTS title text;
+ALTER TABLE cites ADD COLUMN IF NOT EXISTS year integer;
+ALTER TABLE cites ADD COLUMN IF NOT EXISTS publisher text;
+ALTER TABLE cites ADD COLUMN IF NOT EXISTS url text;
+ALTER TABLE cites ADD COLUMN IF NOT EXISTS doi varchar(255);
+
+INSERT INTO unit_members (unit_id, user_id, role)
+	SELECT unit_id, user_id, 'owner' FROM units
+	WHERE user_id IS NOT NULL AND NOT EXISTS (SELECT 1 FROM unit_members WHERE unit_members.unit_id = units.unit_id);
+`
+
 func initDB(dbname string, user string, pw string) {
 	var err error
 	db, err = sqlx.Connect("postgres", fmt.Sprintf("dbname=%s user=%s password=%s sslmode=disable", dbname, user, pw))
@@ -149,228 +421,454 @@ func initDB(dbname string, user string, pw string) {
 	}
 
 	db.MustExec(schema)
+	db.MustExec(migrations)
+	if err := seedGroups(); err != nil {
+		log.Fatalln(err)
+	}
+	if err := migratePageTypes(); err != nil {
+		log.Fatalln(err)
+	}
+	if err := seedUnitRevisions(); err != nil {
+		log.Fatalln(err)
+	}
 }
 
-func parseUnits(rows *sql.Rows) ([]Unit, error) {
-	units := make([]Unit, 0)
-	for rows.Next() {
-		var unit_title string
-		var published bool
-		var rotate_image_id int
-		var user_id int
-		var color_scheme int
-		var front_image int
-		var unit_id int
-		var pages_arr, images_arr, cites_arr string
-
-		err := rows.Scan(&unit_title, &published, &rotate_image_id, &user_id, &color_scheme, &unit_id, &front_image, &pages_arr, &images_arr, &cites_arr)
+func seedGroups() error {
+	stmt, err := db.Prepare("INSERT INTO groups (group_name) SELECT $1::varchar WHERE NOT EXISTS (SELECT 1 FROM groups WHERE group_name=$1::varchar);")
+	if err != nil {
+		return err
+	}
+	for _, group := range builtinGroups {
+		_, err := stmt.Exec(group)
 		if err != nil {
-			return nil, err
-		}
-		var pages []int
-		if pages_arr == emptyArr {
-			pages = make([]int, 0)
-		} else {
-			err = json.Unmarshal([]byte(pages_arr), &pages)
-			if err != nil {
-				return nil, err
-			}
-		}
-		var images []int
-		if images_arr == emptyArr {
-			images = make([]int, 0)
-		} else {
-			err = json.Unmarshal([]byte(images_arr), &images)
-			if err != nil {
-				return nil, err
-			}
-		}
-		var cites []int
-		if cites_arr == emptyArr {
-			cites = make([]int, 0)
-		} else {
-			err = json.Unmarshal([]byte(cites_arr), &cites)
-			if err != nil {
-				return nil, err
-			}
+			return err
 		}
-		units = append(units, Unit{unit_title, rotate_image_id, pages, published, color_scheme, user_id, images, cites, front_image, unit_id})
 	}
-	return units, nil
+	return nil
 }
 
-func parseUnit(row *sql.Row) (Unit, error) {
-	var unit_title string
-	var published bool
-	var rotate_image_id int
-	var user_id int
-	var color_scheme int
-	var front_image int
-	var unit_id int
+//unitVisible is true for units which are published and inside their publication window. Use it instead of
+//units.published whenever visibility for the public is checked.
+const unitVisible = "(units.published AND (units.publish_at IS NULL OR units.publish_at <= now()) AND (units.unpublish_at IS NULL OR units.unpublish_at > now()))"
+
+//unitSelect selects everything scanUnit expects. Conditions are appended to it, followed by unitGroupBy.
+const unitSelect = `
+	SELECT units.unit_title, ` + unitVisible + `, units.rotate_image_id, units.user_id, units.color_scheme, units.unit_id, units.front_image, units.status, units.publish_at, units.unpublish_at, units.version, units.is_template,
+	COALESCE((SELECT json_agg(pages.page_id ORDER BY pages.position, pages.page_id) FROM pages WHERE pages.unit_id = units.unit_id), '[]') AS pages_arr,
+	json_agg(DISTINCT images.image_id) AS images_arr, json_agg(DISTINCT cites.cite_id) AS cites_arr
+	FROM units
+	LEFT OUTER JOIN images ON units.unit_id = images.unit_id
+	LEFT JOIN cites ON cites.unit_id = units.unit_id
+	`
+
+const unitGroupBy = " GROUP BY units.unit_id ORDER BY units.unit_id;"
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+//querier is the db or a transaction, so reads can be used inside and outside of transactions
+type querier interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+func parseIdArray(jsonArr string) ([]int, error) {
+	ids := make([]int, 0)
+	if jsonArr == emptyArr {
+		return ids, nil
+	}
+	if err := json.Unmarshal([]byte(jsonArr), &ids); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+func scanUnit(row scanner) (Unit, error) {
+	var unit Unit
 	var pages_arr, images_arr, cites_arr string
 
-	err := row.Scan(&unit_title, &published, &rotate_image_id, &user_id, &color_scheme, &unit_id, &front_image, &pages_arr, &images_arr, &cites_arr)
+	err := row.Scan(&unit.Title, &unit.Published, &unit.UnitImageID, &unit.UserId, &unit.ColorScheme, &unit.ID, &unit.FrontImage, &unit.Status, &unit.PublishAt, &unit.UnpublishAt, &unit.Version, &unit.IsTemplate, &pages_arr, &images_arr, &cites_arr)
 	if err != nil {
 		return Unit{}, err
 	}
-	var pages []int
-	if pages_arr == emptyArr {
-		pages = make([]int, 0)
-	} else {
-		err = json.Unmarshal([]byte(pages_arr), &pages)
-		if err != nil {
-			return Unit{}, err
-		}
+	if unit.PageIds, err = parseIdArray(pages_arr); err != nil {
+		return Unit{}, err
 	}
-	var images []int
-	if images_arr == emptyArr {
-		images = make([]int, 0)
-	} else {
-		err = json.Unmarshal([]byte(images_arr), &images)
-		if err != nil {
-			return Unit{}, err
-		}
+	if unit.ImageIds, err = parseIdArray(images_arr); err != nil {
+		return Unit{}, err
 	}
-	var cites []int
-	if cites_arr == emptyArr {
-		cites = make([]int, 0)
-	} else {
-		err = json.Unmarshal([]byte(cites_arr), &cites)
-		if err != nil {
-			return Unit{}, err
-		}
+	if unit.CiteIds, err = parseIdArray(cites_arr); err != nil {
+		return Unit{}, err
 	}
-	return Unit{unit_title, rotate_image_id, pages, published, color_scheme, user_id, images, cites, front_image, unit_id}, nil
+	return unit, nil
 }
 
-func GetUnit(unitId int) (Unit, error) {
-	row := db.QueryRow("SELECT units.*, json_agg(DISTINCT pages.page_id) AS pages_arr, json_agg(DISTINCT images.image_id) AS images_arr, json_agg(DISTINCT cites.cite_id) FROM units LEFT OUTER JOIN pages ON units.unit_id = pages.unit_id LEFT OUTER JOIN images ON units.unit_id=images.unit_id LEFT JOIN cites ON cites.unit_id=units.unit_id WHERE units.unit_id=$1 GROUP BY units.unit_id;", unitId)
-	return parseUnit(row)
+func parseUnits(rows *sql.Rows) ([]Unit, error) {
+	units := make([]Unit, 0)
+	for rows.Next() {
+		unit, err := scanUnit(rows)
+		if err != nil {
+			return nil, err
+		}
+		units = append(units, unit)
+	}
+	return units, rows.Err()
 }
 
-func GetAllUnits() ([]Unit, error) {
-	rows, err := db.Query("SELECT units.*, json_agg(DISTINCT pages.page_id) AS pages_arr, json_agg(DISTINCT images.image_id) AS images_arr, json_agg(DISTINCT cites.cite_id FROM units LEFT OUTER JOIN pages ON units.unit_id = pages.unit_id LEFT OUTER JOIN images ON units.unit_id=images.unit_id LEFT JOIN cites ON cites.unit_id=units.unit_id GROUP BY units.unit_id;")
-	defer rows.Close()
+func queryUnits(condition string, args ...interface{}) ([]Unit, error) {
+	rows, err := db.Query(unitSelect+condition+unitGroupBy, args...)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 	return parseUnits(rows)
 }
 
-/*
-func GetUserUnits(userId int) ([]Unit, error) {
-	rows, err := db.Query("SELECT units.*, json_agg(pages.page_id) AS pages_arr FROM units LEFT OUTER JOIN pages ON units.unit_id = pages.unit_id WHERE units.user_id=$1 GROUP BY units.unit_id;", userId)
-defer rows.Close()
-	if err != nil {
-		return nil, err
-	}
-	return parseUnits(rows)
+func GetUnit(unitId int) (Unit, error) {
+	return scanUnit(db.QueryRow(unitSelect+"WHERE units.unit_id=$1"+unitGroupBy, unitId))
 }
-*/
+
+func GetAllUnits() ([]Unit, error) {
+	return queryUnits("")
+}
+
 func GetUnPublishedUnits() ([]Unit, error) {
-	rows, err := db.Query("SELECT units.*, json_agg(DISTINCT pages.page_id) AS pages_arr, json_agg(DISTINCT images.image_id), json_agg(DISTINCT cites.cite_id)  FROM units LEFT OUTER JOIN pages ON units.unit_id = pages.unit_id LEFT OUTER JOIN images ON units.unit_id=images.unit_id LEFT JOIN cites ON cites.unit_id=units.unit_id WHERE units.published=false GROUP BY units.unit_id;")
-	defer rows.Close()
+	return queryUnits("WHERE NOT " + unitVisible)
+}
+
+func GetUnPublishedMemberUnits(userId int) ([]Unit, error) {
+	return queryUnits("WHERE NOT "+unitVisible+" AND units.unit_id IN (SELECT unit_id FROM unit_members WHERE user_id=$1)", userId)
+}
+
+func GetPublishedUnits() ([]Unit, error) {
+	return queryUnits("WHERE " + unitVisible)
+}
+
+func GetTemplateUnits() ([]Unit, error) {
+	return queryUnits("WHERE units.is_template")
+}
+
+func GetUnitsByStatus(status string) ([]Unit, error) {
+	return queryUnits("WHERE units.status = $1", status)
+}
+
+func GetMemberUnitsByStatus(userId int, status string) ([]Unit, error) {
+	return queryUnits("WHERE units.status = $1 AND units.unit_id IN (SELECT unit_id FROM unit_members WHERE user_id=$2)", status, userId)
+}
+
+func GetUnitImageIds(unitId int) ([]int, error) {
+	rows, err := db.Query("SELECT image_id FROM images WHERE unit_id=$1 ORDER BY image_id;", unitId)
 	if err != nil {
 		return nil, err
 	}
-	return parseUnits(rows)
+	defer rows.Close()
+	imageIds := make([]int, 0)
+	for rows.Next() {
+		var imageId int
+		if err := rows.Scan(&imageId); err != nil {
+			return nil, err
+		}
+		imageIds = append(imageIds, imageId)
+	}
+	return imageIds, rows.Err()
 }
 
-func GetPublishedUnits() ([]Unit, error) {
-	rows, err := db.Query("SELECT units.*, json_agg(DISTINCT pages.page_id) AS pages_arr, json_agg(DISTINCT images.image_id), json_agg(DISTINCT cites.cite_id)  FROM units LEFT OUTER JOIN pages ON units.unit_id = pages.unit_id LEFT OUTER JOIN images ON units.unit_id=images.unit_id LEFT JOIN cites ON cites.unit_id=units.unit_id WHERE units.published=true GROUP BY units.unit_id;")
-	defer rows.Close()
+//SetRowOrder moves the rows of the page to the positions given by rowIds if version is still the current version
+//of the page. It returns the new version of the page.
+func SetRowOrder(pageId, version int, rowIds []int, revision UnitRevision) (int, error) {
+	return setOrder("pages", "page_id", "rows", "row_id", pageId, version, rowIds, revision)
+}
+
+//SetPageOrder moves the pages of the unit to the positions given by pageIds if version is still the current
+//version of the unit. It returns the new version of the unit.
+func SetPageOrder(unitId, version int, pageIds []int, revision UnitRevision) (int, error) {
+	return setOrder("units", "unit_id", "pages", "page_id", unitId, version, pageIds, revision)
+}
+
+//setOrder increments the version of the parent and sets the position of each child to its index in ids in one
+//transaction. ids must contain every child of the parent exactly once.
+func setOrder(parentTable, parentColumn, childTable, childColumn string, parentId, version int, ids []int, revision UnitRevision) (int, error) {
+	tx, err := db.Begin()
 	if err != nil {
-		return nil, err
+		return -1, err
 	}
-	return parseUnits(rows)
+	defer tx.Rollback()
+	query := fmt.Sprintf("UPDATE %[1]s SET version=version+1 WHERE %[2]s=$1 AND version=$2 RETURNING version;", parentTable, parentColumn)
+	if err := tx.QueryRow(query, parentId, version).Scan(&version); err == sql.ErrNoRows {
+		return -1, errVersionConflict
+	} else if err != nil {
+		return -1, err
+	}
+	current, err := queryIds(tx, fmt.Sprintf("SELECT %[1]s FROM %[2]s WHERE %[3]s=$1;", childColumn, childTable, parentColumn), parentId)
+	if err != nil {
+		return -1, err
+	}
+	if len(current) != len(ids) {
+		return -1, errInvalidOrder
+	}
+	position := make(map[int]int)
+	for i, id := range ids {
+		position[id] = i
+	}
+	for _, id := range current {
+		if _, ok := position[id]; !ok {
+			return -1, errInvalidOrder
+		}
+	}
+	query = fmt.Sprintf("UPDATE %[1]s SET position=$1 WHERE %[2]s=$2;", childTable, childColumn)
+	for id, i := range position {
+		if _, err := tx.Exec(query, i, id); err != nil {
+			return -1, err
+		}
+	}
+	if err := insertUnitRevision(tx, revision); err != nil {
+		return -1, err
+	}
+	return version, tx.Commit()
 }
 
-func GetPageOwner(pageId int) (int, error) {
-	query := `
-		SELECT units.user_id FROM units
-		JOIN pages ON pages.unit_id = units.unit_id
-		WHERE pages.page_id = $1;
-		`
-	row := db.QueryRow(query, pageId)
-	var userId int
-	err := row.Scan(&userId)
+//nextPagePosition puts a new page at the end of the unit $3. The unit row has to be locked first, otherwise
+//two pages inserted at the same time get the same position.
+const nextPagePosition = "(SELECT COALESCE(max(position) + 1, 0) FROM pages WHERE unit_id=$3)"
+
+func GetPageUnitId(pageId int) (int, error) {
+	var unitId int
+	err := db.QueryRow("SELECT unit_id FROM pages WHERE page_id=$1;", pageId).Scan(&unitId)
 	if err != nil {
 		return -1, err
 	}
-	return userId, nil
+	return unitId, nil
+}
+
+//deleteRows deletes the rows matching condition together with the decisions of the students on them, they
+//would count as answers to rows which no longer exist. Every deletion of rows goes through here.
+func deleteRows(tx *sql.Tx, condition string, args ...interface{}) error {
+	for _, table := range []string{"row_results", "row_result_history", "clicked_arguments"} {
+		if _, err := tx.Exec("DELETE FROM "+table+" WHERE row_id IN (SELECT row_id FROM rows WHERE "+condition+");", args...); err != nil {
+			return err
+		}
+	}
+	_, err := tx.Exec("DELETE FROM rows WHERE "+condition+";", args...)
+	return err
 }
 
-func DbUpdatePage(page Page) (Page, error) {
-	stmt, err := db.Prepare("UPDATE pages SET page_title=$1, page_type=$2 WHERE page_id=$3;")
+//DbUpdatePage replaces the content of the page in one transaction if page.Version is still the current
+//version and increments it. Rows missing from page.Rows are deleted together with the results of the
+//students, rows without a known id are inserted, and all rows are stored in the given order. It returns the
+//saved page as read back from the database.
+func DbUpdatePage(page Page, revision UnitRevision) (Page, error) {
+	tx, err := db.Begin()
 	if err != nil {
 		return Page{}, err
 	}
-	_, err = stmt.Exec(page.Title, page.PageType, page.ID)
+	defer tx.Rollback()
+	err = tx.QueryRow("UPDATE pages SET page_title=$1, page_type=$2, version=version+1 WHERE page_id=$3 AND version=$4 RETURNING version;", page.Title, page.PageType, page.ID, page.Version).Scan(&page.Version)
+	if err == sql.ErrNoRows {
+		return Page{}, errVersionConflict
+	} else if err != nil {
+		return Page{}, err
+	}
+	keep := make(map[int]bool)
+	for _, row := range page.Rows {
+		keep[row.ID] = true
+	}
+	rowIds, err := queryIds(tx, "SELECT row_id FROM rows WHERE page_id=$1;", page.ID)
 	if err != nil {
 		return Page{}, err
 	}
-	stmt, err = db.Prepare("UPDATE rows SET left_markdown=$1, right_markdown=$2, left_has_image=$3, right_has_image=$4, leftimage=$5, rightimage=$6, left_is_argument=$7, right_is_argument=$8 WHERE row_id=$9 RETURNING row_id;")
+	for _, rowId := range rowIds {
+		if keep[rowId] {
+			continue
+		}
+		if err := deleteRows(tx, "row_id=$1", rowId); err != nil {
+			return Page{}, err
+		}
+	}
+	stmt, err := tx.Prepare("UPDATE rows SET left_markdown=$1, right_markdown=$2, left_has_image=$3, right_has_image=$4, leftimage=$5, rightimage=$6, left_is_argument=$7, right_is_argument=$8, position=$9, version=version+1 WHERE row_id=$10 AND page_id=$11;")
 	if err != nil {
 		return Page{}, err
 	}
-	insStmt, err := db.Prepare("INSERT INTO rows (left_markdown, right_markdown, left_has_image, right_has_image, leftimage, rightimage, left_is_argument, right_is_argument, page_id) VALUES ($1, $2, $3, $4, $5 ,$6, $7, $8, $9) RETURNING row_id;")
+	insStmt, err := tx.Prepare("INSERT INTO rows (left_markdown, right_markdown, left_has_image, right_has_image, leftimage, rightimage, left_is_argument, right_is_argument, position, page_id) VALUES ($1, $2, $3, $4, $5 ,$6, $7, $8, $9, $10);")
 	if err != nil {
 		return Page{}, err
 	}
 	for idx, row := range page.Rows {
-		dbRows, err := stmt.Query(row.LeftMarkdown, row.RightMarkdown, row.LeftHasImage, row.RightHasImage, row.LeftImage, row.RightImage, row.LeftIsArgument, row.RightIsArgument, row.ID)
+		result, err := stmt.Exec(row.LeftMarkdown, row.RightMarkdown, row.LeftHasImage, row.RightHasImage, row.LeftImage, row.RightImage, row.LeftIsArgument, row.RightIsArgument, idx, row.ID, page.ID)
 		if err != nil {
 			return Page{}, err
 		}
-		if !dbRows.Next() {
-			var rowId int
-			dbRow := insStmt.QueryRow(row.LeftMarkdown, row.RightMarkdown, row.LeftHasImage, row.RightHasImage, row.LeftImage, row.RightImage, row.LeftIsArgument, row.RightIsArgument, page.ID)
-			dbRow.Scan(&rowId)
-			if err != nil {
-				return Page{}, err
-			}
-			log.Println("got new id for row", rowId)
-			page.Rows[idx].ID = rowId
+		if affected, err := result.RowsAffected(); err != nil {
+			return Page{}, err
+		} else if affected > 0 {
+			continue
+		}
+		_, err = insStmt.Exec(row.LeftMarkdown, row.RightMarkdown, row.LeftHasImage, row.RightHasImage, row.LeftImage, row.RightImage, row.LeftIsArgument, row.RightIsArgument, idx, page.ID)
+		if err != nil {
+			return Page{}, err
 		}
-		dbRows.Close()
 	}
-	return page, nil
+	if _, err := setPageQuestions(tx, page.ID, page.Questions); err != nil {
+		return Page{}, err
+	}
+	if err := insertUnitRevision(tx, revision); err != nil {
+		return Page{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return Page{}, err
+	}
+	return GetPageById(page.ID)
 }
 
-func parsePage(row *sql.Row) (Page, error) {
-	var published bool
-	var pageTitle, page_type, jsonRows string
-	var pageId, unitId, userId int
-	if err := row.Scan(&published, &userId, &pageTitle, &pageId, &unitId, &page_type, &jsonRows); err != nil {
-		return Page{}, err
+//questionSelect selects everything scanQuestion expects
+const questionSelect = `SELECT prompt, options, correct, accepted, question_id, shuffle_seed FROM page_questions `
+
+const questionOrder = " ORDER BY position, question_id"
+
+func scanQuestion(row scanner) (Question, error) {
+	var question Question
+	var options, correct, accepted string
+	if err := row.Scan(&question.Prompt, &options, &correct, &accepted, &question.Id, &question.seed); err != nil {
+		return Question{}, err
 	}
-	var rows []Row
-	if jsonRows == emptyArr {
-		rows = make([]Row, 0)
-	} else {
-		if err := json.Unmarshal([]byte(jsonRows), &rows); err != nil {
-			return Page{}, err
-		}
+	if err := json.Unmarshal([]byte(options), &question.Options); err != nil {
+		return Question{}, err
+	}
+	if err := json.Unmarshal([]byte(correct), &question.Correct); err != nil {
+		return Question{}, err
 	}
-	return Page{pageTitle, rows, unitId, page_type, pageId, userId, published, 0}, nil
+	if err := json.Unmarshal([]byte(accepted), &question.Accepted); err != nil {
+		return Question{}, err
+	}
+	return question, nil
 }
 
-/*
-func GetPublicPageById(id int) (Page, error) {
-	query := `
-		SELECT pages.page_title, pages.page_id, pages.unit_id, pages.page_type, json_agg(rows.*) AS rows FROM pages
-		LEFT JOIN rows ON rows.page_id = pages.page_id
-		RIGHT JOIN units ON units.unit_id = pages.unit_id
-		WHERE pages.page_id=$1 AND units.published = true
-		GROUP BY pages.page_id;
-		`
-	row := db.QueryRow(query, id)
-	return parsePage(row)
+//GetPageQuestions returns the questions of a quiz page including their solutions
+func GetPageQuestions(pageId int) ([]Question, error) {
+	return queryQuestions(db, pageId)
 }
-*/
-func GetPageById(id int) (Page, error) {
+
+func queryQuestions(q querier, pageId int) ([]Question, error) {
+	rows, err := q.Query(questionSelect+"WHERE page_id=$1"+questionOrder+";", pageId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	questions := make([]Question, 0)
+	for rows.Next() {
+		question, err := scanQuestion(rows)
+		if err != nil {
+			return nil, err
+		}
+		questions = append(questions, question)
+	}
+	return questions, rows.Err()
+}
+
+//jsonArray encodes a slice for a jsonb column, nil is stored as an empty array
+func jsonArray(slice interface{}) (string, error) {
+	encoded, err := json.Marshal(slice)
+	if err != nil {
+		return "", err
+	}
+	if string(encoded) == "null" {
+		return "[]", nil
+	}
+	return string(encoded), nil
+}
+
+//setPageQuestions replaces the questions of the page in the given order. Questions with a known id are updated,
+//all others are inserted with a new id. It returns the questions with their ids.
+func setPageQuestions(tx *sql.Tx, pageId int, questions []Question) ([]Question, error) {
+	keep := make(map[int]bool)
+	for _, question := range questions {
+		keep[question.Id] = true
+	}
+	questionIds, err := queryIds(tx, "SELECT question_id FROM page_questions WHERE page_id=$1;", pageId)
+	if err != nil {
+		return nil, err
+	}
+	for _, questionId := range questionIds {
+		if keep[questionId] {
+			continue
+		}
+		if _, err := tx.Exec("DELETE FROM page_questions WHERE question_id=$1;", questionId); err != nil {
+			return nil, err
+		}
+	}
+	for i, question := range questions {
+		options, err := jsonArray(question.Options)
+		if err != nil {
+			return nil, err
+		}
+		correct, err := jsonArray(question.Correct)
+		if err != nil {
+			return nil, err
+		}
+		accepted, err := jsonArray(question.Accepted)
+		if err != nil {
+			return nil, err
+		}
+		result, err := tx.Exec("UPDATE page_questions SET prompt=$1, options=$2, correct=$3, accepted=$4, position=$5 WHERE question_id=$6 AND page_id=$7;",
+			question.Prompt, options, correct, accepted, i, question.Id, pageId)
+		if err != nil {
+			return nil, err
+		}
+		if affected, err := result.RowsAffected(); err != nil {
+			return nil, err
+		} else if affected > 0 {
+			continue
+		}
+		err = tx.QueryRow("INSERT INTO page_questions (prompt, options, correct, accepted, page_id, position) VALUES ($1, $2, $3, $4, $5, $6) RETURNING question_id;",
+			question.Prompt, options, correct, accepted, pageId, i).Scan(&questions[i].Id)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return questions, nil
+}
+
+func parsePage(row *sql.Row) (Page, error) {
+	var published bool
+	var pageTitle, page_type, jsonRows string
+	var pageId, unitId, userId, version, position int
+	if err := row.Scan(&published, &userId, &pageTitle, &pageId, &unitId, &page_type, &jsonRows, &version, &position); err != nil {
+		return Page{}, err
+	}
+	var rows []Row
+	if jsonRows == emptyArr {
+		rows = make([]Row, 0)
+	} else {
+		if err := json.Unmarshal([]byte(jsonRows), &rows); err != nil {
+			return Page{}, err
+		}
+	}
+	page := Page{pageTitle, rows, unitId, page_type, pageId, userId, published, 0, version, position, nil}
+	if isQuizPage(page_type) {
+		var err error
+		if page.Questions, err = GetPageQuestions(pageId); err != nil {
+			return Page{}, err
+		}
+	}
+	return page, nil
+}
+
+/*
+func GetPublicPageById(id int) (Page, error) {
+	query := `
+		SELECT pages.page_title, pages.page_id, pages.unit_id, pages.page_type, json_agg(rows.*) AS rows FROM pages
+		LEFT JOIN rows ON rows.page_id = pages.page_id
+		RIGHT JOIN units ON units.unit_id = pages.unit_id
+		WHERE pages.page_id=$1 AND units.published = true
+		GROUP BY pages.page_id;
+		`
+	row := db.QueryRow(query, id)
+	return parsePage(row)
+}
+*/
+func GetPageById(id int) (Page, error) {
 	query := `
-		SELECT units.published, units.user_id, pages.page_title, pages.page_id, pages.unit_id, pages.page_type, json_agg(rows.* ORDER BY rows.row_id) AS rows FROM pages 
+		SELECT ` + unitVisible + `, units.user_id, pages.page_title, pages.page_id, pages.unit_id, pages.page_type, json_agg(rows.* ORDER BY rows.position, rows.row_id) AS rows, pages.version, COALESCE(pages.position, 0) FROM pages 
 		LEFT JOIN rows ON rows.page_id = pages.page_id
 		RIGHT JOIN units ON units.unit_id = pages.unit_id
 		WHERE pages.page_id=$1
@@ -382,7 +880,7 @@ func GetPageById(id int) (Page, error) {
 
 func GetPageWithResultsById(pageId, userId int) (Page, error) {
 	query := `
-		SELECT units.published, units.user_id, pages.page_title, pages.unit_id, pages.page_type, json_agg(rows.* ORDER BY rows.row_id) AS rows, page_results.page_result_id FROM pages 
+		SELECT ` + unitVisible + `, units.user_id, pages.page_title, pages.unit_id, pages.page_type, json_agg(rows.* ORDER BY rows.position, rows.row_id) AS rows, page_results.page_result_id, pages.version, COALESCE(pages.position, 0) FROM pages 
 		LEFT JOIN rows ON rows.page_id = pages.page_id
 		LEFT JOIN page_results ON page_results.page_id = pages.page_id AND page_results.user_id=$2
 		RIGHT JOIN units ON units.unit_id = pages.unit_id
@@ -392,9 +890,9 @@ func GetPageWithResultsById(pageId, userId int) (Page, error) {
 	row := db.QueryRow(query, pageId, userId)
 	var published bool
 	var pageTitle, page_type, jsonRows string
-	var unitId, pageUserId int
+	var unitId, pageUserId, version, position int
 	var pageResultId sql.NullInt64
-	if err := row.Scan(&published, &pageUserId, &pageTitle, &unitId, &page_type, &jsonRows, &pageResultId); err != nil {
+	if err := row.Scan(&published, &pageUserId, &pageTitle, &unitId, &page_type, &jsonRows, &pageResultId, &version, &position); err != nil {
 		return Page{}, err
 	}
 	var pageResultIdVal int
@@ -409,7 +907,14 @@ func GetPageWithResultsById(pageId, userId int) (Page, error) {
 			return Page{}, err
 		}
 	}
-	return Page{pageTitle, rows, unitId, page_type, pageId, pageUserId, published, pageResultIdVal}, nil
+	page := Page{pageTitle, rows, unitId, page_type, pageId, pageUserId, published, pageResultIdVal, version, position, nil}
+	if isQuizPage(page_type) {
+		var err error
+		if page.Questions, err = GetPageQuestions(pageId); err != nil {
+			return Page{}, err
+		}
+	}
+	return page, nil
 }
 
 /*
@@ -425,686 +930,2999 @@ func GetUserPageById(pageId, userId int) (Page, error) {
 	return parsePage(row)
 }
 */
-func InsertUnit(unit Unit) (int, error) {
-	log.Println(unit.Title)
-	row := db.QueryRow("INSERT INTO units (unit_title, published, rotate_image_id, user_id, color_scheme, front_image) VALUES ($1, $2, $3, $4, $5, $6) RETURNING units.unit_id", unit.Title, unit.Published, unit.UnitImageID, unit.UserId, unit.ColorScheme, unit.FrontImage)
-	var id int
-	err := row.Scan(&id)
+func InsertUnit(unit Unit, revision UnitRevision) (int, error) {
+	tx, err := db.Begin()
 	if err != nil {
 		return 0, err
 	}
-	return id, nil
-}
-
-func UpdateUnitAdmin(unit Unit) error {
-	stmt, err := db.Prepare("UPDATE units SET unit_title=$1, published=$2, rotate_image_id=$3, color_scheme=$4, front_image=$5 WHERE unit_id=$6;")
+	defer tx.Rollback()
+	row := tx.QueryRow("INSERT INTO units (unit_title, published, status, rotate_image_id, user_id, color_scheme, front_image, is_template) VALUES ($1, false, $2, $3, $4, $5, $6, $7) RETURNING units.unit_id", unit.Title, statusDraft, unit.UnitImageID, unit.UserId, unit.ColorScheme, unit.FrontImage, unit.IsTemplate)
+	var id int
+	err = row.Scan(&id)
 	if err != nil {
-		return err
+		return 0, err
 	}
-	_, err = stmt.Exec(unit.Title, unit.Published, unit.UnitImageID, unit.ColorScheme, unit.FrontImage, unit.ID)
+	_, err = tx.Exec("INSERT INTO unit_members (unit_id, user_id, role) VALUES ($1, $2, $3);", id, unit.UserId, roleOwner)
 	if err != nil {
-		return err
+		return 0, err
 	}
-	return nil
+	revision.UnitId = id
+	if err := insertUnitRevision(tx, revision); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return id, nil
 }
 
-func UpdateUnitUser(unit Unit) error {
-	stmt, err := db.Prepare("UPDATE units SET unit_title=$1, rotate_image_id=$2, color_scheme=$3, front_image=$4 WHERE unit_id=$5;")
+//UpdateUnitUser saves the unit if unit.Version is still the current version and returns the new version. The
+//template flag is only changed if template is not nil.
+func UpdateUnitUser(unit Unit, template *bool, revision UnitRevision) (int, error) {
+	tx, err := db.Begin()
 	if err != nil {
-		return err
+		return 0, err
 	}
-	_, err = stmt.Exec(unit.Title, unit.UnitImageID, unit.ColorScheme, unit.FrontImage, unit.ID)
-	if err != nil {
-		return err
+	defer tx.Rollback()
+	var version int
+	err = tx.QueryRow("UPDATE units SET unit_title=$1, rotate_image_id=$2, color_scheme=$3, front_image=$4, is_template=COALESCE($5, is_template), version=version+1 WHERE unit_id=$6 AND version=$7 RETURNING version;",
+		unit.Title, unit.UnitImageID, unit.ColorScheme, unit.FrontImage, template, unit.ID, unit.Version).Scan(&version)
+	if err == sql.ErrNoRows {
+		return 0, errVersionConflict
+	} else if err != nil {
+		return 0, err
 	}
-	return nil
+	if err := insertUnitRevision(tx, revision); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return version, nil
 }
 
-func UpdateErrorImage(errorImage ErrorImage) (ErrorImage, error) {
-	stmt, err := db.Prepare("UPDATE error_images SET correct_image_id=$1, scale=$2, published=$3 WHERE error_image_id=$4;")
+//DbCloneUnit copies the unit with its pages, rows, cites, images and rotate image into a new draft owned by
+//userId. Image references are changed to the copies. copyFiles copies the files of the images before the
+//transaction is committed, their new paths are saved with the clone, so either everything is copied or nothing.
+func DbCloneUnit(unitId, userId int, options UnitCloneOptions, copyFiles func(unitCopy) (clonedFiles, error), revision UnitRevision) (unitCopy, error) {
+	tx, err := db.Begin()
 	if err != nil {
-		return ErrorImage{}, err
+		return unitCopy{}, err
 	}
-	_, err = stmt.Exec(errorImage.CorrectImageId, errorImage.Scale, errorImage.Published, errorImage.ID)
+	defer tx.Rollback()
+	var title string
+	var rotateImageId, colorScheme, frontImage int
+	err = tx.QueryRow("SELECT unit_title, rotate_image_id, color_scheme, front_image FROM units WHERE unit_id=$1;", unitId).Scan(&title, &rotateImageId, &colorScheme, &frontImage)
 	if err != nil {
-		return ErrorImage{}, err
+		return unitCopy{}, err
 	}
-	stmt, err = db.Prepare("DELETE FROM error_circles WHERE error_image_id=$1;")
-	if err != nil {
-		return ErrorImage{}, err
+	if len(options.Title) > 0 {
+		title = options.Title
 	}
-	_, err = stmt.Exec(errorImage.ID)
+	clone := unitCopy{Images: make(map[int]int), RotateImages: make(map[int]int)}
+	newRotateImageId := rotateImageId
+	if rotateImageId > 0 {
+		query := "INSERT INTO rotate_images (num, caption, credits) SELECT num, caption, credits FROM rotate_images WHERE rotate_image_id=$1 RETURNING rotate_image_id;"
+		err := tx.QueryRow(query, rotateImageId).Scan(&newRotateImageId)
+		if err != nil && err != sql.ErrNoRows {
+			return unitCopy{}, err
+		} else if err == nil {
+			clone.RotateImages[rotateImageId] = newRotateImageId
+		}
+	}
+	query := "INSERT INTO units (unit_title, published, status, rotate_image_id, user_id, color_scheme, front_image, is_template) VALUES ($1, false, $2, $3, $4, $5, $6, $7) RETURNING unit_id;"
+	err = tx.QueryRow(query, title, statusDraft, newRotateImageId, userId, colorScheme, frontImage, options.Template).Scan(&clone.UnitId)
 	if err != nil {
-		return ErrorImage{}, err
+		return unitCopy{}, err
 	}
-	query := "INSERT INTO error_circles (radius, centerX, centerY, error_image_id) VALUES ($1,$2,$3,$4) RETURNING error_circle_id;"
+	if _, err := tx.Exec("INSERT INTO unit_members (unit_id, user_id, role) VALUES ($1, $2, $3);", clone.UnitId, userId, roleOwner); err != nil {
+		return unitCopy{}, err
+	}
+
+	imageIds, err := queryIds(tx, "SELECT image_id FROM images WHERE unit_id=$1 ORDER BY image_id;", unitId)
 	if err != nil {
-		return ErrorImage{}, err
+		return unitCopy{}, err
 	}
-	for idx, circle := range errorImage.ErrorCircles {
+	for _, imageId := range imageIds {
 		var newId int
-		err := db.QueryRow(query, circle.Radius, circle.CenterX, circle.CenterY, errorImage.ID).Scan(&newId)
-		if err != nil {
-			return ErrorImage{}, err
+		query := "INSERT INTO images (caption, credits, unit_id, age_known, age, imprecision) SELECT caption, credits, $2, age_known, age, imprecision FROM images WHERE image_id=$1 RETURNING image_id;"
+		if err := tx.QueryRow(query, imageId, clone.UnitId).Scan(&newId); err != nil {
+			return unitCopy{}, err
+		}
+		clone.Images[imageId] = newId
+	}
+	if newId, ok := clone.Images[frontImage]; ok {
+		if _, err := tx.Exec("UPDATE units SET front_image=$1 WHERE unit_id=$2;", newId, clone.UnitId); err != nil {
+			return unitCopy{}, err
 		}
-		errorImage.ErrorCircles[idx].ID = newId
 	}
-	return errorImage, nil
-}
 
-func UpdateImageUser(image Image) error {
-	stmt, err := db.Prepare("UPDATE images SET caption=$1, credits=$2, age_known=$3, age=$4, imprecision=$5 WHERE image_id=$6;")
+	pageIds, err := queryIds(tx, "SELECT page_id FROM pages WHERE unit_id=$1 ORDER BY position, page_id;", unitId)
 	if err != nil {
-		return err
+		return unitCopy{}, err
 	}
-	_, err = stmt.Exec(image.Caption, image.Credits, image.AgeKnown, image.Age, image.Imprecision, image.ID)
-	if err != nil {
-		return err
+	for _, pageId := range pageIds {
+		var newPageId int
+		query := "INSERT INTO pages (page_title, page_type, unit_id, position) SELECT page_title, page_type, $2, position FROM pages WHERE page_id=$1 RETURNING page_id;"
+		if err := tx.QueryRow(query, pageId, clone.UnitId).Scan(&newPageId); err != nil {
+			return unitCopy{}, err
+		}
+		rows, err := queryRows(tx, pageId)
+		if err != nil {
+			return unitCopy{}, err
+		}
+		query = "INSERT INTO rows (left_markdown, right_markdown, left_has_image, right_has_image, leftimage, rightimage, left_is_argument, right_is_argument, page_id, position) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10);"
+		for _, row := range rows {
+			if newId, ok := clone.Images[row.LeftImage]; ok {
+				row.LeftImage = newId
+			}
+			if newId, ok := clone.Images[row.RightImage]; ok {
+				row.RightImage = newId
+			}
+			_, err := tx.Exec(query, row.LeftMarkdown, row.RightMarkdown, row.LeftHasImage, row.RightHasImage, row.LeftImage, row.RightImage, row.LeftIsArgument, row.RightIsArgument, newPageId, row.Position)
+			if err != nil {
+				return unitCopy{}, err
+			}
+		}
+		query = "INSERT INTO page_questions (prompt, options, correct, accepted, page_id, position) SELECT prompt, options, correct, accepted, $2, position FROM page_questions WHERE page_id=$1" + questionOrder + ";"
+		if _, err := tx.Exec(query, pageId, newPageId); err != nil {
+			return unitCopy{}, err
+		}
 	}
-	return nil
-}
 
-func UpdateImagePath(imageId int, imagePath string) error {
-	stmt, err := db.Prepare("UPDATE images SET path=$1 WHERE image_id=$2")
-	if err != nil {
-		return err
+	if _, err := tx.Exec("INSERT INTO cites (abbrev, cite_text, unit_id, cite_type, authors, title, year, publisher, url, doi) SELECT abbrev, cite_text, $2, cite_type, authors, title, year, publisher, url, doi FROM cites WHERE unit_id=$1 ORDER BY cite_id;", unitId, clone.UnitId); err != nil {
+		return unitCopy{}, err
 	}
-	_, err = stmt.Exec(imagePath, imageId)
+	files, err := copyFiles(clone)
 	if err != nil {
-		return err
+		return unitCopy{}, err
 	}
-	return nil
+	for imageId, imagePath := range files.Images {
+		if _, err := tx.Exec("UPDATE images SET path=$1 WHERE image_id=$2;", imagePath, imageId); err != nil {
+			return unitCopy{}, err
+		}
+	}
+	for imageId, imageDir := range files.RotateImages {
+		if _, err := tx.Exec("UPDATE rotate_images SET basepath=$1 WHERE rotate_image_id=$2;", imageDir, imageId); err != nil {
+			return unitCopy{}, err
+		}
+	}
+	revision.UnitId = clone.UnitId
+	if err := insertUnitRevision(tx, revision); err != nil {
+		return unitCopy{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return unitCopy{}, err
+	}
+	return clone, nil
 }
 
-func GetRotateImagePublishedAndPath(imageId int) (bool, string, error) {
-	query := `
-		SELECT units.published, rotate_images.basepath FROM units
-		JOIN rotate_images ON rotate_images.rotate_image_id = units.rotate_image_id
-		WHERE rotate_images.rotate_image_id = $1;
-		`
-	row := db.QueryRow(query, imageId)
-	var published bool
-	var path string
-	err := row.Scan(&published, &path)
+func queryRows(tx *sql.Tx, pageId int) ([]Row, error) {
+	query := "SELECT left_markdown, right_markdown, left_has_image, right_has_image, leftimage, rightimage, left_is_argument, right_is_argument, row_id, version, COALESCE(position, 0) FROM rows WHERE page_id=$1 ORDER BY position, row_id;"
+	dbRows, err := tx.Query(query, pageId)
 	if err != nil {
-		return false, "", err
+		return nil, err
 	}
-	return published, path, nil
+	defer dbRows.Close()
+	rows := make([]Row, 0)
+	for dbRows.Next() {
+		var row Row
+		err := dbRows.Scan(&row.LeftMarkdown, &row.RightMarkdown, &row.LeftHasImage, &row.RightHasImage, &row.LeftImage, &row.RightImage, &row.LeftIsArgument, &row.RightIsArgument, &row.ID, &row.Version, &row.Position)
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, row)
+	}
+	return rows, dbRows.Err()
 }
 
-func GetImageById(imageId int) (Image, error) {
-	query := `
-		SELECT units.published, units.user_id, images.path, images.caption, images.credits, images.unit_id, images.age_known, images.age, images.imprecision FROM units
-		JOIN images ON images.unit_id = units.unit_id
-		WHERE images.image_id = $1;
-		`
-	row := db.QueryRow(query, imageId)
-	var published, ageKnown bool
-	var userId, unitId, age, imprecision int
-	var path, caption, credits string
-	var nullPath sql.NullString
-	err := row.Scan(&published, &userId, &nullPath, &caption, &credits, &unitId, &ageKnown, &age, &imprecision)
+//ChangeUnitStatus moves the unit from one status to another and records who did it. ok is false if the
+//unit is not in the from status (anymore).
+func ChangeUnitStatus(event UnitStatusEvent) (UnitStatusEvent, bool, error) {
+	tx, err := db.Begin()
 	if err != nil {
-		return Image{}, err
+		return UnitStatusEvent{}, false, err
 	}
-	if nullPath.Valid {
-		path = nullPath.String
+	defer tx.Rollback()
+	result, err := tx.Exec("UPDATE units SET status=$1, published=$2 WHERE unit_id=$3 AND status=$4;", event.To, event.To == statusPublished, event.UnitId, event.From)
+	if err != nil {
+		return UnitStatusEvent{}, false, err
 	}
-	return Image{path, caption, credits, unitId, userId, imageId, published, ageKnown, age, imprecision}, nil
-}
-
-func GetRotateImageById(imageId int) (RotateImage, error) {
-	query := `
-		SELECT units.published, units.user_id, units.unit_id, rotate_images.basepath, rotate_images.caption, rotate_images.credits, rotate_images.num FROM units
-		JOIN rotate_images ON rotate_images.rotate_image_id = units.rotate_image_id
-		WHERE rotate_images.rotate_image_id = $1;
-		`
-	row := db.QueryRow(query, imageId)
-	var published bool
-	var userId, unitId, num int
-	var path, caption, credits string
-	var nullPath sql.NullString
-	err := row.Scan(&published, &userId, &unitId, &nullPath, &caption, &credits, &num)
+	affected, err := result.RowsAffected()
 	if err != nil {
-		return RotateImage{}, err
+		return UnitStatusEvent{}, false, err
 	}
-	if nullPath.Valid {
-		path = nullPath.String
+	if affected != 1 {
+		return UnitStatusEvent{}, false, nil
 	}
-	return RotateImage{path, num, caption, credits, unitId, userId, imageId, published}, nil
-}
-
-func InsertImage(image Image) (int, error) {
-	query := "INSERT INTO images (caption, credits, unit_id, age_known, age, imprecision) VALUES ($1, $2, $3, $4, $5, $6) RETURNING image_id;"
-	var imageId int
-	err := db.QueryRow(query, image.Caption, image.Credits, image.UnitId, image.AgeKnown, image.Age, image.Imprecision).Scan(&imageId)
+	query := "INSERT INTO unit_status_events (unit_id, user_id, from_status, to_status, comment) VALUES ($1, NULLIF($2, 0), $3, $4, $5) RETURNING unit_status_event_id, created_at;"
+	err = tx.QueryRow(query, event.UnitId, event.UserId, event.From, event.To, event.Comment).Scan(&event.ID, &event.CreatedAt)
 	if err != nil {
-		return -1, err
+		return UnitStatusEvent{}, false, err
 	}
-	return int(imageId), nil
+	if err := tx.Commit(); err != nil {
+		return UnitStatusEvent{}, false, err
+	}
+	return event, true, nil
 }
 
-func InsertErrorImage(errorImage ErrorImage) (ErrorImage, error) {
-	query := "INSERT INTO error_images (path, correct_image_id, scale, user_id) VALUES ($1, $2, $3, $4) RETURNING error_image_id;"
-	var errorImageId int
-	err := db.QueryRow(query, errorImage.path, errorImage.CorrectImageId, errorImage.Scale, errorImage.UserId).Scan(&errorImageId)
+func DbUpdateUnitSchedule(unitId int, schedule UnitSchedule) error {
+	_, err := db.Exec("UPDATE units SET publish_at=$1, unpublish_at=$2 WHERE unit_id=$3;", schedule.PublishAt, schedule.UnpublishAt, unitId)
+	return err
+}
+
+//PublishScheduledUnits publishes all approved units whose publish_at has passed.
+func PublishScheduledUnits() ([]UnitStatusEvent, error) {
+	return applyUnitSchedule(statusApproved, statusPublished, "publish_at")
+}
+
+//ArchiveExpiredUnits archives all published units whose unpublish_at has passed.
+func ArchiveExpiredUnits() ([]UnitStatusEvent, error) {
+	return applyUnitSchedule(statusPublished, statusArchived, "unpublish_at")
+}
+
+//applyUnitSchedule moves all units in status from whose timestamp in column has passed to status to. The
+//timestamp is cleared so it does not fire again once the unit comes back to from. The events are recorded
+//without a user.
+func applyUnitSchedule(from, to, column string) ([]UnitStatusEvent, error) {
+	tx, err := db.Begin()
 	if err != nil {
-		return ErrorImage{}, err
+		return nil, err
 	}
-	errorImage.ID = errorImageId
-	query = "INSERT INTO error_circles (radius, centerX, centerY, error_image_id) VALUES ($1,$2,$3,$4) RETURNING error_circle_id;"
+	defer tx.Rollback()
+	query := fmt.Sprintf("UPDATE units SET status=$1, published=$2, %[1]s=NULL WHERE status=$3 AND %[1]s <= now() RETURNING unit_id;", column)
+	rows, err := tx.Query(query, to, to == statusPublished, from)
 	if err != nil {
-		return ErrorImage{}, err
+		return nil, err
 	}
-	for idx, circle := range errorImage.ErrorCircles {
-		var newId int
-		err := db.QueryRow(query, circle.Radius, circle.CenterX, circle.CenterY, errorImage.ID).Scan(&newId)
+	unitIds := make([]int, 0)
+	for rows.Next() {
+		var unitId int
+		if err := rows.Scan(&unitId); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		unitIds = append(unitIds, unitId)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	events := make([]UnitStatusEvent, 0)
+	for _, unitId := range unitIds {
+		event := UnitStatusEvent{UnitId: unitId, From: from, To: to, Comment: "scheduled"}
+		err := tx.QueryRow("INSERT INTO unit_status_events (unit_id, user_id, from_status, to_status, comment) VALUES ($1, NULL, $2, $3, $4) RETURNING unit_status_event_id, created_at;", unitId, from, to, event.Comment).Scan(&event.ID, &event.CreatedAt)
 		if err != nil {
-			return ErrorImage{}, err
+			return nil, err
 		}
-		errorImage.ErrorCircles[idx].ID = newId
+		events = append(events, event)
 	}
-	return errorImage, nil
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return events, nil
 }
 
-func UpdateErrorImagePath(id int, path string) error {
-	stmt, err := db.Prepare("UPDATE error_images SET path=$1 WHERE error_image_id=$2;")
+func GetUnitStatusEvents(unitId int) ([]UnitStatusEvent, error) {
+	query := `
+		SELECT unit_status_events.unit_id, COALESCE(unit_status_events.user_id, 0), COALESCE(users.username, ''), unit_status_events.from_status,
+		unit_status_events.to_status, COALESCE(unit_status_events.comment, ''), unit_status_events.created_at, unit_status_events.unit_status_event_id
+		FROM unit_status_events
+		LEFT JOIN users ON users.user_id = unit_status_events.user_id
+		WHERE unit_status_events.unit_id = $1
+		ORDER BY unit_status_events.created_at, unit_status_events.unit_status_event_id;
+		`
+	rows, err := db.Query(query, unitId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	events := make([]UnitStatusEvent, 0)
+	for rows.Next() {
+		var event UnitStatusEvent
+		err := rows.Scan(&event.UnitId, &event.UserId, &event.Username, &event.From, &event.To, &event.Comment, &event.CreatedAt, &event.ID)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+//getUnitSnapshot reads the current content of a unit including all pages and rows
+func getUnitSnapshot(q querier, unitId int) (UnitSnapshot, error) {
+	var snapshot UnitSnapshot
+	err := q.QueryRow("SELECT unit_title, rotate_image_id, color_scheme, front_image FROM units WHERE unit_id=$1;", unitId).Scan(&snapshot.Title, &snapshot.UnitImageID, &snapshot.ColorScheme, &snapshot.FrontImage)
+	if err != nil {
+		return UnitSnapshot{}, err
+	}
+	snapshot.Pages = make([]PageSnapshot, 0)
+	pageIdx := make(map[int]int)
+	pageRows, err := q.Query("SELECT page_title, page_type, page_id, COALESCE(position, 0) FROM pages WHERE unit_id=$1 ORDER BY position, page_id;", unitId)
+	if err != nil {
+		return UnitSnapshot{}, err
+	}
+	defer pageRows.Close()
+	for pageRows.Next() {
+		page := PageSnapshot{Rows: make([]Row, 0)}
+		if err := pageRows.Scan(&page.Title, &page.PageType, &page.ID, &page.Position); err != nil {
+			return UnitSnapshot{}, err
+		}
+		pageIdx[page.ID] = len(snapshot.Pages)
+		snapshot.Pages = append(snapshot.Pages, page)
+	}
+	if err := pageRows.Err(); err != nil {
+		return UnitSnapshot{}, err
+	}
+	query := `
+		SELECT rows.left_markdown, rows.right_markdown, rows.left_has_image, rows.right_has_image, rows.leftimage, rows.rightimage,
+		rows.left_is_argument, rows.right_is_argument, rows.row_id, COALESCE(rows.position, 0), rows.page_id FROM rows
+		JOIN pages ON pages.page_id = rows.page_id
+		WHERE pages.unit_id=$1
+		ORDER BY rows.position, rows.row_id;
+		`
+	rows, err := q.Query(query, unitId)
+	if err != nil {
+		return UnitSnapshot{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var row Row
+		var pageId int
+		err := rows.Scan(&row.LeftMarkdown, &row.RightMarkdown, &row.LeftHasImage, &row.RightHasImage, &row.LeftImage, &row.RightImage, &row.LeftIsArgument, &row.RightIsArgument, &row.ID, &row.Position, &pageId)
+		if err != nil {
+			return UnitSnapshot{}, err
+		}
+		idx := pageIdx[pageId]
+		snapshot.Pages[idx].Rows = append(snapshot.Pages[idx].Rows, row)
+	}
+	if err := rows.Err(); err != nil {
+		return UnitSnapshot{}, err
+	}
+	for i, page := range snapshot.Pages {
+		if !isQuizPage(page.PageType) {
+			continue
+		}
+		if snapshot.Pages[i].Questions, err = queryQuestions(q, page.ID); err != nil {
+			return UnitSnapshot{}, err
+		}
+	}
+	return snapshot, nil
+}
+
+//insertUnitRevision stores the content of revision.UnitId as a new revision. It is called in the transaction of
+//the edit, so the edit is only saved together with its revision.
+func insertUnitRevision(tx *sql.Tx, revision UnitRevision) error {
+	snapshot, err := getUnitSnapshot(tx, revision.UnitId)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	query := "INSERT INTO unit_revisions (unit_id, user_id, action, snapshot) VALUES ($1, NULLIF($2, 0), $3, $4);"
+	_, err = tx.Exec(query, revision.UnitId, revision.UserId, revision.Action, string(data))
+	return err
+}
+
+//migratePageTypes sets the type of pages whose type is not registered, e.g. the free text types of older
+//versions, to the default type, so they can be saved again. Like the migrations it can run on every start.
+func migratePageTypes() error {
+	names := make([]string, len(pageTypes))
+	for i, pageType := range pageTypes {
+		names[i] = pageType.Name
+	}
+	query := "UPDATE pages SET page_type=$1 WHERE page_type IS NULL OR NOT page_type = ANY(string_to_array($2, ','));"
+	_, err := db.Exec(query, pageTypeDefault, strings.Join(names, ","))
+	return err
+}
+
+//seedUnitRevisions stores a baseline revision for every unit without revisions, e.g. units created before
+//revisions were recorded, so their first edit can be reverted
+func seedUnitRevisions() error {
+	unitIds, err := queryIds(db, "SELECT unit_id FROM units WHERE NOT EXISTS (SELECT 1 FROM unit_revisions WHERE unit_revisions.unit_id = units.unit_id) ORDER BY unit_id;")
+	if err != nil {
+		return err
+	}
+	for _, unitId := range unitIds {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if err := insertUnitRevision(tx, UnitRevision{UnitId: unitId, Action: "baseline"}); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//GetUnitRevisions lists the revisions of a unit without their snapshots, newest first
+func GetUnitRevisions(unitId int) ([]UnitRevision, error) {
+	query := `
+		SELECT unit_revisions.unit_id, COALESCE(unit_revisions.user_id, 0), COALESCE(users.username, ''), unit_revisions.action,
+		unit_revisions.created_at, unit_revisions.unit_revision_id
+		FROM unit_revisions
+		LEFT JOIN users ON users.user_id = unit_revisions.user_id
+		WHERE unit_revisions.unit_id = $1
+		ORDER BY unit_revisions.unit_revision_id DESC;
+		`
+	rows, err := db.Query(query, unitId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	revisions := make([]UnitRevision, 0)
+	for rows.Next() {
+		var revision UnitRevision
+		err := rows.Scan(&revision.UnitId, &revision.UserId, &revision.Username, &revision.Action, &revision.CreatedAt, &revision.ID)
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, revision)
+	}
+	return revisions, rows.Err()
+}
+
+func GetUnitRevision(revisionId int) (UnitRevision, error) {
+	query := `
+		SELECT unit_revisions.unit_id, COALESCE(unit_revisions.user_id, 0), COALESCE(users.username, ''), unit_revisions.action,
+		unit_revisions.created_at, unit_revisions.unit_revision_id, unit_revisions.snapshot
+		FROM unit_revisions
+		LEFT JOIN users ON users.user_id = unit_revisions.user_id
+		WHERE unit_revisions.unit_revision_id = $1;
+		`
+	var revision UnitRevision
+	var data string
+	err := db.QueryRow(query, revisionId).Scan(&revision.UnitId, &revision.UserId, &revision.Username, &revision.Action, &revision.CreatedAt, &revision.ID, &data)
+	if err != nil {
+		return UnitRevision{}, err
+	}
+	var snapshot UnitSnapshot
+	if err := json.Unmarshal([]byte(data), &snapshot); err != nil {
+		return UnitRevision{}, err
+	}
+	revision.Snapshot = &snapshot
+	return revision, nil
+}
+
+//RestoreUnit sets the content of the unit back to the snapshot. Pages and rows which were deleted since are
+//inserted again with their old ids, pages and rows which were added since are deleted.
+func RestoreUnit(unitId int, snapshot UnitSnapshot, revision UnitRevision) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec("UPDATE units SET unit_title=$1, rotate_image_id=$2, color_scheme=$3, front_image=$4, version=version+1 WHERE unit_id=$5;", snapshot.Title, snapshot.UnitImageID, snapshot.ColorScheme, snapshot.FrontImage, unitId)
+	if err != nil {
+		return err
+	}
+	keep := make(map[int]bool)
+	for _, page := range snapshot.Pages {
+		keep[page.ID] = true
+	}
+	pageIds, err := queryIds(tx, "SELECT page_id FROM pages WHERE unit_id=$1;", unitId)
+	if err != nil {
+		return err
+	}
+	for _, pageId := range pageIds {
+		if keep[pageId] {
+			continue
+		}
+		if err := deleteRows(tx, "page_id=$1", pageId); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("DELETE FROM page_questions WHERE page_id=$1;", pageId); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("DELETE FROM pages WHERE page_id=$1;", pageId); err != nil {
+			return err
+		}
+	}
+	for i, page := range snapshot.Pages {
+		//older snapshots have no positions, but their pages are in reading order as well
+		page.Position = i
+		if err := restorePage(tx, unitId, page); err != nil {
+			return err
+		}
+	}
+	if err := insertUnitRevision(tx, revision); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+//RestorePage sets a single page back to the snapshot, see RestoreUnit
+func RestorePage(unitId int, page PageSnapshot, revision UnitRevision) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := restorePage(tx, unitId, page); err != nil {
+		return err
+	}
+	if err := insertUnitRevision(tx, revision); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func restorePage(tx *sql.Tx, unitId int, page PageSnapshot) error {
+	//snapshots taken before the page types were registered can still contain the old free text types
+	if _, ok := findPageType(page.PageType); !ok {
+		page.PageType = pageTypeDefault
+	}
+	query := `
+		INSERT INTO pages (page_title, page_type, unit_id, page_id, position) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (page_id) DO UPDATE SET page_title=EXCLUDED.page_title, page_type=EXCLUDED.page_type, unit_id=EXCLUDED.unit_id,
+		position=EXCLUDED.position, version=pages.version+1;
+		`
+	if _, err := tx.Exec(query, page.Title, page.PageType, unitId, page.ID, page.Position); err != nil {
+		return err
+	}
+	keep := make(map[int]bool)
+	for _, row := range page.Rows {
+		keep[row.ID] = true
+	}
+	rowIds, err := queryIds(tx, "SELECT row_id FROM rows WHERE page_id=$1;", page.ID)
+	if err != nil {
+		return err
+	}
+	for _, rowId := range rowIds {
+		if keep[rowId] {
+			continue
+		}
+		if err := deleteRows(tx, "row_id=$1", rowId); err != nil {
+			return err
+		}
+	}
+	query = `
+		INSERT INTO rows (left_markdown, right_markdown, left_has_image, right_has_image, leftimage, rightimage, left_is_argument, right_is_argument, page_id, row_id, position)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		ON CONFLICT (row_id) DO UPDATE SET left_markdown=EXCLUDED.left_markdown, right_markdown=EXCLUDED.right_markdown,
+		left_has_image=EXCLUDED.left_has_image, right_has_image=EXCLUDED.right_has_image, leftimage=EXCLUDED.leftimage, rightimage=EXCLUDED.rightimage,
+		left_is_argument=EXCLUDED.left_is_argument, right_is_argument=EXCLUDED.right_is_argument, page_id=EXCLUDED.page_id,
+		position=EXCLUDED.position, version=rows.version+1;
+		`
+	for i, row := range page.Rows {
+		_, err := tx.Exec(query, row.LeftMarkdown, row.RightMarkdown, row.LeftHasImage, row.RightHasImage, row.LeftImage, row.RightImage, row.LeftIsArgument, row.RightIsArgument, page.ID, row.ID, i)
+		if err != nil {
+			return err
+		}
+	}
+	return restoreQuestions(tx, page)
+}
+
+//restoreQuestions sets the questions of the page back to the snapshot keeping their ids, so earlier answers
+//still refer to them
+func restoreQuestions(tx *sql.Tx, page PageSnapshot) error {
+	keep := make(map[int]bool)
+	for _, question := range page.Questions {
+		keep[question.Id] = true
+	}
+	questionIds, err := queryIds(tx, "SELECT question_id FROM page_questions WHERE page_id=$1;", page.ID)
+	if err != nil {
+		return err
+	}
+	for _, questionId := range questionIds {
+		if keep[questionId] {
+			continue
+		}
+		if _, err := tx.Exec("DELETE FROM page_questions WHERE question_id=$1;", questionId); err != nil {
+			return err
+		}
+	}
+	query := `
+		INSERT INTO page_questions (prompt, options, correct, accepted, page_id, question_id, position) VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (question_id) DO UPDATE SET prompt=EXCLUDED.prompt, options=EXCLUDED.options, correct=EXCLUDED.correct,
+		accepted=EXCLUDED.accepted, page_id=EXCLUDED.page_id, position=EXCLUDED.position;
+		`
+	for i, question := range page.Questions {
+		options, err := jsonArray(question.Options)
+		if err != nil {
+			return err
+		}
+		correct, err := jsonArray(question.Correct)
+		if err != nil {
+			return err
+		}
+		accepted, err := jsonArray(question.Accepted)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(query, question.Prompt, options, correct, accepted, page.ID, question.Id, i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func queryIds(q querier, query string, args ...interface{}) ([]int, error) {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ids := make([]int, 0)
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+//UpdateErrorImage changes the image and its circles. Publishing is left to the moderation.
+func UpdateErrorImage(errorImage ErrorImage) (ErrorImage, error) {
+	stmt, err := db.Prepare("UPDATE error_images SET correct_image_id=$1, scale=$2 WHERE error_image_id=$3;")
+	if err != nil {
+		return ErrorImage{}, err
+	}
+	_, err = stmt.Exec(errorImage.CorrectImageId, errorImage.Scale, errorImage.ID)
+	if err != nil {
+		return ErrorImage{}, err
+	}
+	stmt, err = db.Prepare("DELETE FROM error_circles WHERE error_image_id=$1;")
+	if err != nil {
+		return ErrorImage{}, err
+	}
+	_, err = stmt.Exec(errorImage.ID)
+	if err != nil {
+		return ErrorImage{}, err
+	}
+	query := "INSERT INTO error_circles (radius, centerX, centerY, error_image_id) VALUES ($1,$2,$3,$4) RETURNING error_circle_id;"
+	if err != nil {
+		return ErrorImage{}, err
+	}
+	for idx, circle := range errorImage.ErrorCircles {
+		var newId int
+		err := db.QueryRow(query, circle.Radius, circle.CenterX, circle.CenterY, errorImage.ID).Scan(&newId)
+		if err != nil {
+			return ErrorImage{}, err
+		}
+		errorImage.ErrorCircles[idx].ID = newId
+	}
+	return errorImage, nil
+}
+
+func UpdateImageUser(image Image) error {
+	stmt, err := db.Prepare("UPDATE images SET caption=$1, credits=$2, age_known=$3, age=$4, imprecision=$5 WHERE image_id=$6;")
+	if err != nil {
+		return err
+	}
+	_, err = stmt.Exec(image.Caption, image.Credits, image.AgeKnown, image.Age, image.Imprecision, image.ID)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func UpdateImagePath(imageId int, imagePath string) error {
+	stmt, err := db.Prepare("UPDATE images SET path=$1 WHERE image_id=$2")
+	if err != nil {
+		return err
+	}
+	_, err = stmt.Exec(imagePath, imageId)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func GetRotateImagePublishedAndPath(imageId int) (bool, string, error) {
+	query := `
+		SELECT ` + unitVisible + `, rotate_images.basepath FROM units
+		JOIN rotate_images ON rotate_images.rotate_image_id = units.rotate_image_id
+		WHERE rotate_images.rotate_image_id = $1;
+		`
+	row := db.QueryRow(query, imageId)
+	var published bool
+	var path string
+	err := row.Scan(&published, &path)
+	if err != nil {
+		return false, "", err
+	}
+	return published, path, nil
+}
+
+func GetImageById(imageId int) (Image, error) {
+	query := `
+		SELECT ` + unitVisible + `, units.user_id, images.path, images.caption, images.credits, images.unit_id, images.age_known, images.age, images.imprecision FROM units
+		JOIN images ON images.unit_id = units.unit_id
+		WHERE images.image_id = $1;
+		`
+	row := db.QueryRow(query, imageId)
+	var published, ageKnown bool
+	var userId, unitId, age, imprecision int
+	var path, caption, credits string
+	var nullPath sql.NullString
+	err := row.Scan(&published, &userId, &nullPath, &caption, &credits, &unitId, &ageKnown, &age, &imprecision)
+	if err != nil {
+		return Image{}, err
+	}
+	if nullPath.Valid {
+		path = nullPath.String
+	}
+	return Image{path, caption, credits, unitId, userId, imageId, published, ageKnown, age, imprecision}, nil
+}
+
+func GetRotateImageById(imageId int) (RotateImage, error) {
+	query := `
+		SELECT ` + unitVisible + `, units.user_id, units.unit_id, rotate_images.basepath, rotate_images.caption, rotate_images.credits, rotate_images.num FROM units
+		JOIN rotate_images ON rotate_images.rotate_image_id = units.rotate_image_id
+		WHERE rotate_images.rotate_image_id = $1;
+		`
+	row := db.QueryRow(query, imageId)
+	var published bool
+	var userId, unitId, num int
+	var path, caption, credits string
+	var nullPath sql.NullString
+	err := row.Scan(&published, &userId, &unitId, &nullPath, &caption, &credits, &num)
+	if err != nil {
+		return RotateImage{}, err
+	}
+	if nullPath.Valid {
+		path = nullPath.String
+	}
+	return RotateImage{path, num, caption, credits, unitId, userId, imageId, published}, nil
+}
+
+func InsertImage(image Image) (int, error) {
+	query := "INSERT INTO images (caption, credits, unit_id, age_known, age, imprecision) VALUES ($1, $2, $3, $4, $5, $6) RETURNING image_id;"
+	var imageId int
+	err := db.QueryRow(query, image.Caption, image.Credits, image.UnitId, image.AgeKnown, image.Age, image.Imprecision).Scan(&imageId)
+	if err != nil {
+		return -1, err
+	}
+	return int(imageId), nil
+}
+
+func InsertErrorImage(errorImage ErrorImage) (ErrorImage, error) {
+	query := "INSERT INTO error_images (path, correct_image_id, scale, user_id) VALUES ($1, $2, $3, $4) RETURNING error_image_id;"
+	var errorImageId int
+	err := db.QueryRow(query, errorImage.path, errorImage.CorrectImageId, errorImage.Scale, errorImage.UserId).Scan(&errorImageId)
+	if err != nil {
+		return ErrorImage{}, err
+	}
+	errorImage.ID = errorImageId
+	query = "INSERT INTO error_circles (radius, centerX, centerY, error_image_id) VALUES ($1,$2,$3,$4) RETURNING error_circle_id;"
+	if err != nil {
+		return ErrorImage{}, err
+	}
+	for idx, circle := range errorImage.ErrorCircles {
+		var newId int
+		err := db.QueryRow(query, circle.Radius, circle.CenterX, circle.CenterY, errorImage.ID).Scan(&newId)
+		if err != nil {
+			return ErrorImage{}, err
+		}
+		errorImage.ErrorCircles[idx].ID = newId
+	}
+	return errorImage, nil
+}
+
+func UpdateErrorImagePath(id int, path string) error {
+	stmt, err := db.Prepare("UPDATE error_images SET path=$1 WHERE error_image_id=$2;")
+	if err != nil {
+		return err
+	}
+	_, err = stmt.Exec(path, id)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+//errorImageSelect selects everything scanErrorImage expects. Conditions are appended to it, followed by
+//errorImageGroupBy.
+const errorImageSelect = `
+	SELECT error_images.path, error_images.correct_image_id, error_images.scale, error_images.user_id, error_images.error_image_id, error_images.published,
+	COALESCE(error_images.moderation_status, 'pending'), COALESCE(error_images.moderation_reason, ''),
+	COALESCE(json_agg(json_build_object('centerX', error_circles.centerX, 'centerY', error_circles.centerY, 'radius', error_circles.radius, 'id', error_circles.error_circle_id)
+		ORDER BY error_circles.error_circle_id) FILTER (WHERE error_circles.error_circle_id IS NOT NULL), '[]')
+	FROM error_images
+	LEFT JOIN error_circles ON error_circles.error_image_id = error_images.error_image_id `
+
+const errorImageGroupBy = ` GROUP BY error_images.error_image_id`
+
+func scanErrorImage(row scanner) (ErrorImage, error) {
+	var errorImage ErrorImage
+	var circlesAgg string
+	err := row.Scan(&errorImage.path, &errorImage.CorrectImageId, &errorImage.Scale, &errorImage.UserId, &errorImage.ID, &errorImage.Published,
+		&errorImage.ModerationStatus, &errorImage.ModerationReason, &circlesAgg)
+	if err != nil {
+		return ErrorImage{}, err
+	}
+	if err := json.Unmarshal([]byte(circlesAgg), &errorImage.ErrorCircles); err != nil {
+		return ErrorImage{}, err
+	}
+	return errorImage, nil
+}
+
+func GetErrorImageById(id int) (ErrorImage, error) {
+	return scanErrorImage(db.QueryRow(errorImageSelect+"WHERE error_images.error_image_id=$1"+errorImageGroupBy, id))
+}
+
+//DbModerateErrorImage approves or rejects the error image and notifies its creator in the same transaction
+func DbModerateErrorImage(errorImageId, moderatorId int, status, reason, message string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var userId int
+	err = tx.QueryRow(`UPDATE error_images SET moderation_status=$1, moderation_reason=NULLIF($2, ''), published=($1 = 'approved'), moderated_by=$3, moderated_at=now()
+		WHERE error_image_id=$4 RETURNING user_id;`, status, reason, moderatorId, errorImageId).Scan(&userId)
+	if err != nil {
+		return err
+	}
+	if err := insertNotification(tx, Notification{UserId: userId, Kind: "error-image-" + status, Message: message, RefId: errorImageId}); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+//ResubmitErrorImage puts a changed error image back into the moderation queue
+func ResubmitErrorImage(errorImageId int) error {
+	_, err := db.Exec("UPDATE error_images SET moderation_status='pending', moderation_reason=NULL, published=false WHERE error_image_id=$1;", errorImageId)
+	return err
+}
+
+func insertNotification(tx *sql.Tx, notification Notification) error {
+	_, err := tx.Exec("INSERT INTO notifications (user_id, kind, message, ref_id) VALUES ($1, $2, $3, NULLIF($4, 0));",
+		notification.UserId, notification.Kind, notification.Message, notification.RefId)
+	return err
+}
+
+//GetUserNotifications returns the notifications of the user, newest first
+func GetUserNotifications(userId int, unreadOnly bool) ([]Notification, error) {
+	query := `
+		SELECT user_id, kind, COALESCE(message, ''), COALESCE(ref_id, 0), created_at, read_at, notification_id FROM notifications
+		WHERE user_id=$1 AND (NOT $2 OR read_at IS NULL)
+		ORDER BY created_at DESC, notification_id DESC;
+		`
+	rows, err := db.Query(query, userId, unreadOnly)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	notifications := make([]Notification, 0)
+	for rows.Next() {
+		var notification Notification
+		if err := rows.Scan(&notification.UserId, &notification.Kind, &notification.Message, &notification.RefId, &notification.CreatedAt, &notification.ReadAt, &notification.Id); err != nil {
+			return nil, err
+		}
+		notification.Read = notification.ReadAt != nil
+		notifications = append(notifications, notification)
+	}
+	return notifications, rows.Err()
+}
+
+//MarkNotificationRead marks or unmarks a notification of the user as read
+func MarkNotificationRead(notificationId, userId int, read bool) error {
+	result, err := db.Exec("UPDATE notifications SET read_at=CASE WHEN $1 THEN COALESCE(read_at, now()) ELSE NULL END WHERE notification_id=$2 AND user_id=$3;", read, notificationId, userId)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+//errorImageAttemptSelect reads an attempt together with the number of found and existing circles
+const errorImageAttemptSelect = `
+	SELECT error_image_attempts.error_image_id, error_image_attempts.user_id, error_image_attempts.started_at, error_image_attempts.completed_at, error_image_attempts.clicks,
+	(SELECT count(*) FROM error_image_hits WHERE error_image_hits.error_image_attempt_id = error_image_attempts.error_image_attempt_id),
+	(SELECT count(*) FROM error_circles WHERE error_circles.error_image_id = error_image_attempts.error_image_id),
+	error_image_attempts.error_image_attempt_id
+	FROM error_image_attempts
+	WHERE error_image_attempts.error_image_attempt_id=$1`
+
+func scanErrorImageAttempt(row *sql.Row) (ErrorImageAttempt, error) {
+	var attempt ErrorImageAttempt
+	var circles int
+	if err := row.Scan(&attempt.ErrorImageId, &attempt.UserId, &attempt.StartedAt, &attempt.CompletedAt, &attempt.Clicks, &attempt.Found, &circles, &attempt.Id); err != nil {
+		return ErrorImageAttempt{}, err
+	}
+	if attempt.CompletedAt != nil {
+		attempt.Seconds = attempt.CompletedAt.Sub(attempt.StartedAt).Seconds()
+	}
+	attempt.Remaining = circles - attempt.Found
+	return attempt, nil
+}
+
+func InsertErrorImageAttempt(errorImageId, userId int) (ErrorImageAttempt, error) {
+	var attemptId int
+	err := db.QueryRow("INSERT INTO error_image_attempts (error_image_id, user_id) VALUES ($1, $2) RETURNING error_image_attempt_id;", errorImageId, userId).Scan(&attemptId)
+	if err != nil {
+		return ErrorImageAttempt{}, err
+	}
+	return GetErrorImageAttempt(attemptId)
+}
+
+func GetErrorImageAttempt(attemptId int) (ErrorImageAttempt, error) {
+	return scanErrorImageAttempt(db.QueryRow(errorImageAttemptSelect, attemptId))
+}
+
+//RecordErrorImageClick checks the click against the circles not found yet in the attempt and completes the
+//attempt when the last one is found. It returns the id of the found circle or 0. With earnsPoints the player
+//gets the points for the circle in the same transaction. After maxMissedClicks misses the attempt takes no
+//more clicks.
+func RecordErrorImageClick(attemptId int, click ErrorImageClick, earnsPoints bool) (ErrorImageAttempt, int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return ErrorImageAttempt{}, 0, err
+	}
+	defer tx.Rollback()
+	var errorImageId, userId, clicks int
+	var scale float64
+	var completed bool
+	err = tx.QueryRow(`SELECT error_image_attempts.error_image_id, error_image_attempts.user_id, error_image_attempts.clicks, error_images.scale, error_image_attempts.completed_at IS NOT NULL FROM error_image_attempts
+		JOIN error_images ON error_images.error_image_id = error_image_attempts.error_image_id
+		WHERE error_image_attempt_id=$1 FOR UPDATE OF error_image_attempts;`, attemptId).Scan(&errorImageId, &userId, &clicks, &scale, &completed)
+	if err != nil {
+		return ErrorImageAttempt{}, 0, err
+	}
+	if completed {
+		return ErrorImageAttempt{}, 0, errAttemptCompleted
+	}
+	rows, err := tx.Query("SELECT centerX, centerY, radius, error_circle_id FROM error_circles WHERE error_image_id=$1 ORDER BY error_circle_id;", errorImageId)
+	if err != nil {
+		return ErrorImageAttempt{}, 0, err
+	}
+	circles := make([]Circle, 0)
+	for rows.Next() {
+		var circle Circle
+		if err := rows.Scan(&circle.CenterX, &circle.CenterY, &circle.Radius, &circle.ID); err != nil {
+			rows.Close()
+			return ErrorImageAttempt{}, 0, err
+		}
+		circles = append(circles, circle)
+	}
+	rows.Close()
+	found := make(map[int]bool)
+	foundIds, err := queryIds(tx, "SELECT error_circle_id FROM error_image_hits WHERE error_image_attempt_id=$1;", attemptId)
+	if err != nil {
+		return ErrorImageAttempt{}, 0, err
+	}
+	for _, id := range foundIds {
+		found[id] = true
+	}
+	if clicks-len(foundIds) >= maxMissedClicks {
+		return ErrorImageAttempt{}, 0, errTooManyMisses
+	}
+	circleId, hit := hitCircle(circles, scale, click, found)
+	if hit {
+		if _, err := tx.Exec("INSERT INTO error_image_hits (error_image_attempt_id, error_circle_id) VALUES ($1, $2);", attemptId, circleId); err != nil {
+			return ErrorImageAttempt{}, 0, err
+		}
+		found[circleId] = true
+		if earnsPoints {
+			if _, err := awardPoints(tx, userId, pointsErrorCircleFound, circleId); err != nil {
+				return ErrorImageAttempt{}, 0, err
+			}
+		}
+	}
+	query := "UPDATE error_image_attempts SET clicks=clicks+1 WHERE error_image_attempt_id=$1;"
+	if len(found) >= len(circles) {
+		query = "UPDATE error_image_attempts SET clicks=clicks+1, completed_at=now() WHERE error_image_attempt_id=$1;"
+	}
+	if _, err := tx.Exec(query, attemptId); err != nil {
+		return ErrorImageAttempt{}, 0, err
+	}
+	attempt, err := scanErrorImageAttempt(tx.QueryRow(errorImageAttemptSelect, attemptId))
+	if err != nil {
+		return ErrorImageAttempt{}, 0, err
+	}
+	return attempt, circleId, tx.Commit()
+}
+
+//DbCreateDatingRound picks up to size random age-known images for a new round of the user. Images come from the
+//unit, the units of the course or, without both, from all visible units.
+func DbCreateDatingRound(userId, unitId, courseId, size int) (DatingRound, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return DatingRound{}, err
+	}
+	defer tx.Rollback()
+	var roundId int
+	err = tx.QueryRow("INSERT INTO dating_rounds (user_id, unit_id, course_id) VALUES ($1, NULLIF($2, 0), NULLIF($3, 0)) RETURNING dating_round_id;", userId, unitId, courseId).Scan(&roundId)
+	if err != nil {
+		return DatingRound{}, err
+	}
+	query := `
+		INSERT INTO dating_answers (dating_round_id, image_id, position)
+		SELECT $1, image_id, row_number() OVER () FROM (
+			SELECT images.image_id FROM images
+			JOIN units ON units.unit_id = images.unit_id
+			WHERE images.age_known AND (
+				($2 = 0 AND $4 = 0 AND ` + unitVisible + `) OR images.unit_id = $2 OR
+				($4 <> 0 AND ` + unitVisible + ` AND images.unit_id IN (SELECT unit_id FROM course_units WHERE course_id = $4))
+			)
+			ORDER BY random()
+			LIMIT $3
+		) picked;
+		`
+	result, err := tx.Exec(query, roundId, unitId, size, courseId)
+	if err != nil {
+		return DatingRound{}, err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return DatingRound{}, err
+	} else if affected == 0 {
+		return DatingRound{}, errNoDatingImages
+	}
+	if err := tx.Commit(); err != nil {
+		return DatingRound{}, err
+	}
+	return GetDatingRound(roundId)
+}
+
+func GetDatingRound(roundId int) (DatingRound, error) {
+	var round DatingRound
+	err := db.QueryRow("SELECT user_id, COALESCE(unit_id, 0), COALESCE(course_id, 0), created_at, completed_at, score, dating_round_id FROM dating_rounds WHERE dating_round_id=$1;", roundId).
+		Scan(&round.UserId, &round.UnitId, &round.CourseId, &round.CreatedAt, &round.CompletedAt, &round.Score, &round.Id)
+	if err != nil {
+		return DatingRound{}, err
+	}
+	query := `
+		SELECT dating_answers.image_id, COALESCE(images.caption, ''), COALESCE(images.credits, ''), dating_answers.estimate, dating_answers.score, COALESCE(images.age, 0), COALESCE(images.imprecision, 0) FROM dating_answers
+		JOIN images ON images.image_id = dating_answers.image_id
+		WHERE dating_answers.dating_round_id=$1
+		ORDER BY dating_answers.position;
+		`
+	rows, err := db.Query(query, roundId)
+	if err != nil {
+		return DatingRound{}, err
+	}
+	defer rows.Close()
+	round.Answers = make([]DatingAnswer, 0)
+	for rows.Next() {
+		var answer DatingAnswer
+		var age, imprecision int
+		if err := rows.Scan(&answer.ImageId, &answer.Caption, &answer.Credits, &answer.Estimate, &answer.Score, &age, &imprecision); err != nil {
+			return DatingRound{}, err
+		}
+		if answer.Estimate != nil {
+			answer.Age = &age
+			answer.Imprecision = &imprecision
+		}
+		round.Answers = append(round.Answers, answer)
+	}
+	round.MaxScore = len(round.Answers)
+	return round, rows.Err()
+}
+
+//GetUserDatingRounds returns the rounds of the user without their answers, newest first
+func GetUserDatingRounds(userId int) ([]DatingRound, error) {
+	query := `
+		SELECT dating_rounds.user_id, COALESCE(dating_rounds.unit_id, 0), COALESCE(dating_rounds.course_id, 0), dating_rounds.created_at, dating_rounds.completed_at, dating_rounds.score,
+		(SELECT count(*) FROM dating_answers WHERE dating_answers.dating_round_id = dating_rounds.dating_round_id), dating_rounds.dating_round_id
+		FROM dating_rounds
+		WHERE dating_rounds.user_id=$1
+		ORDER BY dating_rounds.created_at DESC, dating_rounds.dating_round_id DESC;
+		`
+	rows, err := db.Query(query, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	rounds := make([]DatingRound, 0)
+	for rows.Next() {
+		var round DatingRound
+		if err := rows.Scan(&round.UserId, &round.UnitId, &round.CourseId, &round.CreatedAt, &round.CompletedAt, &round.Score, &round.MaxScore, &round.Id); err != nil {
+			return nil, err
+		}
+		rounds = append(rounds, round)
+	}
+	return rounds, rows.Err()
+}
+
+//DateImage scores the estimate for an image of the round. The round is completed with the last answer.
+func DateImage(roundId, imageId, estimate int) (DatingAnswer, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return DatingAnswer{}, err
+	}
+	defer tx.Rollback()
+	var answered bool
+	var answer DatingAnswer
+	var age, imprecision int
+	query := `
+		SELECT dating_answers.estimate IS NOT NULL, COALESCE(images.caption, ''), COALESCE(images.credits, ''), COALESCE(images.age, 0), COALESCE(images.imprecision, 0) FROM dating_answers
+		JOIN images ON images.image_id = dating_answers.image_id
+		WHERE dating_answers.dating_round_id=$1 AND dating_answers.image_id=$2
+		FOR UPDATE OF dating_answers;
+		`
+	if err := tx.QueryRow(query, roundId, imageId).Scan(&answered, &answer.Caption, &answer.Credits, &age, &imprecision); err != nil {
+		return DatingAnswer{}, err
+	}
+	if answered {
+		return DatingAnswer{}, errAlreadyDated
+	}
+	score := datingScore(age, imprecision, estimate)
+	_, err = tx.Exec("UPDATE dating_answers SET estimate=$1, score=$2, answered_at=now() WHERE dating_round_id=$3 AND image_id=$4;", estimate, score, roundId, imageId)
+	if err != nil {
+		return DatingAnswer{}, err
+	}
+	query = `
+		UPDATE dating_rounds SET score=(SELECT COALESCE(sum(score), 0) FROM dating_answers WHERE dating_round_id=$1),
+		completed_at=CASE WHEN EXISTS (SELECT 1 FROM dating_answers WHERE dating_round_id=$1 AND estimate IS NULL) THEN NULL ELSE now() END
+		WHERE dating_round_id=$1;
+		`
+	if _, err := tx.Exec(query, roundId); err != nil {
+		return DatingAnswer{}, err
+	}
+	answer.ImageId = imageId
+	answer.Estimate = &estimate
+	answer.Score = &score
+	answer.Age = &age
+	answer.Imprecision = &imprecision
+	return answer, tx.Commit()
+}
+
+func InsertRotateImage(image RotateImage) (int, error) {
+	query := "INSERT INTO rotate_images (caption, credits, num) VALUES ($1, $2, $3) RETURNING rotate_image_id;"
+	var imageId int
+	err := db.QueryRow(query, image.Caption, image.Credits, image.Num).Scan(&imageId)
+	if err != nil {
+		return -1, err
+	}
+	return int(imageId), nil
+}
+
+func InsertPage(page Page, revision UnitRevision) (Page, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return Page{}, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("SELECT 1 FROM units WHERE unit_id=$1 FOR UPDATE;", page.UnitID); err != nil {
+		return Page{}, err
+	}
+	query := "INSERT INTO pages (page_title, page_type, unit_id, position) VALUES ($1, $2, $3, " + nextPagePosition + ") RETURNING page_id, position;"
+	var pageId int
+	err = tx.QueryRow(query, page.Title, page.PageType, page.UnitID).Scan(&pageId, &page.Position)
+	if err != nil {
+		return Page{}, err
+	}
+	page.ID = pageId
+	stmt, err := tx.Prepare("INSERT INTO rows (left_markdown, right_markdown, left_has_image, right_has_image, leftimage, rightimage, left_is_argument, right_is_argument, page_id, position) VALUES ($1, $2, $3, $4, $5 ,$6, $7, $8, $9, $10) RETURNING row_id;")
+	if err != nil {
+		return Page{}, err
+	}
+	for idx, row := range page.Rows {
+		var rowId int
+		err := stmt.QueryRow(row.LeftMarkdown, row.RightMarkdown, row.LeftHasImage, row.RightHasImage, row.LeftImage, row.RightImage, row.LeftIsArgument, row.RightIsArgument, pageId, idx).Scan(&rowId)
+		if err != nil {
+			return Page{}, err
+		}
+		log.Println(rowId)
+		page.Rows[idx].ID = rowId
+		page.Rows[idx].Position = idx
+	}
+	log.Println(page.Rows)
+	if page.Questions, err = setPageQuestions(tx, pageId, page.Questions); err != nil {
+		return Page{}, err
+	}
+	if err := insertUnitRevision(tx, revision); err != nil {
+		return Page{}, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return Page{}, err
+	}
+	return page, nil
+}
+
+//userRanks ranks all users by their points, users with equal points share a rank
+const userRanks = `SELECT user_id, rank() OVER (ORDER BY points DESC) AS rank FROM users`
+
+func GetUserById(userId int) (User, error) {
+	query := `SELECT uo.username, uo.points, uo.active, ranks.rank,
+		json_agg(DISTINCT unit_members.unit_id) AS units, 
+		json_agg(DISTINCT groups.group_name) AS groups,
+		json_agg(DISTINCT clicked_images.image_id) AS clicked_images,
+		json_agg(DISTINCT clicked_arguments.row_id) AS clicked_arguments,
+		json_agg(DISTINCT error_images.error_image_id) AS error_images
+		FROM users uo
+		LEFT JOIN unit_members ON unit_members.user_id=uo.user_id 
+		LEFT JOIN clicked_images ON clicked_images.user_id=uo.user_id 
+		LEFT JOIN clicked_arguments ON clicked_arguments.user_id=uo.user_id 
+		LEFT JOIN error_images ON error_images.user_id=uo.user_id 
+		LEFT JOIN user_groups ON uo.user_id=user_groups.user_id 
+		LEFT JOIN groups ON user_groups.group_id = groups.group_id
+		JOIN (` + userRanks + `) ranks ON ranks.user_id=uo.user_id
+		WHERE uo.user_id=$1 GROUP BY uo.user_id, ranks.rank`
+	row := db.QueryRow(query, userId)
+	var dbUsername, jsonUnits, jsonGroups, jsonClickedIms, jsonClickedArgs, jsonErrorIms string
+	var points, rank uint
+	var active bool
+	err := row.Scan(&dbUsername, &points, &active, &rank, &jsonUnits, &jsonGroups, &jsonClickedIms, &jsonClickedArgs, &jsonErrorIms)
+	if err != nil {
+		return User{}, err
+	}
+	var clickedIms []int
+	if jsonClickedIms == emptyArr {
+		clickedIms = make([]int, 0)
+	} else {
+		if err := json.Unmarshal([]byte(jsonClickedIms), &clickedIms); err != nil {
+			return User{}, err
+		}
+	}
+	var clickedArgs []int
+	if jsonClickedArgs == emptyArr {
+		clickedArgs = make([]int, 0)
+	} else {
+		if err := json.Unmarshal([]byte(jsonClickedArgs), &clickedArgs); err != nil {
+			return User{}, err
+		}
+	}
+	var errorIms []int
+	if jsonErrorIms == emptyArr {
+		errorIms = make([]int, 0)
+	} else {
+		if err := json.Unmarshal([]byte(jsonErrorIms), &errorIms); err != nil {
+			return User{}, err
+		}
+	}
+	var groups []string
+	if err := json.Unmarshal([]byte(jsonGroups), &groups); err != nil {
+		return User{}, err
+	}
+	var units []int
+	if jsonUnits == emptyArr {
+		units = make([]int, 0)
+	} else {
+		if err := json.Unmarshal([]byte(jsonUnits), &units); err != nil {
+			return User{}, err
+		}
+	}
+	u := User{Username: dbUsername, Units: units, Groups: groups, ID: userId, Points: points, Active: active, Rank: rank, ClickedImages: clickedIms, ClickedArguments: clickedArgs, ErrorImages: errorIms}
+	return u, nil
+}
+
+func GetUserByName(username string) (User, error) {
+	row := db.QueryRow("SELECT users.*, json_agg(groups.group_name) FROM users LEFT JOIN user_groups ON users.user_id=user_groups.user_id LEFT JOIN groups ON user_groups.group_id=groups.group_id WHERE username=$1 GROUP BY users.user_id", username)
+	var dbUsername, salt, pwhash, jsonGroups, mailHash string
+	var active bool
+	var id int
+	var points uint
+	err := row.Scan(&dbUsername, &salt, &pwhash, &active, &id, &mailHash, &points, &jsonGroups)
+	if err != nil {
+		return User{}, err
+	}
+	var groups []string
+	if err := json.Unmarshal([]byte(jsonGroups), &groups); err != nil {
+		return User{}, err
+	}
+	u := User{dbUsername, groups, nil, id, salt, pwhash, active, mailHash, points, 0, "", nil, nil, nil, nil}
+	return u, nil
+}
+
+func InsertUser(user User) (int, error) {
+	query := "INSERT INTO users (username, salt, pwhash, active, mailhash) VALUES ($1, $2, $3, $4, $5) RETURNING user_id;"
+	var userId int
+	err := db.QueryRow(query, user.Username, user.salt, user.pwHash, user.Active, user.mailHash).Scan(&userId)
+	if err != nil {
+		return -1, err
+	}
+	return userId, nil
+}
+
+func GetAllUsers() ([]User, error) {
+	query := `SELECT uo.username, uo.active, uo.user_id, uo.points, 
+		json_agg(DISTINCT unit_members.unit_id) AS units, 
+		json_agg(DISTINCT groups.group_name), ranks.rank
+		FROM users uo
+		LEFT JOIN unit_members ON unit_members.user_id=uo.user_id 
+		LEFT JOIN user_groups ON uo.user_id=user_groups.user_id 
+		LEFT JOIN groups ON user_groups.group_id = groups.group_id
+		JOIN (` + userRanks + `) ranks ON ranks.user_id=uo.user_id
+		GROUP BY uo.user_id, ranks.rank`
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	users := make([]User, 0)
+	for rows.Next() {
+		var dbUsername, jsonUnits, jsonGroups string
+		var active bool
+		var userId int
+		var points, rank uint
+		err := rows.Scan(&dbUsername, &active, &userId, &points, &jsonUnits, &jsonGroups, &rank)
+		if err != nil {
+			return nil, err
+		}
+		var groups []string
+		if err := json.Unmarshal([]byte(jsonGroups), &groups); err != nil {
+			return nil, err
+		}
+		var units []int
+		if jsonUnits == emptyArr {
+			units = make([]int, 0)
+		} else {
+			if err := json.Unmarshal([]byte(jsonUnits), &units); err != nil {
+				return nil, err
+			}
+		}
+		users = append(users, User{Username: dbUsername, Units: units, Groups: groups, Active: active, ID: userId, Points: points, Rank: rank})
+	}
+	return users, nil
+}
+
+func AdminUpdateUser(user User) error {
+	stmt, err := db.Prepare("UPDATE users SET active=$1 WHERE user_id=$2;")
+	if err != nil {
+		return err
+	}
+	_, err = stmt.Exec(user.Active, user.ID)
+	if err != nil {
+		return err
+	}
+	err = UpdateGroups(user)
+	if err != nil {
+		return err
+	}
+	if !user.Active {
+		return RevokeUserSessions(user.ID)
+	}
+	return nil
+}
+
+func InsertSession(userId int, refreshHash string, expiresAt time.Time) (int, error) {
+	var sessionId int
+	err := db.QueryRow("INSERT INTO sessions (user_id, refresh_hash, expires_at) VALUES ($1, $2, $3) RETURNING session_id;", userId, refreshHash, expiresAt).Scan(&sessionId)
+	if err != nil {
+		return -1, err
+	}
+	return sessionId, nil
+}
+
+func GetSessionByRefreshHash(refreshHash string) (Session, error) {
+	var session Session
+	err := db.QueryRow("SELECT session_id, user_id, expires_at, revoked FROM sessions WHERE refresh_hash=$1;", refreshHash).Scan(&session.ID, &session.UserId, &session.ExpiresAt, &session.Revoked)
+	if err != nil {
+		return Session{}, err
+	}
+	return session, nil
+}
+
+//RotateRefreshHash replaces the refresh token of a session. It only succeeds if the old token is still the current one,
+//so a refresh token can be used exactly once.
+func RotateRefreshHash(sessionId int, oldHash, newHash string, expiresAt time.Time) (bool, error) {
+	result, err := db.Exec("UPDATE sessions SET refresh_hash=$1, expires_at=$2 WHERE session_id=$3 AND refresh_hash=$4 AND revoked=false;", newHash, expiresAt, sessionId, oldHash)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected == 1, nil
+}
+
+func RevokeSession(sessionId int) error {
+	stmt, err := db.Prepare("UPDATE sessions SET revoked=true WHERE session_id=$1;")
+	if err != nil {
+		return err
+	}
+	_, err = stmt.Exec(sessionId)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func RevokeUserSessions(userId int) error {
+	stmt, err := db.Prepare("UPDATE sessions SET revoked=true WHERE user_id=$1;")
+	if err != nil {
+		return err
+	}
+	_, err = stmt.Exec(userId)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+//IsSessionValid checks that the session is neither revoked nor expired and that its user is still active
+func IsSessionValid(sessionId, userId int) (bool, error) {
+	var valid bool
+	query := `
+		SELECT users.active AND NOT sessions.revoked AND sessions.expires_at > now() FROM sessions
+		JOIN users ON users.user_id = sessions.user_id
+		WHERE sessions.session_id = $1 AND sessions.user_id = $2;
+		`
+	err := db.QueryRow(query, sessionId, userId).Scan(&valid)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return valid, nil
+}
+
+func InsertUserToken(userId int, purpose, jti string, expiresAt time.Time) error {
+	stmt, err := db.Prepare("INSERT INTO user_tokens (user_id, purpose, jti, expires_at) VALUES ($1, $2, $3, $4);")
+	if err != nil {
+		return err
+	}
+	_, err = stmt.Exec(userId, purpose, jti, expiresAt)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+//ConsumeUserToken marks the token as used and returns its user. ok is false if the token is unknown, was issued
+//for another purpose, is expired or was already used.
+func ConsumeUserToken(jti, purpose string) (userId int, ok bool, err error) {
+	query := `
+		UPDATE user_tokens SET used_at = now()
+		WHERE jti = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > now()
+		RETURNING user_id;
+		`
+	err = db.QueryRow(query, jti, purpose).Scan(&userId)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return userId, true, nil
+}
+
+func ActivateUser(userId int) error {
+	stmt, err := db.Prepare("UPDATE users SET active=true WHERE user_id=$1;")
+	if err != nil {
+		return err
+	}
+	_, err = stmt.Exec(userId)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func GetUserSalt(userId int) (string, error) {
+	var salt string
+	err := db.QueryRow("SELECT salt FROM users WHERE user_id=$1;", userId).Scan(&salt)
+	if err != nil {
+		return "", err
+	}
+	return salt, nil
+}
+
+func UpdateGroups(user User) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	//first delete all old groups for user
+	_, err = tx.Exec("DELETE FROM user_groups WHERE user_id=$1;", user.ID)
+	if err != nil {
+		return err
+	}
+	//now add groups
+	stmt, err := tx.Prepare("INSERT INTO user_groups (user_id, group_id) SELECT $1, group_id FROM groups WHERE group_name=$2;")
+	if err != nil {
+		return err
+	}
+	for _, group := range user.Groups {
+		result, err := stmt.Exec(user.ID, group)
+		if err != nil {
+			return err
+		}
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if affected != 1 {
+			return fmt.Errorf("group %q does not exist", group)
+		}
+	}
+	return tx.Commit()
+}
+
+func GetAllGroups() ([]Group, error) {
+	rows, err := db.Query("SELECT group_name, group_id FROM groups ORDER BY group_id;")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	groups := make([]Group, 0)
+	for rows.Next() {
+		var group Group
+		if err := rows.Scan(&group.Name, &group.ID); err != nil {
+			return nil, err
+		}
+		groups = append(groups, group)
+	}
+	return groups, rows.Err()
+}
+
+func GetGroupById(groupId int) (Group, error) {
+	var group Group
+	err := db.QueryRow("SELECT group_name, group_id FROM groups WHERE group_id=$1;", groupId).Scan(&group.Name, &group.ID)
+	if err != nil {
+		return Group{}, err
+	}
+	return group, nil
+}
+
+func IsGroupNameInDb(name string) (bool, error) {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM groups WHERE group_name=$1;", name).Scan(&count)
+	if err != nil {
+		return true, err
+	}
+	return count > 0, nil
+}
+
+//GetUnknownGroups returns all names that do not belong to an existing group
+func GetUnknownGroups(names []string) ([]string, error) {
+	groups, err := GetAllGroups()
+	if err != nil {
+		return nil, err
+	}
+	known := make([]string, len(groups))
+	for i, group := range groups {
+		known[i] = group.Name
+	}
+	unknown := make([]string, 0)
+	for _, name := range names {
+		if !stringInSlice(name, known) {
+			unknown = append(unknown, name)
+		}
+	}
+	return unknown, nil
+}
+
+func InsertGroup(group Group) (int, error) {
+	var groupId int
+	err := db.QueryRow("INSERT INTO groups (group_name) VALUES ($1) RETURNING group_id;", group.Name).Scan(&groupId)
+	if err != nil {
+		return -1, err
+	}
+	return groupId, nil
+}
+
+func DbUpdateGroup(group Group) error {
+	stmt, err := db.Prepare("UPDATE groups SET group_name=$1 WHERE group_id=$2;")
+	if err != nil {
+		return err
+	}
+	_, err = stmt.Exec(group.Name, group.ID)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func DbDeleteGroup(groupId int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM user_groups WHERE group_id=$1;", groupId); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM groups WHERE group_id=$1;", groupId); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func UpdateUserPW(user User) error {
+	stmt, err := db.Prepare("UPDATE users SET pwhash=$1 WHERE user_id=$2;")
+	if err != nil {
+		return err
+	}
+	_, err = stmt.Exec(user.pwHash, user.ID)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func UserUpdateUser(user User) error {
+	stmt, err := db.Prepare("UPDATE users SET active=$1, username=$2 WHERE user_id=$3;")
+	if err != nil {
+		return err
+	}
+	_, err = stmt.Exec(user.Active, user.Username, user.ID)
+	if err != nil {
+		return err
+	}
+	//delete all clicked images of user
+	stmt, err = db.Prepare("DELETE from clicked_images WHERE user_id=$1;")
+	if err != nil {
+		return err
+	}
+	_, err = stmt.Exec(user.ID)
+	if err != nil {
+		return err
+	}
+	//delete all clicked arguments of user
+	stmt, err = db.Prepare("DELETE from clicked_arguments WHERE user_id=$1;")
+	if err != nil {
+		return err
+	}
+	_, err = stmt.Exec(user.ID)
+	if err != nil {
+		return err
+	}
+	//insert clicked images
+	stmt, err = db.Prepare("INSERT INTO clicked_images (user_id, image_id) VALUES ($1, $2)")
+	if err != nil {
+		return err
+	}
+	for _, clickedIm := range user.ClickedImages {
+		_, err = stmt.Exec(user.ID, clickedIm)
+		if err != nil {
+			return err
+		}
+	}
+	//insert clicked arguments
+	stmt, err = db.Prepare("INSERT INTO clicked_arguments (user_id, row_id) VALUES ($1, $2)")
+	if err != nil {
+		return err
+	}
+	for _, rowId := range user.ClickedArguments {
+		_, err = stmt.Exec(user.ID, rowId)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//AwardPoints records the event in the points ledger and recomputes the total of the user. It returns false if
+//the user already got points for the event and reference.
+func AwardPoints(userId int, event string, refId int) (bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	awarded, err := awardPoints(tx, userId, event, refId)
+	if err != nil || !awarded {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+//awardPoints is AwardPoints inside the transaction of the event the points are for
+func awardPoints(tx *sql.Tx, userId int, event string, refId int) (bool, error) {
+	result, err := tx.Exec("INSERT INTO point_events (user_id, event, ref_id, points) VALUES ($1, $2, $3, $4) ON CONFLICT (user_id, event, ref_id) DO NOTHING", userId, event, refId, pointValues[event])
+	if err != nil {
+		return false, err
+	}
+	if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+		return false, err
+	}
+	if _, err := tx.Exec("UPDATE users SET points=(SELECT COALESCE(sum(points), 0) FROM point_events WHERE user_id=$1) WHERE user_id=$1", userId); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+//leaderboardTotals sums the points of the active users since $1
+const leaderboardTotals = `
+	WITH totals AS (
+		SELECT users.user_id, users.username, sum(point_events.points) AS points FROM users
+		JOIN point_events ON point_events.user_id=users.user_id
+		WHERE users.active AND point_events.created_at >= $1
+		GROUP BY users.user_id
+		HAVING sum(point_events.points) > 0
+	)`
+
+//GetLeaderboard ranks the active users by the points they got since the given time. It returns one page of
+//entries and the number of ranked users.
+func GetLeaderboard(since time.Time, limit, offset int) ([]LeaderboardEntry, int, error) {
+	query := leaderboardTotals + `
+		SELECT rank() OVER (ORDER BY points DESC), user_id, username, points, count(*) OVER () FROM totals
+		ORDER BY points DESC, user_id
+		LIMIT $2 OFFSET $3;
+		`
+	rows, err := db.Query(query, since, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	entries := make([]LeaderboardEntry, 0)
+	total := 0
+	for rows.Next() {
+		var entry LeaderboardEntry
+		if err := rows.Scan(&entry.Rank, &entry.UserId, &entry.Username, &entry.Points, &total); err != nil {
+			return nil, 0, err
+		}
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	//the total is read from the entries, a page behind the last one needs its own query
+	if len(entries) == 0 && offset > 0 {
+		err = db.QueryRow(leaderboardTotals+" SELECT count(*) FROM totals;", since).Scan(&total)
+	}
+	return entries, total, err
+}
+
+//courseSelect selects everything scanCourse expects. Conditions are appended to it, followed by courseGroupBy.
+const courseSelect = `
+	SELECT courses.title, courses.user_id, courses.join_code,
+	COALESCE(json_agg(course_units.unit_id ORDER BY course_units.position) FILTER (WHERE course_units.unit_id IS NOT NULL), '[]'),
+	(SELECT count(*) FROM course_members WHERE course_members.course_id = courses.course_id), courses.created_at, courses.course_id
+	FROM courses
+	LEFT JOIN course_units ON course_units.course_id = courses.course_id `
+
+const courseGroupBy = ` GROUP BY courses.course_id`
+
+func scanCourse(row scanner) (Course, error) {
+	var course Course
+	var units string
+	err := row.Scan(&course.Title, &course.UserId, &course.JoinCode, &units, &course.MemberCount, &course.CreatedAt, &course.Id)
+	if err != nil {
+		return Course{}, err
+	}
+	if err := json.Unmarshal([]byte(units), &course.Units); err != nil {
+		return Course{}, err
+	}
+	return course, nil
+}
+
+func GetCourseById(courseId int) (Course, error) {
+	return scanCourse(db.QueryRow(courseSelect+"WHERE courses.course_id=$1"+courseGroupBy, courseId))
+}
+
+func GetCourseByJoinCode(code string) (Course, error) {
+	return scanCourse(db.QueryRow(courseSelect+"WHERE courses.join_code=$1"+courseGroupBy, code))
+}
+
+//GetUserCourses returns the courses the user owns or is a member of
+func GetUserCourses(userId int) ([]Course, error) {
+	rows, err := db.Query(courseSelect+`WHERE courses.user_id=$1 OR courses.course_id IN (SELECT course_id FROM course_members WHERE user_id=$1)`+
+		courseGroupBy+" ORDER BY courses.course_id", userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	courses := make([]Course, 0)
+	for rows.Next() {
+		course, err := scanCourse(rows)
+		if err != nil {
+			return nil, err
+		}
+		courses = append(courses, course)
+	}
+	return courses, rows.Err()
+}
+
+func IsJoinCodeInDb(code string) (bool, error) {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM courses WHERE join_code=$1;", code).Scan(&count)
+	if err != nil {
+		return true, err
+	}
+	return count > 0, nil
+}
+
+func InsertCourse(course Course) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return -1, err
+	}
+	defer tx.Rollback()
+	var courseId int
+	err = tx.QueryRow("INSERT INTO courses (title, user_id, join_code) VALUES ($1, $2, $3) RETURNING course_id;", course.Title, course.UserId, course.JoinCode).Scan(&courseId)
+	if err != nil {
+		return -1, err
+	}
+	if err := setCourseUnits(tx, courseId, course.Units); err != nil {
+		return -1, err
+	}
+	return courseId, tx.Commit()
+}
+
+//DbUpdateCourse changes the title and the ordered units of the course
+func DbUpdateCourse(course Course) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("UPDATE courses SET title=$1 WHERE course_id=$2;", course.Title, course.Id); err != nil {
+		return err
+	}
+	if err := setCourseUnits(tx, course.Id, course.Units); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func setCourseUnits(tx *sql.Tx, courseId int, unitIds []int) error {
+	if _, err := tx.Exec("DELETE FROM course_units WHERE course_id=$1;", courseId); err != nil {
+		return err
+	}
+	for position, unitId := range unitIds {
+		if _, err := tx.Exec("INSERT INTO course_units (course_id, unit_id, position) VALUES ($1, $2, $3);", courseId, unitId, position); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func SetCourseJoinCode(courseId int, code string) error {
+	_, err := db.Exec("UPDATE courses SET join_code=$1 WHERE course_id=$2;", code, courseId)
+	return err
+}
+
+func DbDeleteCourse(courseId int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM assignment_submissions WHERE assignment_id IN (SELECT assignment_id FROM assignments WHERE course_id=$1);", courseId); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM assignments WHERE course_id=$1;", courseId); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM course_units WHERE course_id=$1;", courseId); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM course_members WHERE course_id=$1;", courseId); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM courses WHERE course_id=$1;", courseId); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func IsCourseMember(courseId, userId int) (bool, error) {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM course_members WHERE course_id=$1 AND user_id=$2;", courseId, userId).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func InsertCourseMember(courseId, userId int) error {
+	_, err := db.Exec("INSERT INTO course_members (course_id, user_id) VALUES ($1, $2) ON CONFLICT (course_id, user_id) DO NOTHING;", courseId, userId)
+	return err
+}
+
+func DbRemoveCourseMember(courseId, userId int) error {
+	result, err := db.Exec("DELETE FROM course_members WHERE course_id=$1 AND user_id=$2;", courseId, userId)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func GetCourseMembers(courseId int) ([]CourseMember, error) {
+	query := `
+		SELECT course_members.user_id, users.username, course_members.joined_at FROM course_members
+		JOIN users ON users.user_id = course_members.user_id
+		WHERE course_members.course_id=$1
+		ORDER BY users.username, course_members.user_id;
+		`
+	rows, err := db.Query(query, courseId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	members := make([]CourseMember, 0)
+	for rows.Next() {
+		var member CourseMember
+		if err := rows.Scan(&member.UserId, &member.Username, &member.JoinedAt); err != nil {
+			return nil, err
+		}
+		members = append(members, member)
+	}
+	return members, rows.Err()
+}
+
+//GetCourseProgress reports for every member of the course what they did in each unit of the course. A unit
+//counts as finished when the student decided it and everything in it, see unitFinished.
+func GetCourseProgress(courseId int) ([]StudentProgress, error) {
+	query := `
+		SELECT course_members.user_id, users.username, COALESCE(course_units.unit_id, 0),
+		(SELECT count(*) FROM pages WHERE pages.unit_id = course_units.unit_id),
+		(SELECT count(DISTINCT page_results.page_id) FROM page_results
+			JOIN pages ON pages.page_id = page_results.page_id
+			WHERE page_results.user_id = course_members.user_id AND pages.unit_id = course_units.unit_id),
+		(SELECT count(DISTINCT clicked_images.image_id) FROM clicked_images
+			JOIN images ON images.image_id = clicked_images.image_id
+			WHERE clicked_images.user_id = course_members.user_id AND images.unit_id = course_units.unit_id),
+		COALESCE(unit_results.decision, ''), ` + unitFinished("course_members.user_id", "course_units.unit_id") + `
+		FROM course_members
+		JOIN users ON users.user_id = course_members.user_id
+		LEFT JOIN course_units ON course_units.course_id = course_members.course_id
+		LEFT JOIN unit_results ON unit_results.unit_id = course_units.unit_id AND unit_results.user_id = course_members.user_id
+		WHERE course_members.course_id=$1
+		ORDER BY users.username, course_members.user_id, course_units.position;
+		`
+	rows, err := db.Query(query, courseId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	progress := make([]StudentProgress, 0)
+	for rows.Next() {
+		var userId int
+		var username string
+		var unit UnitProgress
+		if err := rows.Scan(&userId, &username, &unit.UnitId, &unit.Pages, &unit.PagesAnswered, &unit.ClickedImages, &unit.Decision, &unit.Finished); err != nil {
+			return nil, err
+		}
+		if len(progress) == 0 || progress[len(progress)-1].UserId != userId {
+			progress = append(progress, StudentProgress{UserId: userId, Username: username, Units: make([]UnitProgress, 0)})
+		}
+		if unit.UnitId == 0 {
+			continue
+		}
+		student := &progress[len(progress)-1]
+		if unit.Finished {
+			student.UnitsFinished++
+		}
+		student.Units = append(student.Units, unit)
+	}
+	return progress, rows.Err()
+}
+
+//assignmentStarted tells whether the user $1 answered a page of the assignment's unit
+const assignmentStarted = `EXISTS (SELECT 1 FROM page_results JOIN pages ON pages.page_id = page_results.page_id
+	WHERE page_results.user_id = $1 AND pages.unit_id = assignments.unit_id)`
+
+//assignmentSelect selects everything scanAssignment expects for the user $1
+const assignmentSelect = `
+	SELECT assignments.course_id, assignments.unit_id, assignments.title, COALESCE(assignments.description, ''), assignments.due_at, assignments.created_at,
+	` + assignmentStarted + `, assignment_submissions.submitted_at, assignments.assignment_id
+	FROM assignments
+	LEFT JOIN assignment_submissions ON assignment_submissions.assignment_id = assignments.assignment_id AND assignment_submissions.user_id = $1 `
+
+//scanAssignment reads an assignment, the status is only kept for students
+func scanAssignment(row scanner, student bool) (Assignment, error) {
+	var assignment Assignment
+	var started bool
+	err := row.Scan(&assignment.CourseId, &assignment.UnitId, &assignment.Title, &assignment.Description, &assignment.DueAt, &assignment.CreatedAt,
+		&started, &assignment.SubmittedAt, &assignment.Id)
+	if err != nil {
+		return Assignment{}, err
+	}
+	if student {
+		assignment.Status, assignment.Overdue = assignmentStatus(started, assignment.SubmittedAt, assignment.DueAt, time.Now())
+	} else {
+		assignment.SubmittedAt = nil
+	}
+	return assignment, nil
+}
+
+func GetAssignmentById(assignmentId, userId int, student bool) (Assignment, error) {
+	return scanAssignment(db.QueryRow(assignmentSelect+"WHERE assignments.assignment_id=$2;", userId, assignmentId), student)
+}
+
+//GetUserAssignments returns the assignments of the courses the user is a member of with the user's status,
+//followed by those of the other courses the user teaches. Every assignment is returned once.
+func GetUserAssignments(userId int) ([]Assignment, error) {
+	assignments := make([]Assignment, 0)
+	queries := []struct {
+		condition string
+		student   bool
+	}{
+		{"WHERE assignments.course_id IN (SELECT course_id FROM course_members WHERE user_id=$1)", true},
+		{"WHERE assignments.course_id IN (SELECT course_id FROM courses WHERE user_id=$1) AND assignments.course_id NOT IN (SELECT course_id FROM course_members WHERE user_id=$1)", false},
+	}
+	for _, query := range queries {
+		rows, err := db.Query(assignmentSelect+query.condition+" ORDER BY assignments.due_at, assignments.assignment_id;", userId)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			assignment, err := scanAssignment(rows, query.student)
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+			assignments = append(assignments, assignment)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return assignments, nil
+}
+
+func InsertAssignment(assignment Assignment) (int, error) {
+	var assignmentId int
+	err := db.QueryRow("INSERT INTO assignments (course_id, unit_id, title, description, due_at) VALUES ($1, $2, $3, $4, $5) RETURNING assignment_id;",
+		assignment.CourseId, assignment.UnitId, assignment.Title, assignment.Description, assignment.DueAt).Scan(&assignmentId)
+	if err != nil {
+		return -1, err
+	}
+	return assignmentId, nil
+}
+
+func DbUpdateAssignment(assignment Assignment) error {
+	_, err := db.Exec("UPDATE assignments SET unit_id=$1, title=$2, description=$3, due_at=$4 WHERE assignment_id=$5;",
+		assignment.UnitId, assignment.Title, assignment.Description, assignment.DueAt, assignment.Id)
+	return err
+}
+
+func DbDeleteAssignment(assignmentId int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM assignment_submissions WHERE assignment_id=$1;", assignmentId); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM assignments WHERE assignment_id=$1;", assignmentId); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+//missingDecisions counts the argument rows and quiz questions of the unit the user has not answered, plus one
+//if the user has not decided the unit itself. user and unit are the SQL expressions of their ids.
+func missingDecisions(user, unit string) string {
+	return `((SELECT count(*) FROM rows
+		JOIN pages ON pages.page_id = rows.page_id
+		WHERE pages.unit_id = ` + unit + ` AND (rows.left_is_argument OR rows.right_is_argument)
+		AND NOT EXISTS (
+			SELECT 1 FROM row_results
+			JOIN page_results ON page_results.page_result_id = row_results.page_result_id
+			WHERE page_results.user_id = ` + user + ` AND row_results.row_id = rows.row_id AND row_results.decision <> ''
+		))
+		+ (SELECT count(*) FROM page_questions
+		JOIN pages ON pages.page_id = page_questions.page_id
+		WHERE pages.unit_id = ` + unit + ` AND NOT EXISTS (
+			SELECT 1 FROM question_results
+			JOIN page_results ON page_results.page_result_id = question_results.page_result_id
+			WHERE page_results.user_id = ` + user + ` AND question_results.question_id = page_questions.question_id
+		))
+		+ (CASE WHEN EXISTS (SELECT 1 FROM unit_results WHERE unit_results.user_id = ` + user + ` AND unit_results.unit_id = ` + unit + ` AND unit_results.decision <> '') THEN 0 ELSE 1 END))`
+}
+
+//unitFinished is true if the user has nothing left to decide in the unit. Points, achievements and the course
+//progress all count a unit as finished by it.
+func unitFinished(user, unit string) string {
+	return "(" + missingDecisions(user, unit) + " = 0)"
+}
+
+//CountMissingDecisions counts what the user has left to decide in the unit, see missingDecisions
+func CountMissingDecisions(userId, unitId int) (int, error) {
+	var missing int
+	err := db.QueryRow("SELECT "+missingDecisions("$1", "$2")+";", userId, unitId).Scan(&missing)
+	return missing, err
+}
+
+//InsertAssignmentSubmission submits the assignment for the user, it returns false if it was submitted before
+func InsertAssignmentSubmission(assignmentId, userId int) (bool, error) {
+	result, err := db.Exec("INSERT INTO assignment_submissions (assignment_id, user_id) VALUES ($1, $2) ON CONFLICT (assignment_id, user_id) DO NOTHING;", assignmentId, userId)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+//GetAssignmentSubmissions lists the status and the row decisions in the unit of every member of the course
+func GetAssignmentSubmissions(assignment Assignment) ([]AssignmentSubmission, error) {
+	query := `
+		SELECT course_members.user_id, users.username,
+		EXISTS (SELECT 1 FROM page_results JOIN pages ON pages.page_id = page_results.page_id
+			WHERE page_results.user_id = course_members.user_id AND pages.unit_id = $2),
+		assignment_submissions.submitted_at
+		FROM course_members
+		JOIN users ON users.user_id = course_members.user_id
+		LEFT JOIN assignment_submissions ON assignment_submissions.assignment_id = $3 AND assignment_submissions.user_id = course_members.user_id
+		WHERE course_members.course_id=$1
+		ORDER BY users.username, course_members.user_id;
+		`
+	rows, err := db.Query(query, assignment.CourseId, assignment.UnitId, assignment.Id)
+	if err != nil {
+		return nil, err
+	}
+	submissions := make([]AssignmentSubmission, 0)
+	index := make(map[int]int)
+	now := time.Now()
+	for rows.Next() {
+		var submission AssignmentSubmission
+		var started bool
+		if err := rows.Scan(&submission.UserId, &submission.Username, &started, &submission.SubmittedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		submission.Status, submission.Overdue = assignmentStatus(started, submission.SubmittedAt, assignment.DueAt, now)
+		submission.Decisions = make([]AssignmentDecision, 0)
+		index[submission.UserId] = len(submissions)
+		submissions = append(submissions, submission)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	query = `
+		SELECT page_results.user_id, pages.page_id, row_results.row_id, row_results.decision FROM row_results
+		JOIN page_results ON page_results.page_result_id = row_results.page_result_id
+		JOIN pages ON pages.page_id = page_results.page_id
+		LEFT JOIN rows ON rows.row_id = row_results.row_id
+		WHERE pages.unit_id=$2 AND page_results.user_id IN (SELECT user_id FROM course_members WHERE course_id=$1)
+		ORDER BY page_results.user_id, pages.position, pages.page_id, rows.position, row_results.row_id;
+		`
+	rows, err = db.Query(query, assignment.CourseId, assignment.UnitId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var userId int
+		var decision AssignmentDecision
+		if err := rows.Scan(&userId, &decision.PageId, &decision.RowId, &decision.Decision); err != nil {
+			return nil, err
+		}
+		if i, ok := index[userId]; ok {
+			submissions[i].Decisions = append(submissions[i].Decisions, decision)
+		}
+	}
+	return submissions, rows.Err()
+}
+
+func GetAchievements() ([]Achievement, error) {
+	rows, err := db.Query("SELECT title, COALESCE(description, ''), metric, threshold, achievement_id FROM achievements ORDER BY achievement_id;")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	achievements := make([]Achievement, 0)
+	for rows.Next() {
+		var achievement Achievement
+		if err := rows.Scan(&achievement.Title, &achievement.Description, &achievement.Metric, &achievement.Threshold, &achievement.Id); err != nil {
+			return nil, err
+		}
+		achievements = append(achievements, achievement)
+	}
+	return achievements, rows.Err()
+}
+
+func GetAchievementById(achievementId int) (Achievement, error) {
+	var achievement Achievement
+	err := db.QueryRow("SELECT title, COALESCE(description, ''), metric, threshold, achievement_id FROM achievements WHERE achievement_id=$1;", achievementId).
+		Scan(&achievement.Title, &achievement.Description, &achievement.Metric, &achievement.Threshold, &achievement.Id)
+	if err != nil {
+		return Achievement{}, err
+	}
+	return achievement, nil
+}
+
+func InsertAchievement(achievement Achievement) (int, error) {
+	var achievementId int
+	err := db.QueryRow("INSERT INTO achievements (title, description, metric, threshold) VALUES ($1, $2, $3, $4) RETURNING achievement_id;",
+		achievement.Title, achievement.Description, achievement.Metric, achievement.Threshold).Scan(&achievementId)
+	if err != nil {
+		return -1, err
+	}
+	return achievementId, nil
+}
+
+func DbUpdateAchievement(achievement Achievement) error {
+	result, err := db.Exec("UPDATE achievements SET title=$1, description=$2, metric=$3, threshold=$4 WHERE achievement_id=$5;",
+		achievement.Title, achievement.Description, achievement.Metric, achievement.Threshold, achievement.Id)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func DbDeleteAchievement(achievementId int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM user_achievements WHERE achievement_id=$1;", achievementId); err != nil {
+		return err
+	}
+	result, err := tx.Exec("DELETE FROM achievements WHERE achievement_id=$1;", achievementId)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return tx.Commit()
+}
+
+func GetUserAchievements(userId int) ([]UserAchievement, error) {
+	query := `
+		SELECT achievements.achievement_id, achievements.title, COALESCE(achievements.description, ''), user_achievements.awarded_at FROM user_achievements
+		JOIN achievements ON achievements.achievement_id = user_achievements.achievement_id
+		WHERE user_achievements.user_id=$1
+		ORDER BY user_achievements.awarded_at, achievements.achievement_id;
+		`
+	rows, err := db.Query(query, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	achievements := make([]UserAchievement, 0)
+	for rows.Next() {
+		var achievement UserAchievement
+		if err := rows.Scan(&achievement.AchievementId, &achievement.Title, &achievement.Description, &achievement.AwardedAt); err != nil {
+			return nil, err
+		}
+		achievements = append(achievements, achievement)
+	}
+	return achievements, rows.Err()
+}
+
+//EvaluateAchievements computes the metrics of all achievements the user does not have yet and awards those whose
+//threshold is reached. Every metric is queried only once.
+func EvaluateAchievements(userId int) ([]Achievement, error) {
+	rows, err := db.Query(`SELECT title, COALESCE(description, ''), metric, threshold, achievement_id FROM achievements
+		WHERE achievement_id NOT IN (SELECT achievement_id FROM user_achievements WHERE user_id=$1)
+		ORDER BY achievement_id;`, userId)
+	if err != nil {
+		return nil, err
+	}
+	pending := make([]Achievement, 0)
+	for rows.Next() {
+		var achievement Achievement
+		if err := rows.Scan(&achievement.Title, &achievement.Description, &achievement.Metric, &achievement.Threshold, &achievement.Id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		pending = append(pending, achievement)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	values := make(map[string]int)
+	awarded := make([]Achievement, 0)
+	for _, achievement := range pending {
+		query, ok := achievementMetrics[achievement.Metric]
+		if !ok {
+			continue
+		}
+		value, ok := values[achievement.Metric]
+		if !ok {
+			if err := db.QueryRow(query, userId).Scan(&value); err != nil && err != sql.ErrNoRows {
+				return nil, err
+			}
+			values[achievement.Metric] = value
+		}
+		if value < achievement.Threshold {
+			continue
+		}
+		if _, err := db.Exec("INSERT INTO user_achievements (user_id, achievement_id) VALUES ($1, $2) ON CONFLICT (user_id, achievement_id) DO NOTHING;", userId, achievement.Id); err != nil {
+			return nil, err
+		}
+		awarded = append(awarded, achievement)
+	}
+	return awarded, nil
+}
+
+func SetRotateImagePathAndNum(imageId int, imageDir string, imageCount int) error {
+	stmt, err := db.Prepare("UPDATE rotate_images SET basepath=$1, num=$2 WHERE rotate_image_id=$3")
 	if err != nil {
 		return err
 	}
-	_, err = stmt.Exec(path, id)
+	_, err = stmt.Exec(imageDir, imageCount, imageId)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func GetErrorImageById(id int) (ErrorImage, error) {
-	query := "SELECT error_images.*, json_agg(error_circles.*) FROM error_images LEFT JOIN error_circles ON error_circles.error_image_id=error_images.error_image_id WHERE error_images.error_image_id=$1 GROUP BY error_images.error_image_id;"
-	var path, circlesAgg string
-	var scale float64
-	var dbId, correctImageId, userId int
-	var published bool
-	err := db.QueryRow(query, id).Scan(&path, &correctImageId, &scale, &userId, &dbId, &published, &circlesAgg)
+func IsUsernameInDb(username string) (bool, error) {
+	var count int
+	row := db.QueryRow("SELECT COUNT(*) FROM users WHERE username=$1", username)
+	err := row.Scan(&count)
 	if err != nil {
-		return ErrorImage{}, err
+		return true, err
 	}
-	var errorCircles []Circle
-	if err := json.Unmarshal([]byte(circlesAgg), &errorCircles); err != nil {
-		return ErrorImage{}, err
+	return count > 0, nil
+}
+
+func GetRowUnitId(rowId int) (int, error) {
+	var unitId int
+	err := db.QueryRow("SELECT pages.unit_id FROM rows JOIN pages ON rows.page_id=pages.page_id WHERE rows.row_id=$1;", rowId).Scan(&unitId)
+	if err != nil {
+		return 0, err
 	}
-	return ErrorImage{path: path, CorrectImageId: correctImageId, Scale: scale, ID: dbId, ErrorCircles: errorCircles, UserId: userId, Published: published}, nil
+	return unitId, nil
 }
 
-func InsertRotateImage(image RotateImage) (int, error) {
-	query := "INSERT INTO rotate_images (caption, credits, num) VALUES ($1, $2, $3) RETURNING rotate_image_id;"
-	var imageId int
-	err := db.QueryRow(query, image.Caption, image.Credits, image.Num).Scan(&imageId)
+func GetRowById(rowId int) (Row, error) {
+	var row Row
+	query := "SELECT left_markdown, right_markdown, left_has_image, right_has_image, leftimage, rightimage, left_is_argument, right_is_argument, row_id, version, COALESCE(position, 0) FROM rows WHERE row_id=$1;"
+	err := db.QueryRow(query, rowId).Scan(&row.LeftMarkdown, &row.RightMarkdown, &row.LeftHasImage, &row.RightHasImage, &row.LeftImage, &row.RightImage, &row.LeftIsArgument, &row.RightIsArgument, &row.ID, &row.Version, &row.Position)
 	if err != nil {
-		return -1, err
+		return Row{}, err
 	}
-	return int(imageId), nil
+	return row, nil
 }
 
-func InsertPage(page Page) (Page, error) {
-	query := "INSERT INTO pages (page_title, page_type, unit_id) VALUES ($1, $2, $3) RETURNING page_id;"
-	var pageId int
-	err := db.QueryRow(query, page.Title, page.PageType, page.UnitID).Scan(&pageId)
+//RowDelete deletes the row and the decisions on it if version is still its current version. The version of
+//the page is incremented as well, since its content changed.
+func RowDelete(rowId, version int, revision UnitRevision) error {
+	tx, err := db.Begin()
 	if err != nil {
-		return Page{}, err
+		return err
 	}
-	page.ID = pageId
+	defer tx.Rollback()
+	var pageId int
+	err = tx.QueryRow("SELECT page_id FROM rows WHERE row_id=$1 AND version=$2 FOR UPDATE;", rowId, version).Scan(&pageId)
+	if err == sql.ErrNoRows {
+		return errVersionConflict
+	} else if err != nil {
+		return err
+	}
+	if err := deleteRows(tx, "row_id=$1", rowId); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE pages SET version=version+1 WHERE page_id=$1;", pageId); err != nil {
+		return err
+	}
+	if err := insertUnitRevision(tx, revision); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+//DbUpdatePageResult changes the decisions of a page result of the user. Every decision is also appended to
+//row_result_history so changes of mind can be analysed.
+func DbUpdatePageResult(user User, pageResult PageResult) error {
 	tx, err := db.Begin()
 	if err != nil {
-		return Page{}, err
+		return err
 	}
-	stmt, err := tx.Prepare("INSERT INTO rows (left_markdown, right_markdown, left_has_image, right_has_image, leftimage, rightimage, left_is_argument, right_is_argument, page_id) VALUES ($1, $2, $3, $4, $5 ,$6, $7, $8, $9) RETURNING row_id;")
+	defer tx.Rollback()
+	stmt, err := tx.Prepare("UPDATE row_results SET decision=$1 WHERE page_result_id=$2 AND row_id=$3 AND page_result_id IN (SELECT page_result_id FROM page_results WHERE user_id=$4)")
 	if err != nil {
-		return Page{}, err
+		return err
 	}
-	for idx, row := range page.Rows {
-		var rowId int
-		err := stmt.QueryRow(row.LeftMarkdown, row.RightMarkdown, row.LeftHasImage, row.RightHasImage, row.LeftImage, row.RightImage, row.LeftIsArgument, row.RightIsArgument, pageId).Scan(&rowId)
+	historyStmt, err := tx.Prepare("INSERT INTO row_result_history (decision, row_id, page_result_id, user_id) VALUES ($1, $2, $3, $4)")
+	if err != nil {
+		return err
+	}
+	for _, rowResult := range pageResult.RowResults {
+		result, err := stmt.Exec(rowResult.Decision, pageResult.Id, rowResult.RowID, user.ID)
 		if err != nil {
-			return Page{}, err
+			return err
+		}
+		if affected, err := result.RowsAffected(); err != nil {
+			return err
+		} else if affected == 0 {
+			continue
+		}
+		if _, err := historyStmt.Exec(rowResult.Decision, rowResult.RowID, pageResult.Id, user.ID); err != nil {
+			return err
 		}
-		log.Println(rowId)
-		page.Rows[idx].ID = rowId
 	}
-	log.Println(page.Rows)
-	err = tx.Commit()
-	if err != nil {
-		return Page{}, err
+	if err := saveQuestionResults(tx, user, pageResult.Id, pageResult.QuestionResults); err != nil {
+		return err
 	}
-	return page, nil
+	return tx.Commit()
 }
 
-func GetUserById(userId int) (User, error) {
-	query := `SELECT uo.username, uo.points, uo.active, 
-		(
-			SELECT COUNT(*) 
-			FROM users ui
-			WHERE (ui.points, ui.user_id) >= (uo.points, uo.user_id)
-		) AS rank,
-		json_agg(DISTINCT units.unit_id) AS units, 
-		json_agg(DISTINCT groups.group_name) AS groups,
-		json_agg(DISTINCT clicked_images.image_id) AS clicked_images,
-		json_agg(DISTINCT clicked_arguments.row_id) AS clicked_arguments,
-		json_agg(DISTINCT error_images.error_image_id) AS error_images
-		FROM users uo
-		LEFT JOIN units ON units.user_id=uo.user_id 
-		LEFT JOIN clicked_images ON clicked_images.user_id=uo.user_id 
-		LEFT JOIN clicked_arguments ON clicked_arguments.user_id=uo.user_id 
-		LEFT JOIN error_images ON error_images.user_id=uo.user_id 
-		LEFT JOIN user_groups ON uo.user_id=user_groups.user_id 
-		LEFT JOIN groups ON user_groups.group_id = groups.group_id
-		WHERE uo.user_id=$1 GROUP BY uo.user_id`
-	row := db.QueryRow(query, userId)
-	var dbUsername, jsonUnits, jsonGroups, jsonClickedIms, jsonClickedArgs, jsonErrorIms string
-	var points, rank uint
-	var active bool
-	err := row.Scan(&dbUsername, &points, &active, &rank, &jsonUnits, &jsonGroups, &jsonClickedIms, &jsonClickedArgs, &jsonErrorIms)
+func DbInsertPageResult(user User, pageResult PageResult) (int, error) {
+	tx, err := db.Begin()
 	if err != nil {
-		return User{}, err
+		return -1, err
 	}
-	var clickedIms []int
-	if jsonClickedIms == emptyArr {
-		clickedIms = make([]int, 0)
-	} else {
-		if err := json.Unmarshal([]byte(jsonClickedIms), &clickedIms); err != nil {
-			return User{}, err
+	defer tx.Rollback()
+	var pageResultId int
+	err = tx.QueryRow("INSERT INTO page_results (page_id, unit_id, user_id) VALUES ($1,$2,$3) RETURNING page_result_id", pageResult.PageId, pageResult.UnitId, user.ID).Scan(&pageResultId)
+	if err != nil {
+		return -1, err
+	}
+	stmt, err := tx.Prepare("INSERT INTO row_results (decision, row_id, page_result_id) VALUES ($1,$2,$3)")
+	if err != nil {
+		return -1, err
+	}
+	historyStmt, err := tx.Prepare("INSERT INTO row_result_history (decision, row_id, page_result_id, user_id) VALUES ($1, $2, $3, $4)")
+	if err != nil {
+		return -1, err
+	}
+	for _, rowResult := range pageResult.RowResults {
+		_, err := stmt.Exec(rowResult.Decision, rowResult.RowID, pageResultId)
+		if err != nil {
+			return -1, err
+		}
+		if _, err := historyStmt.Exec(rowResult.Decision, rowResult.RowID, pageResultId, user.ID); err != nil {
+			return -1, err
 		}
 	}
-	var clickedArgs []int
-	if jsonClickedArgs == emptyArr {
-		clickedArgs = make([]int, 0)
-	} else {
-		if err := json.Unmarshal([]byte(jsonClickedArgs), &clickedArgs); err != nil {
-			return User{}, err
+	if err := saveQuestionResults(tx, user, pageResultId, pageResult.QuestionResults); err != nil {
+		return -1, err
+	}
+	if err := tx.Commit(); err != nil {
+		return -1, err
+	}
+	return pageResultId, nil
+}
+
+func DbGetPageResult(pageResultId int) (PageResult, error) {
+	var unitId, pageId, userId int
+	var rowResultsAgg string
+	err := db.QueryRow("SELECT page_results.unit_id, page_results.user_id, page_results.page_id, json_agg(row_results.* ORDER BY rows.position, row_results.row_id) FROM page_results LEFT JOIN row_results ON row_results.page_result_id = page_results.page_result_id LEFT JOIN rows ON rows.row_id = row_results.row_id WHERE page_results.page_result_id=$1 GROUP BY page_results.page_result_id", pageResultId).Scan(&unitId, &userId, &pageId, &rowResultsAgg)
+	if err != nil {
+		return PageResult{}, err
+	}
+	var rowResults []Result
+	err = json.Unmarshal([]byte(rowResultsAgg), &rowResults)
+	if err != nil {
+		return PageResult{}, err
+	}
+	questionResults, err := getQuestionResults(pageResultId)
+	if err != nil {
+		return PageResult{}, err
+	}
+	return PageResult{rowResults, pageId, unitId, userId, pageResultId, questionResults}, nil
+}
+
+//saveQuestionResults stores graded answers of a page result of the user, a second answer to a question
+//replaces the first
+func saveQuestionResults(tx *sql.Tx, user User, pageResultId int, results []QuestionResult) error {
+	query := `
+		INSERT INTO question_results (choices, answer_text, correct, question_id, page_result_id)
+		SELECT $1, $2, $3, $4, page_result_id FROM page_results WHERE page_result_id=$5 AND user_id=$6
+		ON CONFLICT (page_result_id, question_id) DO UPDATE SET choices=EXCLUDED.choices, answer_text=EXCLUDED.answer_text, correct=EXCLUDED.correct;
+		`
+	for _, result := range results {
+		choices, err := jsonArray(result.Choices)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(query, choices, result.Text, result.Correct, result.QuestionId, pageResultId, user.ID); err != nil {
+			return err
 		}
 	}
-	var errorIms []int
-	if jsonErrorIms == emptyArr {
-		errorIms = make([]int, 0)
-	} else {
-		if err := json.Unmarshal([]byte(jsonErrorIms), &errorIms); err != nil {
-			return User{}, err
+	return nil
+}
+
+func getQuestionResults(pageResultId int) ([]QuestionResult, error) {
+	query := `
+		SELECT question_results.question_id, question_results.choices, question_results.answer_text, question_results.correct FROM question_results
+		LEFT JOIN page_questions ON page_questions.question_id = question_results.question_id
+		WHERE question_results.page_result_id=$1
+		ORDER BY page_questions.position, question_results.question_id;
+		`
+	rows, err := db.Query(query, pageResultId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	results := make([]QuestionResult, 0)
+	for rows.Next() {
+		var result QuestionResult
+		var choices string
+		if err := rows.Scan(&result.QuestionId, &choices, &result.Text, &result.Correct); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(choices), &result.Choices); err != nil {
+			return nil, err
 		}
+		results = append(results, result)
 	}
-	var groups []string
-	if err := json.Unmarshal([]byte(jsonGroups), &groups); err != nil {
-		return User{}, err
+	return results, rows.Err()
+}
+
+//DbInsertUnitResult stores the decision of the user for the unit. A user has only one decision per unit, a
+//second one replaces the first.
+func DbInsertUnitResult(user User, unitResult UnitResult) error {
+	query := `
+		INSERT INTO unit_results (decision, unit_id, user_id) VALUES ($1, $2, $3)
+		ON CONFLICT (unit_id, user_id) DO UPDATE SET decision=EXCLUDED.decision, updated_at=now();
+		`
+	_, err := db.Exec(query, unitResult.Decision, unitResult.UnitId, user.ID)
+	return err
+}
+
+func DbUpdateUnitResult(user User, unitResult UnitResult) error {
+	return DbInsertUnitResult(user, unitResult)
+}
+
+func DbGetUnitResults(unitId int) (UnitResults, error) {
+	query := `
+		SELECT count(*) FILTER (WHERE decision=$2), count(*) FILTER (WHERE decision=$3), count(*) FILTER (WHERE decision=$4)
+		FROM unit_results WHERE unit_id=$1;
+		`
+	results := UnitResults{UnitId: unitId}
+	err := db.QueryRow(query, unitId, decisionPro, decisionCon, decisionUndecided).Scan(&results.ProCount, &results.ConCount, &results.UndecidedCount)
+	if err != nil {
+		return UnitResults{}, err
 	}
-	var units []int
-	if jsonUnits == emptyArr {
-		units = make([]int, 0)
-	} else {
-		if err := json.Unmarshal([]byte(jsonUnits), &units); err != nil {
-			return User{}, err
+	return results, nil
+}
+
+//GetRowDecisionCounts counts the current decisions for every row of the unit. Rows without decisions are
+//included with a total of zero.
+func GetRowDecisionCounts(unitId int) ([]RowDecisions, error) {
+	query := `
+		SELECT pages.page_id, rows.row_id, COALESCE(row_results.decision, ''), count(row_results.row_result_id) FROM pages
+		JOIN rows ON rows.page_id = pages.page_id
+		LEFT JOIN row_results ON row_results.row_id = rows.row_id AND row_results.decision <> ''
+		WHERE pages.unit_id=$1
+		GROUP BY pages.page_id, rows.row_id, row_results.decision
+		ORDER BY pages.position, pages.page_id, rows.position, rows.row_id, row_results.decision;
+		`
+	dbRows, err := db.Query(query, unitId)
+	if err != nil {
+		return nil, err
+	}
+	defer dbRows.Close()
+	stats := make([]RowDecisions, 0)
+	for dbRows.Next() {
+		var pageId, rowId, count int
+		var decision string
+		if err := dbRows.Scan(&pageId, &rowId, &decision, &count); err != nil {
+			return nil, err
+		}
+		if len(stats) == 0 || stats[len(stats)-1].RowId != rowId {
+			stats = append(stats, RowDecisions{PageId: pageId, RowId: rowId, Decisions: make(map[string]int)})
+		}
+		if len(decision) > 0 {
+			stats[len(stats)-1].Decisions[decision] = count
+			stats[len(stats)-1].Total += count
 		}
 	}
-	u := User{Username: dbUsername, Units: units, Groups: groups, ID: userId, Points: points, Active: active, Rank: rank, ClickedImages: clickedIms, ClickedArguments: clickedArgs, ErrorImages: errorIms}
-	return u, nil
+	return stats, dbRows.Err()
 }
 
-func GetUserByName(username string) (User, error) {
-	row := db.QueryRow("SELECT users.*, json_agg(groups.group_name) FROM users LEFT JOIN user_groups ON users.user_id=user_groups.user_id LEFT JOIN groups ON user_groups.group_id=groups.group_id WHERE username=$1 GROUP BY users.user_id", username)
-	var dbUsername, salt, pwhash, jsonGroups, mailHash string
-	var active bool
-	var id int
-	var points uint
-	err := row.Scan(&dbUsername, &salt, &pwhash, &active, &id, &mailHash, &points, &jsonGroups)
+//GetPageCompletion counts per page how many participants of the unit started and completed it. A page is
+//completed when every argument row of it has a decision.
+func GetPageCompletion(unitId int) ([]PageCompletion, error) {
+	query := `
+		WITH required AS (
+			SELECT pages.page_id, count(rows.row_id) FILTER (WHERE rows.left_is_argument OR rows.right_is_argument) AS required FROM pages
+			LEFT JOIN rows ON rows.page_id = pages.page_id
+			WHERE pages.unit_id=$1
+			GROUP BY pages.page_id
+		), answered AS (
+			SELECT page_results.page_id, page_results.user_id, count(DISTINCT row_results.row_id) FILTER (WHERE row_results.decision <> '') AS answered FROM page_results
+			JOIN pages ON pages.page_id = page_results.page_id
+			LEFT JOIN row_results ON row_results.page_result_id = page_results.page_result_id
+			WHERE pages.unit_id=$1
+			GROUP BY page_results.page_id, page_results.user_id
+		)
+		SELECT pages.page_id, COALESCE(pages.page_title, ''), count(DISTINCT answered.user_id),
+		count(DISTINCT answered.user_id) FILTER (WHERE answered.answered >= required.required),
+		(SELECT count(DISTINCT page_results.user_id) FROM page_results JOIN pages ON pages.page_id = page_results.page_id WHERE pages.unit_id=$1)
+		FROM pages
+		JOIN required ON required.page_id = pages.page_id
+		LEFT JOIN answered ON answered.page_id = pages.page_id
+		WHERE pages.unit_id=$1
+		GROUP BY pages.page_id, pages.page_title, required.required
+		ORDER BY pages.position, pages.page_id;
+		`
+	rows, err := db.Query(query, unitId)
 	if err != nil {
-		return User{}, err
+		return nil, err
 	}
-	var groups []string
-	if err := json.Unmarshal([]byte(jsonGroups), &groups); err != nil {
-		return User{}, err
+	defer rows.Close()
+	completion := make([]PageCompletion, 0)
+	for rows.Next() {
+		var page PageCompletion
+		if err := rows.Scan(&page.PageId, &page.Title, &page.Started, &page.Completed, &page.Participants); err != nil {
+			return nil, err
+		}
+		if page.Participants > 0 {
+			page.CompletionRate = float64(page.Completed) / float64(page.Participants)
+		}
+		completion = append(completion, page)
 	}
-	u := User{dbUsername, groups, nil, id, salt, pwhash, active, mailHash, points, 0, "", nil, nil, nil}
-	return u, nil
+	return completion, rows.Err()
 }
 
-func InsertUser(user User) (int, error) {
-	query := "INSERT INTO users (username, salt, pwhash, active, mailhash) VALUES ($1, $2, $3, $4, $5) RETURNING user_id;"
-	var userId int
-	err := db.QueryRow(query, user.Username, user.salt, user.pwHash, user.Active, user.mailHash).Scan(&userId)
+//GetDecisionShifts compares the first and the last decision of every user for every row of the unit
+func GetDecisionShifts(unitId int) ([]DecisionShift, error) {
+	query := `
+		WITH attempts AS (
+			SELECT row_result_history.user_id, row_result_history.row_id, row_result_history.decision,
+			row_number() OVER (PARTITION BY row_result_history.user_id, row_result_history.row_id ORDER BY row_result_history.created_at, row_result_history.row_result_history_id) AS first_rank,
+			row_number() OVER (PARTITION BY row_result_history.user_id, row_result_history.row_id ORDER BY row_result_history.created_at DESC, row_result_history.row_result_history_id DESC) AS last_rank
+			FROM row_result_history
+			JOIN rows ON rows.row_id = row_result_history.row_id
+			JOIN pages ON pages.page_id = rows.page_id
+			WHERE pages.unit_id=$1 AND row_result_history.decision <> ''
+		)
+		SELECT pages.page_id, rows.row_id, f.decision, l.decision, count(*) FROM attempts f
+		JOIN attempts l ON l.user_id = f.user_id AND l.row_id = f.row_id AND l.last_rank = 1
+		JOIN rows ON rows.row_id = f.row_id
+		JOIN pages ON pages.page_id = rows.page_id
+		WHERE f.first_rank = 1
+		GROUP BY pages.page_id, rows.row_id, f.decision, l.decision
+		ORDER BY pages.position, pages.page_id, rows.position, rows.row_id, f.decision, l.decision;
+		`
+	rows, err := db.Query(query, unitId)
 	if err != nil {
-		return -1, err
+		return nil, err
 	}
-	return userId, nil
+	defer rows.Close()
+	shifts := make([]DecisionShift, 0)
+	for rows.Next() {
+		var shift DecisionShift
+		if err := rows.Scan(&shift.PageId, &shift.RowId, &shift.From, &shift.To, &shift.Count); err != nil {
+			return nil, err
+		}
+		shifts = append(shifts, shift)
+	}
+	return shifts, rows.Err()
 }
 
-func GetAllUsers() ([]User, error) {
-	query := `SELECT uo.username, uo.active, uo.user_id, uo.points, 
-		json_agg(DISTINCT units.unit_id) AS units, 
-		json_agg(DISTINCT groups.group_name),
-		(
-			SELECT COUNT(*) 
-			FROM users ui
-			WHERE (ui.points, ui.user_id) >= (uo.points, uo.user_id)
-		) AS rank
-		FROM users uo
-		LEFT JOIN units ON units.user_id=uo.user_id 
-		LEFT JOIN user_groups ON uo.user_id=user_groups.user_id 
-		LEFT JOIN groups ON user_groups.group_id = groups.group_id
-		GROUP BY uo.user_id`
-	rows, err := db.Query(query)
+//GetDecisionTrends counts the decisions made in the unit per bucket, which is one of trendBuckets
+func GetDecisionTrends(unitId int, bucket string) ([]DecisionTrend, error) {
+	query := `
+		SELECT date_trunc($2, row_result_history.created_at) AS bucket, row_result_history.decision, count(*), count(DISTINCT row_result_history.user_id)
+		FROM row_result_history
+		JOIN rows ON rows.row_id = row_result_history.row_id
+		JOIN pages ON pages.page_id = rows.page_id
+		WHERE pages.unit_id=$1 AND row_result_history.decision <> ''
+		GROUP BY bucket, row_result_history.decision
+		ORDER BY bucket, row_result_history.decision;
+		`
+	rows, err := db.Query(query, unitId, bucket)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	users := make([]User, 0)
+	trends := make([]DecisionTrend, 0)
 	for rows.Next() {
-		var dbUsername, jsonUnits, jsonGroups string
-		var active bool
-		var userId int
-		var points, rank uint
-		err := rows.Scan(&dbUsername, &active, &userId, &points, &jsonUnits, &jsonGroups, &rank)
-		if err != nil {
+		var trend DecisionTrend
+		if err := rows.Scan(&trend.Bucket, &trend.Decision, &trend.Count, &trend.Users); err != nil {
 			return nil, err
 		}
-		var groups []string
-		if err := json.Unmarshal([]byte(jsonGroups), &groups); err != nil {
-			return nil, err
-		}
-		var units []int
-		if jsonUnits == emptyArr {
-			units = make([]int, 0)
-		} else {
-			if err := json.Unmarshal([]byte(jsonUnits), &units); err != nil {
-				return nil, err
-			}
-		}
-		users = append(users, User{Username: dbUsername, Units: units, Groups: groups, Active: active, ID: userId, Points: points, Rank: rank})
+		trends = append(trends, trend)
 	}
-	return users, nil
+	return trends, rows.Err()
 }
 
-func AdminUpdateUser(user User) error {
-	stmt, err := db.Prepare("UPDATE users SET active=$1 WHERE user_id=$2;")
-	if err != nil {
-		return err
-	}
-	_, err = stmt.Exec(user.Active, user.ID)
-	if err != nil {
-		return err
-	}
-	err = UpdateGroups(user)
+func DbGetUserUnitResult(userId, unitId int) (UnitResult, error) {
+	unitResult := UnitResult{UnitId: unitId}
+	err := db.QueryRow("SELECT decision FROM unit_results WHERE unit_id=$1 AND user_id=$2;", unitId, userId).Scan(&unitResult.Decision)
 	if err != nil {
-		return err
+		return UnitResult{}, err
 	}
-	return nil
+	return unitResult, nil
 }
 
-func UpdateGroups(user User) error {
-	//first delete all old groups for user
-	stmt, err := db.Prepare("DELETE FROM user_groups WHERE user_id=$1;")
-	if err != nil {
-		return err
-	}
-	_, err = stmt.Exec(user.ID)
-	//now add groups
+func DbDeleteUnit(unitId int) error {
 	tx, err := db.Begin()
 	if err != nil {
 		return err
 	}
-	stmt, err = tx.Prepare("INSERT INTO user_groups (user_id, group_id) VALUES ($1, (SELECT group_id FROM groups WHERE group_name=$2));")
-	for _, group := range user.Groups {
-		_, err := stmt.Exec(user.ID, group)
-		if err != nil {
-			return err
-		}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM unit_members WHERE unit_id=$1", unitId); err != nil {
+		return err
 	}
-	err = tx.Commit()
-	if err != nil {
+	if _, err := tx.Exec("DELETE FROM units WHERE unit_id=$1", unitId); err != nil {
 		return err
 	}
-	return nil
+	return tx.Commit()
 }
 
-func UpdateUserPW(user User) error {
-	stmt, err := db.Prepare("UPDATE users SET pwhash=$1 WHERE user_id=$2;")
-	if err != nil {
-		return err
-	}
-	_, err = stmt.Exec(user.pwHash, user.ID)
+func GetUnitMemberRole(unitId, userId int) (string, error) {
+	var role string
+	err := db.QueryRow("SELECT role FROM unit_members WHERE unit_id=$1 AND user_id=$2;", unitId, userId).Scan(&role)
 	if err != nil {
-		return err
+		return "", err
 	}
-	return nil
+	return role, nil
 }
 
-func UserUpdateUser(user User) error {
-	stmt, err := db.Prepare("UPDATE users SET active=$1, username=$2, points=$3 WHERE user_id=$4;")
-	if err != nil {
-		return err
-	}
-	_, err = stmt.Exec(user.Active, user.Username, user.Points, user.ID)
-	if err != nil {
-		return err
-	}
-	//delete all clicked images of user
-	stmt, err = db.Prepare("DELETE from clicked_images WHERE user_id=$1;")
+func GetUnitMembers(unitId int) ([]UnitMember, error) {
+	query := `
+		SELECT unit_members.unit_id, unit_members.user_id, users.username, unit_members.role FROM unit_members
+		JOIN users ON users.user_id = unit_members.user_id
+		WHERE unit_members.unit_id = $1
+		ORDER BY unit_members.user_id;
+		`
+	rows, err := db.Query(query, unitId)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	_, err = stmt.Exec(user.ID)
-	if err != nil {
-		return err
+	defer rows.Close()
+	members := make([]UnitMember, 0)
+	for rows.Next() {
+		var member UnitMember
+		if err := rows.Scan(&member.UnitId, &member.UserId, &member.Username, &member.Role); err != nil {
+			return nil, err
+		}
+		members = append(members, member)
 	}
-	//delete all clicked arguments of user
-	stmt, err = db.Prepare("DELETE from clicked_arguments WHERE user_id=$1;")
+	return members, rows.Err()
+}
+
+func InsertUnitMember(member UnitMember) error {
+	stmt, err := db.Prepare("INSERT INTO unit_members (unit_id, user_id, role) VALUES ($1, $2, $3);")
 	if err != nil {
 		return err
 	}
-	_, err = stmt.Exec(user.ID)
+	_, err = stmt.Exec(member.UnitId, member.UserId, member.Role)
 	if err != nil {
 		return err
 	}
-	//insert clicked images
-	stmt, err = db.Prepare("INSERT INTO clicked_images (user_id, image_id) VALUES ($1, $2)")
+	return nil
+}
+
+func UpdateUnitMemberRole(member UnitMember) error {
+	stmt, err := db.Prepare("UPDATE unit_members SET role=$1 WHERE unit_id=$2 AND user_id=$3;")
 	if err != nil {
 		return err
 	}
-	for _, clickedIm := range user.ClickedImages {
-		_, err = stmt.Exec(user.ID, clickedIm)
-		if err != nil {
-			return err
-		}
-	}
-	//insert clicked arguments
-	stmt, err = db.Prepare("INSERT INTO clicked_arguments (user_id, row_id) VALUES ($1, $2)")
+	_, err = stmt.Exec(member.Role, member.UnitId, member.UserId)
 	if err != nil {
 		return err
 	}
-	for _, rowId := range user.ClickedArguments {
-		_, err = stmt.Exec(user.ID, rowId)
-		if err != nil {
-			return err
-		}
-	}
 	return nil
 }
 
-func SetRotateImagePathAndNum(imageId int, imageDir string, imageCount int) error {
-	stmt, err := db.Prepare("UPDATE rotate_images SET basepath=$1, num=$2 WHERE rotate_image_id=$3")
+func DeleteUnitMember(unitId, userId int) error {
+	stmt, err := db.Prepare("DELETE FROM unit_members WHERE unit_id=$1 AND user_id=$2;")
 	if err != nil {
 		return err
 	}
-	_, err = stmt.Exec(imageDir, imageCount, imageId)
+	_, err = stmt.Exec(unitId, userId)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func IsUsernameInDb(username string) (bool, error) {
+func CountUnitOwners(unitId int) (int, error) {
 	var count int
-	row := db.QueryRow("SELECT COUNT(*) FROM users WHERE username=$1", username)
-	err := row.Scan(&count)
-	if err != nil {
-		return true, err
-	}
-	return count > 0, nil
-}
-
-func GetRowOwnerId(rowId int) (int, error) {
-	var userId int
-	err := db.QueryRow("SELECT users.user_id FROM rows LEFT JOIN pages ON rows.page_id=pages.page_id LEFT JOIN units ON pages.unit_id=units.unit_id LEFT JOIN users ON units.user_id=users.user_id WHERE row_id=$1", rowId).Scan(&userId)
+	err := db.QueryRow("SELECT COUNT(*) FROM unit_members WHERE unit_id=$1 AND role=$2;", unitId, roleOwner).Scan(&count)
 	if err != nil {
 		return 0, err
 	}
-	return userId, nil
+	return count, nil
 }
 
-func RowDelete(rowId int) error {
-	stmt, err := db.Prepare("DELETE FROM rows WHERE row_id=$1")
+func DbDeletePage(pageId int, revision UnitRevision) error {
+	tx, err := db.Begin()
 	if err != nil {
 		return err
 	}
-	_, err = stmt.Exec(rowId)
-	if err != nil {
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM pages WHERE page_id=$1", pageId); err != nil {
 		return err
 	}
-	return nil
+	if err := insertUnitRevision(tx, revision); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-func DbUpdatePageResult(user User, pageResult PageResult) error {
-	//TODO (maybe before calling this?) check wether page_result.user_id == user.id
-	stmt, err := db.Prepare("UPDATE row_results SET decision=$1 WHERE page_result_id=$2 AND row_id=$3")
+//GetErrorImages returns the error images with the given moderation status
+func GetErrorImages(status string) ([]ErrorImage, error) {
+	return queryErrorImages("WHERE COALESCE(error_images.moderation_status, 'pending')=$1", status)
+}
+
+func GetUserErrorImages(userId int) ([]ErrorImage, error) {
+	return queryErrorImages("WHERE error_images.user_id=$1", userId)
+}
+
+func queryErrorImages(condition string, args ...interface{}) ([]ErrorImage, error) {
+	rows, err := db.Query(errorImageSelect+condition+errorImageGroupBy+" ORDER BY error_images.error_image_id", args...)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	for _, rowResult := range pageResult.RowResults {
-		_, err := stmt.Exec(rowResult.Decision, pageResult.Id, rowResult.RowID)
+	defer rows.Close()
+	imgs := make([]ErrorImage, 0)
+	for rows.Next() {
+		errorImage, err := scanErrorImage(rows)
 		if err != nil {
-			return err
+			return nil, err
 		}
+		imgs = append(imgs, errorImage)
 	}
-	return nil
+	return imgs, rows.Err()
 }
 
-func DbInsertPageResult(user User, pageResult PageResult) (int, error) {
-	var pageResultId int
-	err := db.QueryRow("INSERT INTO page_results (page_id, unit_id, user_id) VALUES ($1,$2,$3) RETURNING page_result_id", pageResult.PageId, pageResult.UnitId, user.ID).Scan(&pageResultId)
-	if err != nil {
-		return -1, err
-	}
-	stmt, err := db.Prepare("INSERT INTO row_results (decision, row_id, page_result_id) VALUES ($1,$2,$3)")
+func GetAgeKnownImages() ([]Image, error) {
+	rows, err := db.Query("SELECT images.*, "+unitVisible+", units.user_id from images LEFT JOIN units ON units.unit_id=images.unit_id WHERE age_known=true")
 	if err != nil {
-		return -1, err
+		return nil, err
 	}
-	for _, rowResult := range pageResult.RowResults {
-		_, err := stmt.Exec(rowResult.Decision, rowResult.RowID, pageResultId)
+	defer rows.Close()
+	var path, caption, credits string
+	var unitId, userId, id, age, imprecision int
+	var published, ageKnown bool
+	imgs := make([]Image, 0)
+	for rows.Next() {
+		err := rows.Scan(&path, &caption, &credits, &unitId, &id, &ageKnown, &age, &imprecision, &published, &userId)
 		if err != nil {
-			return -1, err
+			return nil, err
 		}
+		imgs = append(imgs, Image{Caption: caption, Credits: credits, UnitId: unitId, UserId: userId, ID: id, published: published, AgeKnown: ageKnown, Age: age, Imprecision: imprecision})
 	}
-	return pageResultId, nil
+	return imgs, nil
 }
 
-func DbGetPageResult(pageResultId int) (PageResult, error) {
-	var unitId, pageId, userId int
-	var rowResultsAgg string
-	err := db.QueryRow("SELECT page_results.unit_id, page_results.user_id, page_results.page_id, json_agg(row_results.* ORDER BY row_results.row_id) FROM page_results LEFT JOIN row_results ON row_results.page_result_id = page_results.page_result_id WHERE page_results.page_result_id=$1 GROUP BY page_results.page_result_id", pageResultId).Scan(&unitId, &userId, &pageId, &rowResultsAgg)
+//citeSelect selects everything scanCite expects
+const citeSelect = `SELECT abbrev, COALESCE(cite_text, ''), unit_id, cite_id, COALESCE(cite_type, ''), COALESCE(authors, '[]'), COALESCE(title, ''),
+	COALESCE(year, 0), COALESCE(publisher, ''), COALESCE(url, ''), COALESCE(doi, '') FROM cites `
+
+func scanCite(row scanner) (Cite, error) {
+	var cite Cite
+	var authors string
+	err := row.Scan(&cite.Abbrev, &cite.Text, &cite.UnitID, &cite.ID, &cite.Type, &authors, &cite.Title, &cite.Year, &cite.Publisher, &cite.URL, &cite.DOI)
 	if err != nil {
-		return PageResult{}, err
+		return Cite{}, err
 	}
-	var rowResults []Result
-	err = json.Unmarshal([]byte(rowResultsAgg), &rowResults)
-	if err != nil {
-		return PageResult{}, err
+	if err := json.Unmarshal([]byte(authors), &cite.Authors); err != nil {
+		return Cite{}, err
 	}
-	return PageResult{rowResults, pageId, unitId, userId, pageResultId}, nil
+	return cite, nil
 }
 
-func DbInsertUnitResult(user User, unitResult UnitResult) error {
-	//TODO
-	return nil
+func citeArgs(cite Cite) ([]interface{}, error) {
+	if cite.Authors == nil {
+		cite.Authors = make([]string, 0)
+	}
+	authors, err := json.Marshal(cite.Authors)
+	if err != nil {
+		return nil, err
+	}
+	return []interface{}{cite.Abbrev, cite.Text, cite.UnitID, cite.Type, string(authors), cite.Title, cite.Year, cite.Publisher, cite.URL, cite.DOI}, nil
 }
 
-func DbUpdateUnitResult(user User, unitResult UnitResult) error {
-	//TODO
-	return nil
+const insertCite = "INSERT INTO cites (abbrev, cite_text, unit_id, cite_type, authors, title, year, publisher, url, doi) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING cite_id;"
+
+func InsertCite(cite Cite) (int, error) {
+	args, err := citeArgs(cite)
+	if err != nil {
+		return -1, err
+	}
+	var citeId int
+	err = db.QueryRow(insertCite, args...).Scan(&citeId)
+	if err != nil {
+		return -1, err
+	}
+	return citeId, nil
 }
 
-func DbGetUnitResults(unitId int) (UnitResult, error) {
-	//TODO
-	return UnitResult{}, nil
+//InsertCites inserts all cites or none of them
+func InsertCites(cites []Cite) ([]Cite, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	for idx, cite := range cites {
+		args, err := citeArgs(cite)
+		if err != nil {
+			return nil, err
+		}
+		if err := tx.QueryRow(insertCite, args...).Scan(&cites[idx].ID); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return cites, nil
 }
 
-func DbDeleteUnit(unitId int) error {
-	stmt, err := db.Prepare("DELETE FROM units WHERE unit_id=$1")
+func DbDeleteCite(cite Cite) error {
+	stmt, err := db.Prepare("DELETE FROM cites WHERE cite_id=$1;")
 	if err != nil {
 		return err
 	}
-	_, err = stmt.Exec(unitId)
+	_, err = stmt.Exec(cite.ID)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func DbDeletePage(pageId int) error {
-	stmt, err := db.Prepare("DELETE FROM pages WHERE page_id=$1")
+func DbUpdateCite(cite Cite) error {
+	args, err := citeArgs(cite)
+	if err != nil {
+		return err
+	}
+	stmt, err := db.Prepare("UPDATE cites SET abbrev=$1, cite_text=$2, cite_type=$4, authors=$5, title=$6, year=$7, publisher=$8, url=$9, doi=$10 WHERE cite_id=$11 AND unit_id=$3;")
 	if err != nil {
 		return err
 	}
-	_, err = stmt.Exec(pageId)
+	_, err = stmt.Exec(append(args, cite.ID)...)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func GetErrorImages() ([]ErrorImage, error) {
-	rows, err := db.Query("SELECT error_images.*, json_agg(error_circles.*) FROM error_images LEFT JOIN error_circles ON error_circles.error_image_id = error_images.error_image_id GROUP BY error_images.error_image_id")
+func GetCiteById(citeId int) (Cite, error) {
+	return scanCite(db.QueryRow(citeSelect+"WHERE cite_id=$1;", citeId))
+}
+
+func GetUnitCites(unitId int) ([]Cite, error) {
+	rows, err := db.Query(citeSelect+"WHERE unit_id=$1 ORDER BY cite_id;", unitId)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var path string
-	var correctImageId int
-	var scale float64
-	var userId int
-	var id int
-	var errorCircleJson string
-	var published bool
-	imgs := make([]ErrorImage, 0)
+	cites := make([]Cite, 0)
 	for rows.Next() {
-		err := rows.Scan(&path, &correctImageId, &scale, &userId, &id, &published, &errorCircleJson)
+		cite, err := scanCite(rows)
 		if err != nil {
 			return nil, err
 		}
-		var errorCircles []Circle
-		err = json.Unmarshal([]byte(errorCircleJson), &errorCircles)
-		if err != nil {
-			return nil, err
-		}
-		imgs = append(imgs, ErrorImage{path, correctImageId, scale, errorCircles, userId, id, published})
+		cites = append(cites, cite)
 	}
-	return imgs, nil
+	return cites, rows.Err()
 }
 
-func GetAgeKnownImages() ([]Image, error) {
-	rows, err := db.Query("SELECT images.*, units.published, units.user_id from images LEFT JOIN units ON units.unit_id=images.unit_id WHERE age_known=true")
+//IsCiteAbbrevInUnit checks whether another cite of the unit than citeId already uses abbrev
+func IsCiteAbbrevInUnit(unitId int, abbrev string, citeId int) (bool, error) {
+	var count int
+	err := db.QueryRow("SELECT count(*) FROM cites WHERE unit_id=$1 AND abbrev=$2 AND cite_id<>$3;", unitId, abbrev, citeId).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+//GetUnitMarkdown returns the markdown of all rows of the unit in reading order
+func GetUnitMarkdown(unitId int) ([]string, error) {
+	query := `
+		SELECT rows.left_markdown, rows.right_markdown FROM rows
+		JOIN pages ON pages.page_id = rows.page_id
+		WHERE pages.unit_id=$1
+		ORDER BY pages.position, pages.page_id, rows.position, rows.row_id;
+		`
+	rows, err := db.Query(query, unitId)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var path, caption, credits string
-	var unitId, userId, id, age, imprecision int
-	var published, ageKnown bool
-	imgs := make([]Image, 0)
+	markdown := make([]string, 0)
 	for rows.Next() {
-		err := rows.Scan(&path, &caption, &credits, &unitId, &id, &ageKnown, &age, &imprecision, &published, &userId)
-		if err != nil {
+		var left, right sql.NullString
+		if err := rows.Scan(&left, &right); err != nil {
 			return nil, err
 		}
-		imgs = append(imgs, Image{Caption: caption, Credits: credits, UnitId: unitId, UserId: userId, ID: id, published: published, AgeKnown: ageKnown, Age: age, Imprecision: imprecision})
+		markdown = append(markdown, left.String, right.String)
 	}
-	return imgs, nil
+	return markdown, rows.Err()
 }
 
 /*
@@ -1139,37 +3957,4 @@ CREATE TABLE IF NOT EXISTS page_results (
 	user_id integer,
 	page_result_id SERIAL PRIMARY KEY
 );
-func InsertCite(cite Cite) (int, error) {
-	query := "INSERT INTO cites (abbrev, cite_text, unit_id) VALUES ($1, $2, $3) RETURNING cite_id;"
-	var userId int
-	err := db.QueryRow(query, cite.Abbrev, cite.Text, cite.UnitID).Scan(&userId)
-	if err != nil {
-		return -1, err
-	}
-	return userId, nil
-}
-
-func DeleteCite(cite Cite) error {
-	stmt, err := db.Prepare("DELETE FROM cites WHERE cite_id=$1;")
-	if err != nil {
-		return err
-	}
-	_, err = stmt.Exec(cite.ID)
-	if err != nil {
-		return err
-	}
-	return nil
-}
-
-func UpdateCite(cite Cite) error {
-	stmt, err := db.Prepare("UPDATE cites SET abbrev=$1, cite_text=$2 WHERE cite_id=$3;")
-	if err != nil {
-		return err
-	}
-	_, err = stmt.Exec(cite.Abbrev, cite.Text, cite.ID)
-	if err != nil {
-		return err
-	}
-	return nil
-}
 */
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var testDBOnce sync.Once
+
+//testDB connects to the database in OIKOS_TEST_DB ("dbname user password") and creates the schema. Tests that
+//need the database are skipped without it. Every test creates its own users and units.
+func testDB(t *testing.T) {
+	t.Helper()
+	conf := strings.Fields(os.Getenv("OIKOS_TEST_DB"))
+	if len(conf) != 3 {
+		t.Skip("OIKOS_TEST_DB is not set")
+	}
+	testDBOnce.Do(func() {
+		initDB(conf[0], conf[1], conf[2])
+	})
+}
+
+func testUser(t *testing.T, name string) User {
+	t.Helper()
+	user := User{Username: fmt.Sprintf("%s-%d", name, time.Now().UnixNano()), Active: true}
+	id, err := InsertUser(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user.ID = id
+	return user
+}
+
+//testUnitPage creates a unit of owner with one page and returns the page as stored
+func testUnitPage(t *testing.T, owner User, pageType string, rows []Row, questions []Question) Page {
+	t.Helper()
+	unitId, err := InsertUnit(Unit{Title: t.Name(), UserId: owner.ID}, newRevision(0, owner, "create unit"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, err := InsertPage(Page{Title: t.Name(), UnitID: unitId, PageType: pageType, Rows: rows, Questions: questions}, newRevision(unitId, owner, "create page"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page, err = GetPageById(page.ID); err != nil {
+		t.Fatal(err)
+	}
+	return page
+}
+
+func countRows(t *testing.T, query string, args ...interface{}) int {
+	t.Helper()
+	var count int
+	if err := db.QueryRow(query, args...).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+//TestUnitFinishedIsTheSameEverywhere decides a unit step by step and checks that points, the units-finished
+//achievement and the course progress agree on when it is finished
+func TestUnitFinishedIsTheSameEverywhere(t *testing.T) {
+	testDB(t)
+	teacher, student := testUser(t, "teacher"), testUser(t, "student")
+	page := testUnitPage(t, teacher, pageTypeDefault, []Row{{LeftMarkdown: "argument", LeftIsArgument: true}, {LeftMarkdown: "text"}}, nil)
+	courseId, err := InsertCourse(Course{Title: t.Name(), UserId: teacher.ID, JoinCode: fmt.Sprint(time.Now().UnixNano()), Units: []int{page.UnitID}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := InsertCourseMember(courseId, student.ID); err != nil {
+		t.Fatal(err)
+	}
+	check := func(step string, missing int) {
+		t.Helper()
+		got, err := CountMissingDecisions(student.ID, page.UnitID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		finished := countRows(t, achievementMetrics["units-finished"], student.ID) == 1
+		progress, err := GetCourseProgress(courseId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != missing || finished != (missing == 0) || len(progress) != 1 || progress[0].Units[0].Finished != (missing == 0) {
+			t.Errorf("%s: %d missing, want %d; achievement %v; progress %+v", step, got, missing, finished, progress)
+		}
+	}
+	check("nothing decided", 2)
+	if err := DbInsertUnitResult(student, UnitResult{Decision: decisionPro, UnitId: page.UnitID}); err != nil {
+		t.Fatal(err)
+	}
+	check("only the unit decided", 1)
+	pageResult := PageResult{PageId: page.ID, UnitId: page.UnitID, RowResults: []Result{{Decision: "left", RowID: page.Rows[0].ID}}}
+	if _, err := DbInsertPageResult(student, pageResult); err != nil {
+		t.Fatal(err)
+	}
+	check("everything decided", 0)
+}
+
+//TestDeletingRowsDeletesTheirResults deletes rows in the ways an editor can and checks that no decisions on
+//them are left
+func TestDeletingRowsDeletesTheirResults(t *testing.T) {
+	testDB(t)
+	owner, student := testUser(t, "owner"), testUser(t, "student")
+	page := testUnitPage(t, owner, pageTypeDefault, []Row{{LeftIsArgument: true}, {LeftIsArgument: true}, {RightIsArgument: true}}, nil)
+	results := make([]Result, 0)
+	for _, row := range page.Rows {
+		results = append(results, Result{Decision: "left", RowID: row.ID})
+	}
+	if _, err := DbInsertPageResult(student, PageResult{PageId: page.ID, UnitId: page.UnitID, RowResults: results}); err != nil {
+		t.Fatal(err)
+	}
+	left := func(rowId int) int {
+		return countRows(t, "SELECT count(*) FROM row_results WHERE row_id=$1", rowId) +
+			countRows(t, "SELECT count(*) FROM row_result_history WHERE row_id=$1", rowId)
+	}
+
+	if err := RowDelete(page.Rows[0].ID, page.Rows[0].Version, newRevision(page.UnitID, owner, "delete row")); err != nil {
+		t.Fatal(err)
+	}
+	if n := left(page.Rows[0].ID); n != 0 {
+		t.Errorf("DELETE left %d results", n)
+	}
+
+	current, err := GetPageById(page.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	current.Rows = current.Rows[1:]
+	if _, err := DbUpdatePage(current, newRevision(page.UnitID, owner, "update page")); err != nil {
+		t.Fatal(err)
+	}
+	if n := left(page.Rows[1].ID); n != 0 {
+		t.Errorf("saving the page without the row left %d results", n)
+	}
+	if n := left(page.Rows[2].ID); n != 2 {
+		t.Errorf("the kept row has %d results, want its decision and its history", n)
+	}
+}
+
+func TestAssignmentsOfCourses(t *testing.T) {
+	testDB(t)
+	teacher, student := testUser(t, "teacher"), testUser(t, "student")
+	page := testUnitPage(t, teacher, pageTypeDefault, []Row{{LeftIsArgument: true}}, nil)
+	courseId, err := InsertCourse(Course{Title: t.Name(), UserId: teacher.ID, JoinCode: fmt.Sprint(time.Now().UnixNano()), Units: []int{page.UnitID}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	//the teacher tries the course as a student
+	for _, member := range []User{teacher, student} {
+		if err := InsertCourseMember(courseId, member.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	assignmentId, err := InsertAssignment(Assignment{CourseId: courseId, UnitId: page.UnitID, Title: "read", DueAt: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := InsertAssignmentSubmission(assignmentId, student.ID); err != nil {
+		t.Fatal(err)
+	}
+	assignments, err := GetUserAssignments(teacher.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(assignments) != 1 || assignments[0].Id != assignmentId {
+		t.Errorf("the teacher gets %+v, want the assignment once", assignments)
+	}
+
+	if err := DbDeleteCourse(courseId); err != nil {
+		t.Fatal(err)
+	}
+	if n := countRows(t, "SELECT count(*) FROM assignments WHERE assignment_id=$1", assignmentId); n != 0 {
+		t.Error("the assignment outlived its course")
+	}
+	if n := countRows(t, "SELECT count(*) FROM assignment_submissions WHERE assignment_id=$1", assignmentId); n != 0 {
+		t.Error("the submission outlived its course")
+	}
+}
+
+//TestQuestionResultsInPageOrder checks that answers come back in the order of the questions on the page, not
+//in the order the questions were created
+func TestQuestionResultsInPageOrder(t *testing.T) {
+	testDB(t)
+	owner, student := testUser(t, "owner"), testUser(t, "student")
+	questions := []Question{{Prompt: "first", Accepted: []string{"a"}}, {Prompt: "second", Accepted: []string{"b"}}}
+	page := testUnitPage(t, owner, pageTypeFreeText, nil, questions)
+	page.Questions[0], page.Questions[1] = page.Questions[1], page.Questions[0]
+	page, err := DbUpdatePage(page, newRevision(page.UnitID, owner, "update page"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := []QuestionResult{{QuestionId: page.Questions[1].Id, Text: "a"}, {QuestionId: page.Questions[0].Id, Text: "b"}}
+	pageResultId, err := DbInsertPageResult(student, PageResult{PageId: page.ID, UnitId: page.UnitID, QuestionResults: results})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pageResult, err := DbGetPageResult(pageResultId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pageResult.QuestionResults) != 2 || pageResult.QuestionResults[0].Text != "b" {
+		t.Errorf("got %+v, want the answer to the second question first", pageResult.QuestionResults)
+	}
+}
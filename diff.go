@@ -0,0 +1,184 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+)
+
+const (
+	changeAdded   = "added"
+	changeRemoved = "removed"
+	changeChanged = "changed"
+)
+
+//DiffLine is one line of a text diff. Op is "=" for unchanged, "-" for removed and "+" for added lines.
+type DiffLine struct {
+	Op   string `json:"op"`
+	Text string `json:"text"`
+}
+
+type FieldChange struct {
+	Field string      `json:"field"`
+	Old   interface{} `json:"old"`
+	New   interface{} `json:"new"`
+}
+
+type RowDiff struct {
+	RowId         int           `json:"row"`
+	Change        string        `json:"change"`
+	Fields        []FieldChange `json:"fields"`
+	LeftMarkdown  []DiffLine    `json:"leftMarkdown,omitempty"`
+	RightMarkdown []DiffLine    `json:"rightMarkdown,omitempty"`
+}
+
+type PageDiff struct {
+	PageId int           `json:"page"`
+	Change string        `json:"change"`
+	Fields []FieldChange `json:"fields"`
+	Rows   []RowDiff     `json:"rows"`
+}
+
+//UnitDiff lists everything that changed between two revisions. Unchanged pages and rows are left out.
+type UnitDiff struct {
+	From   int           `json:"from"`
+	To     int           `json:"to"`
+	Fields []FieldChange `json:"fields"`
+	Pages  []PageDiff    `json:"pages"`
+}
+
+func diffUnitSnapshots(from, to UnitSnapshot) UnitDiff {
+	diff := UnitDiff{Fields: make([]FieldChange, 0), Pages: make([]PageDiff, 0)}
+	diff.Fields = appendChange(diff.Fields, "title", from.Title, to.Title)
+	diff.Fields = appendChange(diff.Fields, "rotateImage", from.UnitImageID, to.UnitImageID)
+	diff.Fields = appendChange(diff.Fields, "color_scheme", from.ColorScheme, to.ColorScheme)
+	diff.Fields = appendChange(diff.Fields, "front_image", from.FrontImage, to.FrontImage)
+
+	toPages := make(map[int]PageSnapshot)
+	for _, page := range to.Pages {
+		toPages[page.ID] = page
+	}
+	seen := make(map[int]bool)
+	for _, oldPage := range from.Pages {
+		seen[oldPage.ID] = true
+		if newPage, ok := toPages[oldPage.ID]; ok {
+			if pageDiff := diffPages(oldPage, newPage, changeChanged); len(pageDiff.Fields) > 0 || len(pageDiff.Rows) > 0 {
+				diff.Pages = append(diff.Pages, pageDiff)
+			}
+		} else {
+			diff.Pages = append(diff.Pages, diffPages(oldPage, PageSnapshot{ID: oldPage.ID}, changeRemoved))
+		}
+	}
+	for _, newPage := range to.Pages {
+		if !seen[newPage.ID] {
+			diff.Pages = append(diff.Pages, diffPages(PageSnapshot{ID: newPage.ID}, newPage, changeAdded))
+		}
+	}
+	return diff
+}
+
+func diffPages(from, to PageSnapshot, change string) PageDiff {
+	diff := PageDiff{PageId: from.ID, Change: change, Fields: make([]FieldChange, 0), Rows: make([]RowDiff, 0)}
+	diff.Fields = appendChange(diff.Fields, "title", from.Title, to.Title)
+	diff.Fields = appendChange(diff.Fields, "page_type", from.PageType, to.PageType)
+	diff.Fields = appendChange(diff.Fields, "position", from.Position, to.Position)
+	if (len(from.Questions) > 0 || len(to.Questions) > 0) && !reflect.DeepEqual(from.Questions, to.Questions) {
+		diff.Fields = append(diff.Fields, FieldChange{Field: "questions", Old: from.Questions, New: to.Questions})
+	}
+
+	toRows := make(map[int]Row)
+	for _, row := range to.Rows {
+		toRows[row.ID] = row
+	}
+	seen := make(map[int]bool)
+	for _, oldRow := range from.Rows {
+		seen[oldRow.ID] = true
+		if newRow, ok := toRows[oldRow.ID]; ok {
+			if rowDiff := diffRows(oldRow, newRow, changeChanged); len(rowDiff.Fields) > 0 || len(rowDiff.LeftMarkdown) > 0 || len(rowDiff.RightMarkdown) > 0 {
+				diff.Rows = append(diff.Rows, rowDiff)
+			}
+		} else {
+			diff.Rows = append(diff.Rows, diffRows(oldRow, Row{ID: oldRow.ID}, changeRemoved))
+		}
+	}
+	for _, newRow := range to.Rows {
+		if !seen[newRow.ID] {
+			diff.Rows = append(diff.Rows, diffRows(Row{ID: newRow.ID}, newRow, changeAdded))
+		}
+	}
+	return diff
+}
+
+func diffRows(from, to Row, change string) RowDiff {
+	diff := RowDiff{RowId: from.ID, Change: change, Fields: make([]FieldChange, 0)}
+	diff.Fields = appendChange(diff.Fields, "left_has_image", from.LeftHasImage, to.LeftHasImage)
+	diff.Fields = appendChange(diff.Fields, "right_has_image", from.RightHasImage, to.RightHasImage)
+	diff.Fields = appendChange(diff.Fields, "leftImage", from.LeftImage, to.LeftImage)
+	diff.Fields = appendChange(diff.Fields, "rightImage", from.RightImage, to.RightImage)
+	diff.Fields = appendChange(diff.Fields, "left_is_argument", from.LeftIsArgument, to.LeftIsArgument)
+	diff.Fields = appendChange(diff.Fields, "right_is_argument", from.RightIsArgument, to.RightIsArgument)
+	diff.Fields = appendChange(diff.Fields, "position", from.Position, to.Position)
+	if from.LeftMarkdown != to.LeftMarkdown {
+		diff.LeftMarkdown = diffLines(from.LeftMarkdown, to.LeftMarkdown)
+	}
+	if from.RightMarkdown != to.RightMarkdown {
+		diff.RightMarkdown = diffLines(from.RightMarkdown, to.RightMarkdown)
+	}
+	return diff
+}
+
+func appendChange(changes []FieldChange, field string, old, new interface{}) []FieldChange {
+	if old == new {
+		return changes
+	}
+	return append(changes, FieldChange{Field: field, Old: old, New: new})
+}
+
+func splitLines(text string) []string {
+	if len(text) == 0 {
+		return []string{}
+	}
+	return strings.Split(text, "\n")
+}
+
+//diffLines computes a line based diff of two texts using their longest common subsequence
+func diffLines(from, to string) []DiffLine {
+	a, b := splitLines(from), splitLines(to)
+	//lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	lines := make([]DiffLine, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		if a[i] == b[j] {
+			lines = append(lines, DiffLine{"=", a[i]})
+			i++
+			j++
+		} else if lcs[i+1][j] >= lcs[i][j+1] {
+			lines = append(lines, DiffLine{"-", a[i]})
+			i++
+		} else {
+			lines = append(lines, DiffLine{"+", b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		lines = append(lines, DiffLine{"-", a[i]})
+	}
+	for ; j < len(b); j++ {
+		lines = append(lines, DiffLine{"+", b[j]})
+	}
+	return lines
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestDiffLinesRebuildsBothTexts checks that the kept and removed lines give the old text and the kept and added
+// lines the new one, including empty texts and trailing newlines
+func TestDiffLinesRebuildsBothTexts(t *testing.T) {
+	pairs := [][2]string{
+		{"", ""},
+		{"", "a"},
+		{"a", ""},
+		{"a", "a\n"},
+		{"\n\n", "\n"},
+		{"a\nb\nc", "c\nb\na"},
+		{"# Title\n\nFirst paragraph\nSecond paragraph", "# Title\n\nFirst paragraph, changed\n\nSecond paragraph\nThird"},
+	}
+	for _, pair := range pairs {
+		var from, to []string
+		for _, line := range diffLines(pair[0], pair[1]) {
+			switch line.Op {
+			case "=":
+				from = append(from, line.Text)
+				to = append(to, line.Text)
+			case "-":
+				from = append(from, line.Text)
+			case "+":
+				to = append(to, line.Text)
+			default:
+				t.Fatalf("unknown op %q", line.Op)
+			}
+		}
+		if strings.Join(from, "\n") != pair[0] || strings.Join(to, "\n") != pair[1] {
+			t.Errorf("diff of %q and %q rebuilds %q and %q", pair[0], pair[1], strings.Join(from, "\n"), strings.Join(to, "\n"))
+		}
+	}
+}
+
+// TestDiffLinesKeepsTheLongestCommonPart checks that moving one line only reports that line, not the whole text
+func TestDiffLinesKeepsTheLongestCommonPart(t *testing.T) {
+	lines := diffLines("intro\na\nb\nc", "a\nb\nc\nintro")
+	changed := 0
+	for _, line := range lines {
+		if line.Op != "=" {
+			changed++
+			if line.Text != "intro" {
+				t.Errorf("%q is reported as changed", line.Text)
+			}
+		}
+	}
+	if changed != 2 {
+		t.Errorf("got %d changed lines in %v, want the removed and the added intro", changed, lines)
+	}
+}
+
+func TestDiffUnitSnapshots(t *testing.T) {
+	from := UnitSnapshot{Title: "Industrialisierung", ColorScheme: 1, Pages: []PageSnapshot{
+		{Title: "Start", PageType: "default", ID: 1, Rows: []Row{
+			{LeftMarkdown: "unchanged", ID: 10},
+			{LeftMarkdown: "one\ntwo", LeftIsArgument: true, ID: 11},
+			{RightMarkdown: "removed", ID: 12},
+		}},
+		{Title: "Removed", ID: 2},
+		{Title: "Unchanged", ID: 3, Rows: []Row{{LeftMarkdown: "same", ID: 30}}},
+	}}
+	to := UnitSnapshot{Title: "Industrialisierung", ColorScheme: 2, Pages: []PageSnapshot{
+		{Title: "Start", PageType: "default", ID: 1, Rows: []Row{
+			{LeftMarkdown: "unchanged", ID: 10},
+			{LeftMarkdown: "one\nthree", ID: 11},
+			{RightMarkdown: "added", ID: 13},
+		}},
+		{Title: "Unchanged", ID: 3, Rows: []Row{{LeftMarkdown: "same", ID: 30}}},
+		{Title: "Added", ID: 4},
+	}}
+	diff := diffUnitSnapshots(from, to)
+
+	if len(diff.Fields) != 1 || diff.Fields[0].Field != "color_scheme" || diff.Fields[0].Old != 1 || diff.Fields[0].New != 2 {
+		t.Errorf("unit fields: got %+v, want only the color scheme", diff.Fields)
+	}
+	changes := make(map[int]PageDiff)
+	for _, page := range diff.Pages {
+		changes[page.PageId] = page
+	}
+	if len(changes) != 3 || changes[1].Change != changeChanged || changes[2].Change != changeRemoved || changes[4].Change != changeAdded {
+		t.Fatalf("pages: got %+v, want 1 changed, 2 removed and 4 added", diff.Pages)
+	}
+	if _, ok := changes[3]; ok {
+		t.Errorf("the unchanged page 3 is listed")
+	}
+
+	rows := make(map[int]RowDiff)
+	for _, row := range changes[1].Rows {
+		rows[row.RowId] = row
+	}
+	if _, ok := rows[10]; ok || len(rows) != 3 {
+		t.Fatalf("rows of page 1: got %+v, want 11, 12 and 13", changes[1].Rows)
+	}
+	changed := rows[11]
+	if changed.Change != changeChanged || len(changed.Fields) != 1 || changed.Fields[0].Field != "left_is_argument" {
+		t.Errorf("row 11: got %+v, want the argument flag changed", changed)
+	}
+	want := []DiffLine{{"=", "one"}, {"-", "two"}, {"+", "three"}}
+	if len(changed.LeftMarkdown) != len(want) || changed.LeftMarkdown[1] != want[1] || changed.LeftMarkdown[2] != want[2] || changed.RightMarkdown != nil {
+		t.Errorf("row 11 markdown: got %v, want %v", changed.LeftMarkdown, want)
+	}
+	if rows[12].Change != changeRemoved || rows[13].Change != changeAdded {
+		t.Errorf("rows 12 and 13: got %+v and %+v", rows[12], rows[13])
+	}
+}
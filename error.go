@@ -4,3 +4,16 @@ type jsonErr struct {
 	Code    int    `json:"code"`
 	Message string `json:"message"`
 }
+
+//FieldError names an invalid field of a request, nested fields are separated by dots, e.g. rows.2.leftImage
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+//validationErr is the body of a 422 response listing the invalid fields
+type validationErr struct {
+	Code    int          `json:"code"`
+	Message string       `json:"message"`
+	Errors  []FieldError `json:"errors"`
+}
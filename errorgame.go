@@ -0,0 +1,58 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+//maxErrorCircles is the most circles an error image may have, every circle is worth points to its players
+const maxErrorCircles = 20
+
+//maxMissedClicks is how often an attempt may miss, so that players can not find the circles by clicking everywhere
+const maxMissedClicks = 10
+
+//ErrorImageAttempt is one play of an error image. It only tells how many errors were found, never where they are.
+type ErrorImageAttempt struct {
+	ErrorImageId int        `json:"errorImage"`
+	UserId       int        `json:"user"`
+	StartedAt    time.Time  `json:"startedAt"`
+	CompletedAt  *time.Time `json:"completedAt"`
+	Seconds      float64    `json:"seconds,omitempty"`
+	Clicks       int        `json:"clicks"`
+	Found        int        `json:"found"`
+	Remaining    int        `json:"remaining"`
+	Id           int        `json:"id"`
+}
+
+//ErrorImageClick is a click of a player in the coordinates of the displayed image. Scale is the ratio of the
+//displayed to the original size of the image, 1 if it is not sent.
+type ErrorImageClick struct {
+	X     float64 `json:"x"`
+	Y     float64 `json:"y"`
+	Scale float64 `json:"scale"`
+	Hit   bool    `json:"hit"`
+}
+
+//hitCircle returns the id of the first circle that is not found yet and contains the click. Circles are stored
+//in the coordinates of the image scaled by imageScale, clicks are scaled by their own scale, so both are
+//compared in the coordinates of the original image.
+func hitCircle(circles []Circle, imageScale float64, click ErrorImageClick, found map[int]bool) (int, bool) {
+	if imageScale <= 0 {
+		imageScale = 1
+	}
+	clickScale := click.Scale
+	if clickScale <= 0 {
+		clickScale = 1
+	}
+	x, y := click.X/clickScale, click.Y/clickScale
+	for _, circle := range circles {
+		if found[circle.ID] {
+			continue
+		}
+		centerX, centerY := float64(circle.CenterX)/imageScale, float64(circle.CenterY)/imageScale
+		if math.Hypot(x-centerX, y-centerY) <= circle.Radius/imageScale {
+			return circle.ID, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,57 @@
+package main
+
+import "testing"
+
+//TestHitCircleFindsEveryCircleOnce clicks the spot where two circles overlap until nothing is left, each click must
+//find another circle and never one twice
+func TestHitCircleFindsEveryCircleOnce(t *testing.T) {
+	circles := []Circle{{CenterX: 100, CenterY: 100, Radius: 10, ID: 1}, {CenterX: 105, CenterY: 100, Radius: 10, ID: 2}, {CenterX: 300, CenterY: 50, Radius: 20, ID: 3}}
+	found := make(map[int]bool)
+	click := ErrorImageClick{X: 103, Y: 101, Scale: 1}
+	for _, want := range []int{1, 2} {
+		circleId, hit := hitCircle(circles, 1, click, found)
+		if !hit || circleId != want {
+			t.Fatalf("found %v: got circle %d (hit %v), want %d", found, circleId, hit, want)
+		}
+		found[circleId] = true
+	}
+	if circleId, hit := hitCircle(circles, 1, click, found); hit {
+		t.Errorf("circle %d was found again", circleId)
+	}
+}
+
+func TestHitCircleEdge(t *testing.T) {
+	circles := []Circle{{CenterX: 300, CenterY: 50, Radius: 20, ID: 3}}
+	if _, hit := hitCircle(circles, 1, ErrorImageClick{X: 300, Y: 70}, nil); !hit {
+		t.Error("a click on the edge misses")
+	}
+	if _, hit := hitCircle(circles, 1, ErrorImageClick{X: 300, Y: 70.01}, nil); hit {
+		t.Error("a click just outside hits")
+	}
+}
+
+//TestHitCircleScales checks that the same spot of the original image hits, however the image was scaled when the
+//circles were drawn and however it is displayed when clicked
+func TestHitCircleScales(t *testing.T) {
+	original := Circle{CenterX: 300, CenterY: 50, Radius: 20, ID: 3}
+	for _, imageScale := range []float64{0, 0.5, 1, 2} {
+		stored := original
+		if imageScale > 0 {
+			stored = Circle{CenterX: int(300 * imageScale), CenterY: int(50 * imageScale), Radius: 20 * imageScale, ID: 3}
+		}
+		for _, clickScale := range []float64{0, 0.25, 1, 3} {
+			shown := clickScale
+			if shown == 0 {
+				shown = 1
+			}
+			inside := ErrorImageClick{X: 300 * shown, Y: 69 * shown, Scale: clickScale}
+			outside := ErrorImageClick{X: 300 * shown, Y: 71 * shown, Scale: clickScale}
+			if _, hit := hitCircle([]Circle{stored}, imageScale, inside, nil); !hit {
+				t.Errorf("image scale %v, click scale %v: the inside misses", imageScale, clickScale)
+			}
+			if _, hit := hitCircle([]Circle{stored}, imageScale, outside, nil); hit {
+				t.Errorf("image scale %v, click scale %v: the outside hits", imageScale, clickScale)
+			}
+		}
+	}
+}
@@ -13,6 +13,7 @@ import (
 	"image/jpeg"
 	"image/png"
 	"io"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
@@ -21,7 +22,6 @@ import (
 	"strings"
 	"time"
 
-	"github.com/dgrijalva/jwt-go"
 	"github.com/gorilla/mux"
 	"github.com/nfnt/resize"
 )
@@ -58,11 +58,11 @@ var UnitById = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 				unauthorized(w, r)
 				return
 			}
-			if user.ID != unit.UserId && !user.isInGroup("admin") && !user.isInGroup("editor") {
-				unauthorized(w, r)
+			if !requireUnitAccess(w, r, user, unit.ID, unitView) {
 				return
 			}
 		}
+		w.Header().Set("ETag", etag(unit.Version))
 		w.WriteHeader(http.StatusOK)
 		if err := json.NewEncoder(w).Encode(map[string]interface{}{"unit": unit}); err != nil {
 			panic(err)
@@ -82,6 +82,10 @@ var UserById = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			notFound(w, r)
 			return
 		}
+		if user.Achievements, err = GetUserAchievements(userId); err != nil {
+			internalError(w, r, err)
+			return
+		}
 		if err := json.NewEncoder(w).Encode(map[string]interface{}{"user": user}); err != nil {
 			panic(err)
 		}
@@ -96,6 +100,9 @@ var Images = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			internalError(w, r, err)
 			return
 		}
+		for i := range images {
+			images[i] = hideImageAge(r, images[i])
+		}
 		if err := json.NewEncoder(w).Encode(map[string]interface{}{"images": images}); err != nil {
 			panic(err)
 		}
@@ -105,8 +112,14 @@ var Images = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 var Units = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	publishedFilter := r.URL.Query().Get("filter[published]")
-	if len(publishedFilter) == 0 || publishedFilter == "true" {
-		units, err := GetPublishedUnits()
+	statusFilter := r.URL.Query().Get("filter[status]")
+	if r.URL.Query().Get("filter[template]") == "true" {
+		user, err := getUserFromRequest(r)
+		if err != nil || !(user.isInGroup("editor") || user.isInGroup("admin")) {
+			unauthorized(w, r)
+			return
+		}
+		units, err := GetTemplateUnits()
 		if err != nil {
 			internalError(w, r, err)
 			return
@@ -114,17 +127,46 @@ var Units = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if err := json.NewEncoder(w).Encode(map[string]interface{}{"units": units}); err != nil {
 			panic(err)
 		}
-	} else {
+	} else if len(statusFilter) != 0 {
 		user, err := getUserFromRequest(r)
 		if err != nil {
 			unauthorized(w, r)
 			return
 		}
-		if !user.isInGroup("admin") && !user.isInGroup("editor") {
+		var units []Unit
+		if user.isInGroup("admin") {
+			units, err = GetUnitsByStatus(statusFilter)
+		} else {
+			units, err = GetMemberUnitsByStatus(user.ID, statusFilter)
+		}
+		if err != nil {
+			internalError(w, r, err)
+			return
+		}
+		if err := json.NewEncoder(w).Encode(map[string]interface{}{"units": units}); err != nil {
+			panic(err)
+		}
+	} else if len(publishedFilter) == 0 || publishedFilter == "true" {
+		units, err := GetPublishedUnits()
+		if err != nil {
+			internalError(w, r, err)
+			return
+		}
+		if err := json.NewEncoder(w).Encode(map[string]interface{}{"units": units}); err != nil {
+			panic(err)
+		}
+	} else {
+		user, err := getUserFromRequest(r)
+		if err != nil {
 			unauthorized(w, r)
 			return
 		}
-		units, err := GetUnPublishedUnits()
+		var units []Unit
+		if user.isInGroup("admin") {
+			units, err = GetUnPublishedUnits()
+		} else {
+			units, err = GetUnPublishedMemberUnits(user.ID)
+		}
 		if err != nil {
 			internalError(w, r, err)
 			return
@@ -163,6 +205,18 @@ var UpdateUnit = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		notParsable(w, r, err)
 		return
 	}
+	//the template flag is only changed if it is sent, and only by those who manage the unit
+	var fields map[string]*json.RawMessage
+	if err := json.Unmarshal(*objmap["unit"], &fields); err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	var template *bool
+	action := unitEdit
+	if _, ok := fields["template"]; ok {
+		template = &unit.IsTemplate
+		action = unitManage
+	}
 	vars := mux.Vars(r)
 	unitId, err := strconv.Atoi(vars["unitId"])
 	if err != nil {
@@ -173,21 +227,30 @@ var UpdateUnit = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 	if user, err := getUserFromRequest(r); err != nil {
 		notParsable(w, r, err)
 		return
-	} else if user.isInGroup("admin") {
-		err := UpdateUnitAdmin(unit)
-		if err != nil {
-			internalError(w, r, err)
+	} else if allowed, err := mayAccessUnit(user, unit.ID, action); err != nil {
+		internalError(w, r, err)
+		return
+	} else if allowed {
+		var ok bool
+		if unit.Version, ok = ifMatchVersion(w, r); !ok {
 			return
 		}
-		if _, err := w.Write([]byte("{}")); err != nil {
-			panic(err)
-		}
-	} else if user.ID == unit.UserId {
-		err := UpdateUnitUser(unit)
-		if err != nil {
+		version, err := UpdateUnitUser(unit, template, newRevision(unit.ID, user, "update unit"))
+		if err == errVersionConflict {
+			current, err := GetUnit(unit.ID)
+			if err == sql.ErrNoRows {
+				notFoundError(w, r)
+			} else if err != nil {
+				internalError(w, r, err)
+			} else {
+				preconditionFailed(w, r, "unit", current, current.Version)
+			}
+			return
+		} else if err != nil {
 			internalError(w, r, err)
 			return
 		}
+		w.Header().Set("ETag", etag(version))
 		if _, err := w.Write([]byte("{}")); err != nil {
 			panic(err)
 		}
@@ -221,16 +284,22 @@ var PageById = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 		if !page.published {
-			if err != nil {
-				log.Println(err)
-				unauthorized(w, r)
+			if !requireUnitAccess(w, r, user, page.UnitID, unitView) {
 				return
 			}
-			if user.ID != page.userId && !user.isInGroup("admin") && !user.isInGroup("editor") {
-				unauthorized(w, r)
+		}
+		if len(page.Questions) > 0 {
+			mayEdit, err := mayAccessUnit(user, page.UnitID, unitEdit)
+			if err != nil {
+				internalError(w, r, err)
 				return
 			}
+			if !mayEdit {
+				shuffleOptions(page.Questions, user.ID)
+				hideSolutions(page.Questions)
+			}
 		}
+		w.Header().Set("ETag", etag(page.Version))
 		w.WriteHeader(http.StatusOK)
 		if err := json.NewEncoder(w).Encode(map[string]interface{}{"page": page}); err != nil {
 			panic(err)
@@ -255,8 +324,7 @@ var RotateImageById = http.HandlerFunc(func(w http.ResponseWriter, r *http.Reque
 					unauthorized(w, r)
 					return
 				}
-				if user.ID != image.UserId && !user.isInGroup("admin") {
-					unauthorized(w, r)
+				if !requireUnitAccess(w, r, user, image.UnitId, unitView) {
 					return
 				}
 			}
@@ -286,8 +354,18 @@ var PageCreate = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		notParsable(w, r, err)
 		return
 	}
-	log.Println(page)
-	if insertedPage, err := InsertPage(page); err != nil {
+	user, err := getUserFromRequest(r)
+	if err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	if !requireUnitAccess(w, r, user, page.UnitID, unitEdit) {
+		return
+	}
+	if !validPage(w, r, &page) {
+		return
+	}
+	if insertedPage, err := InsertPage(page, newRevision(page.UnitID, user, "create page")); err != nil {
 		internalError(w, r, err)
 	} else {
 		w.WriteHeader(http.StatusCreated)
@@ -297,6 +375,36 @@ var PageCreate = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 	}
 })
 
+//validPage checks the page against its page type, pages without a type get the default type. On failure the
+//response is already written.
+func validPage(w http.ResponseWriter, r *http.Request, page *Page) bool {
+	if page.PageType == "" {
+		page.PageType = pageTypeDefault
+	}
+	imageIds, err := GetUnitImageIds(page.UnitID)
+	if err != nil {
+		internalError(w, r, err)
+		return false
+	}
+	images := make(map[int]bool)
+	for _, imageId := range imageIds {
+		images[imageId] = true
+	}
+	if errs := validatePage(*page, images); len(errs) > 0 {
+		invalidFields(w, r, errs)
+		return false
+	}
+	return true
+}
+
+var PageTypes = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"pageTypes": pageTypes}); err != nil {
+		panic(err)
+	}
+})
+
 var DeletePage = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 	vars := mux.Vars(r)
@@ -305,8 +413,11 @@ var DeletePage = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		notParsable(w, r, err)
 		return
 	}
-	dbUserId, err := GetPageOwner(pageId)
-	if err != nil {
+	unitId, err := GetPageUnitId(pageId)
+	if err == sql.ErrNoRows {
+		notFoundError(w, r)
+		return
+	} else if err != nil {
 		internalError(w, r, err)
 		return
 	}
@@ -315,11 +426,10 @@ var DeletePage = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		notParsable(w, r, err)
 		return
 	} else {
-		if dbUserId != user.ID && !user.isInGroup("admin") {
-			unauthorized(w, r)
+		if !requireUnitAccess(w, r, user, unitId, unitEdit) {
 			return
 		}
-		err := DbDeletePage(pageId)
+		err := DbDeletePage(pageId, newRevision(unitId, user, "delete page"))
 		if err != nil {
 			internalError(w, r, err)
 			return
@@ -336,8 +446,11 @@ var UpdatePage = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		notParsable(w, r, err)
 		return
 	}
-	dbUserId, err := GetPageOwner(pageId)
-	if err != nil {
+	unitId, err := GetPageUnitId(pageId)
+	if err == sql.ErrNoRows {
+		notFoundError(w, r)
+		return
+	} else if err != nil {
 		internalError(w, r, err)
 		return
 	}
@@ -346,8 +459,7 @@ var UpdatePage = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		notParsable(w, r, err)
 		return
 	} else {
-		if dbUserId != user.ID && !user.isInGroup("admin") {
-			unauthorized(w, r)
+		if !requireUnitAccess(w, r, user, unitId, unitEdit) {
 			return
 		}
 		body, err := readBody(r)
@@ -367,10 +479,35 @@ var UpdatePage = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		page.ID = pageId
-		page, err = DbUpdatePage(page)
-		if err != nil {
+		page.UnitID = unitId
+		if !validPage(w, r, &page) {
+			return
+		}
+		//rows without an id are new, every other row may only be sent once
+		rowIds := make(map[int]bool)
+		for i, row := range page.Rows {
+			if row.ID > 0 && rowIds[row.ID] {
+				invalidFields(w, r, []FieldError{{fmt.Sprintf("rows.%d.id", i), "row is listed twice"}})
+				return
+			}
+			rowIds[row.ID] = true
+		}
+		var ok bool
+		if page.Version, ok = ifMatchVersion(w, r); !ok {
+			return
+		}
+		page, err = DbUpdatePage(page, newRevision(unitId, user, "update page"))
+		if err == errVersionConflict {
+			current, err := GetPageById(pageId)
+			if err != nil {
+				internalError(w, r, err)
+				return
+			}
+			preconditionFailed(w, r, "page", current, current.Version)
+		} else if err != nil {
 			internalError(w, r, err)
 		} else {
+			w.Header().Set("ETag", etag(page.Version))
 			w.WriteHeader(http.StatusOK)
 			if err := json.NewEncoder(w).Encode(map[string]interface{}{"page": page}); err != nil {
 				panic(err)
@@ -386,21 +523,6 @@ var LoginOptionsHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.R
 	//w.Header().Set("Acces-Control-Allow-Origin", "*")
 })
 
-func loginWithToken(w http.ResponseWriter, r *http.Request, token string) {
-	parser := jwt.Parser{[]string{jwt.SigningMethodHS256.Name}, false, false}
-	keyFunc := func(token *jwt.Token) (interface{}, error) {
-		return mySigningKey, nil
-	}
-	_, err := parser.Parse(token, keyFunc)
-	if err != nil {
-		w.WriteHeader(http.StatusUnauthorized)
-		return
-	}
-	if err := json.NewEncoder(w).Encode(map[string]interface{}{"token": token}); err != nil {
-		internalError(w, r, err)
-	}
-}
-
 var LoginHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 	loginFailed := func() {
 		w.WriteHeader(http.StatusUnauthorized)
@@ -423,7 +545,7 @@ var LoginHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request)
 	} else {
 		log.Println("unmarshal success")
 		if len(login.Username) == 0 {
-			loginWithToken(w, r, login.Password)
+			loginFailed()
 			return
 		}
 		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
@@ -447,19 +569,13 @@ var LoginHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request)
 			return
 		}
 		if bytes.Equal(pwHashBytes, hash) && user.Active {
-			token := jwt.New(jwt.SigningMethodHS256)
-			claims := make(jwt.MapClaims)
-
-			claims["groups"] = user.Groups
-			claims["name"] = user.Username
-			claims["exp"] = time.Now().Add(time.Hour * 12).Unix()
-			claims["uid"] = user.ID
-
-			token.Claims = claims
-
-			tokenString, _ := token.SignedString(mySigningKey)
+			accessToken, refreshToken, err := createSession(user)
+			if err != nil {
+				internalError(w, r, err)
+				return
+			}
 
-			if err := json.NewEncoder(w).Encode(map[string]interface{}{"token": tokenString}); err != nil {
+			if err := json.NewEncoder(w).Encode(map[string]interface{}{"token": accessToken, "refreshToken": refreshToken}); err != nil {
 				internalError(w, r, err)
 			}
 		} else {
@@ -472,6 +588,46 @@ var LoginHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request)
 	}
 })
 
+var RefreshTokenHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	var refresh RefreshStruct
+	body, err := readBody(r)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	if err := json.Unmarshal(body, &refresh); err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	accessToken, refreshToken, ok, err := refreshSession(refresh.RefreshToken)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	if !ok {
+		unauthorized(w, r)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"token": accessToken, "refreshToken": refreshToken}); err != nil {
+		panic(err)
+	}
+})
+
+var LogoutHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	sessionId, err := getSessionIdFromRequest(r)
+	if err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	if err := RevokeSession(sessionId); err != nil {
+		internalError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+})
+
 var RegisterHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 	var login LoginStruct
 	body, err := readBody(r)
@@ -507,22 +663,16 @@ var RegisterHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Reque
 	b64hash := base64.StdEncoding.EncodeToString(pwhash)
 	mailHash, err := HashPWWithSaltB64(login.Email, salt)
 	b64MailHash := base64.StdEncoding.EncodeToString(mailHash)
-	user := User{login.Username, []string{"student"}, nil, 0, salt, b64hash, false, b64MailHash, 0, 0, "", nil, nil, nil}
+	user := User{login.Username, []string{"student"}, nil, 0, salt, b64hash, false, b64MailHash, 0, 0, "", nil, nil, nil, nil}
 	if userId, err := InsertUser(user); err != nil {
 		internalError(w, r, err)
 		return
 	} else {
-		token := jwt.New(jwt.SigningMethodHS256)
-		claims := make(jwt.MapClaims)
-
-		claims["groups"] = make([]string, 0)
-		claims["name"] = user.Username
-		claims["exp"] = time.Now().Add(time.Hour * 12).Unix()
-		claims["uid"] = userId
-
-		token.Claims = claims
-
-		tokenString, _ := token.SignedString(mySigningKey)
+		tokenString, err := issuePurposeToken(userId, purposeConfirmMail, time.Hour*48)
+		if err != nil {
+			internalError(w, r, err)
+			return
+		}
 		if err := sendMail(login.Email, conf.AppUrl+"confirm-mail/"+tokenString, "Registrierung Objekte im Kreuzverhör", registerMailTemplate); err != nil {
 			log.Printf("Error sending mail\n")
 			internalError(w, r, err)
@@ -535,6 +685,80 @@ var RegisterHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Reque
 	}
 })
 
+var ConfirmMail = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	userId, ok, err := consumePurposeToken(mux.Vars(r)["token"], purposeConfirmMail)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	if !ok {
+		unauthorized(w, r)
+		return
+	}
+	if err := ActivateUser(userId); err != nil {
+		internalError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write([]byte("{}")); err != nil {
+		panic(err)
+	}
+})
+
+var ResetPassword = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	body, err := readBody(r)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	var objmap map[string]*json.RawMessage
+	if err := json.Unmarshal(body, &objmap); err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	var reset PasswordReset
+	if err := json.Unmarshal(*objmap["passwordReset"], &reset); err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	if len(reset.Password) == 0 {
+		notParsable(w, r, errors.New("empty password"))
+		return
+	}
+	userId, ok, err := consumePurposeToken(mux.Vars(r)["token"], purposePasswordReset)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	if !ok {
+		unauthorized(w, r)
+		return
+	}
+	salt, err := GetUserSalt(userId)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	pwhash, err := HashPWWithSaltB64(reset.Password, salt)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	user := User{ID: userId, pwHash: base64.StdEncoding.EncodeToString(pwhash)}
+	if err := UpdateUserPW(user); err != nil {
+		internalError(w, r, err)
+		return
+	}
+	//everybody who is logged in with the old password has to log in again
+	if err := RevokeUserSessions(userId); err != nil {
+		internalError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+})
+
 var UpdateUser = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	userId, err := strconv.Atoi(vars["userId"])
@@ -585,7 +809,16 @@ var UpdateUser = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			}
 			return
 		}
-		UserUpdateUser(user)
+		if err := UserUpdateUser(user); err != nil {
+			internalError(w, r, err)
+			return
+		}
+		evaluateAchievements(user.ID)
+		//points are awarded by the server, the client only gets to see them
+		if dbUser, err := GetUserById(user.ID); err == nil {
+			user.Points = dbUser.Points
+			user.Rank = dbUser.Rank
+		}
 		w.WriteHeader(http.StatusOK)
 		if err := json.NewEncoder(w).Encode(map[string]interface{}{"user": user}); err != nil {
 			internalError(w, r, err)
@@ -597,7 +830,23 @@ var UpdateUser = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		if stringInSlice("admin", groups) {
-			AdminUpdateUser(user)
+			unknownGroups, err := GetUnknownGroups(user.Groups)
+			if err != nil {
+				internalError(w, r, err)
+				return
+			}
+			if len(unknownGroups) != 0 {
+				w.WriteHeader(422)
+				jsonError := jsonErr{422, "Unknown groups: " + strings.Join(unknownGroups, ", ")}
+				if err := json.NewEncoder(w).Encode(jsonError); err != nil {
+					panic(err)
+				}
+				return
+			}
+			if err := AdminUpdateUser(user); err != nil {
+				internalError(w, r, err)
+				return
+			}
 			w.WriteHeader(http.StatusOK)
 			if err := json.NewEncoder(w).Encode(map[string]interface{}{"user": user}); err != nil {
 				internalError(w, r, err)
@@ -625,7 +874,13 @@ var UnitCreate = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		notParsable(w, r, err)
 		return
 	} else {
-		id, err := InsertUnit(unit)
+		user, err := getUserFromRequest(r)
+		if err != nil {
+			notParsable(w, r, err)
+			return
+		}
+		unit.UserId = user.ID
+		id, err := InsertUnit(unit, newRevision(0, user, "create unit"))
 		if err != nil {
 			internalError(w, r, err)
 			return
@@ -654,7 +909,14 @@ var CreateImage = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request)
 		notParsable(w, r, err)
 		return
 	} else {
-		log.Println(image)
+		user, err := getUserFromRequest(r)
+		if err != nil {
+			notParsable(w, r, err)
+			return
+		}
+		if !requireUnitAccess(w, r, user, image.UnitId, unitEdit) {
+			return
+		}
 		imageId, err := InsertImage(image)
 		if err != nil {
 			internalError(w, r, err)
@@ -668,6 +930,15 @@ var CreateImage = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request)
 	}
 })
 
+//validErrorCircles answers with 422 if the error image has more circles than allowed
+func validErrorCircles(w http.ResponseWriter, r *http.Request, errorImage ErrorImage) bool {
+	if len(errorImage.ErrorCircles) > maxErrorCircles {
+		invalidFields(w, r, []FieldError{{"errorCircles", fmt.Sprintf("at most %d circles are allowed", maxErrorCircles)}})
+		return false
+	}
+	return true
+}
+
 var CreateErrorImage = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 	user, err := getUserFromRequest(r)
 	if err != nil {
@@ -690,7 +961,12 @@ var CreateErrorImage = http.HandlerFunc(func(w http.ResponseWriter, r *http.Requ
 		return
 	} else {
 		log.Println(errorImage)
+		if !validErrorCircles(w, r, errorImage) {
+			return
+		}
 		errorImage.UserId = user.ID
+		errorImage.Published = false
+		errorImage.ModerationStatus = moderationPending
 		errorImage, err := InsertErrorImage(errorImage)
 		if err != nil {
 			internalError(w, r, err)
@@ -746,12 +1022,23 @@ var UploadOrUpdateErrorImage = http.HandlerFunc(func(w http.ResponseWriter, r *h
 			notParsable(w, r, err)
 			return
 		}
+		if !validErrorCircles(w, r, updateErrorImage) {
+			return
+		}
 		updateErrorImage.ID = errorImageId
 		updateErrorImage, err = UpdateErrorImage(updateErrorImage)
 		if err != nil {
 			internalError(w, r, err)
 			return
 		}
+		errorImage, ok := resubmitErrorImage(w, r, user, errorImage)
+		if !ok {
+			return
+		}
+		updateErrorImage.UserId = errorImage.UserId
+		updateErrorImage.Published = errorImage.Published
+		updateErrorImage.ModerationStatus = errorImage.ModerationStatus
+		updateErrorImage.ModerationReason = errorImage.ModerationReason
 		w.WriteHeader(http.StatusOK)
 		if err := json.NewEncoder(w).Encode(map[string]interface{}{"errorImage": updateErrorImage}); err != nil {
 			panic(err)
@@ -823,6 +1110,9 @@ var UploadOrUpdateErrorImage = http.HandlerFunc(func(w http.ResponseWriter, r *h
 		internalError(w, r, err)
 		return
 	}
+	if _, ok := resubmitErrorImage(w, r, user, errorImage); !ok {
+		return
+	}
 	w.WriteHeader(http.StatusCreated)
 
 })
@@ -845,9 +1135,7 @@ var UploadOrUpdateImage = http.HandlerFunc(func(w http.ResponseWriter, r *http.R
 		internalError(w, r, err)
 		return
 	}
-	if image.UserId != user.ID {
-		log.Println("userid != image.userid", user.ID, "!=", image.UserId)
-		unauthorized(w, r)
+	if !requireUnitAccess(w, r, user, image.UnitId, unitEdit) {
 		return
 	}
 	r.ParseMultipartForm(32 << 20)
@@ -1004,9 +1292,7 @@ var UploadRotateImage = http.HandlerFunc(func(w http.ResponseWriter, r *http.Req
 		internalError(w, r, err)
 		return
 	}
-	if image.UserId != user.ID {
-		log.Println("userid != image.userid", user.ID, "!=", image.UserId)
-		unauthorized(w, r)
+	if !requireUnitAccess(w, r, user, image.UnitId, unitEdit) {
 		return
 	}
 	r.ParseMultipartForm(32 << 20)
@@ -1098,10 +1384,9 @@ var RotateImageByIdAndNumber = http.HandlerFunc(func(w http.ResponseWriter, r *h
 		if err != nil {
 			log.Println("could not get user from request")
 			unauthorized(w, r)
+			return
 		}
-		if user.ID != image.UserId && !stringInSlice("admin", user.Groups) {
-			log.Println("rotateImage.userId != userid and user is not admin")
-			unauthorized(w, r)
+		if !requireUnitAccess(w, r, user, image.UnitId, unitView) {
 			return
 		}
 	}
@@ -1116,10 +1401,18 @@ var ErrorImageJSONById = http.HandlerFunc(func(w http.ResponseWriter, r *http.Re
 		return
 	}
 	image, err := GetErrorImageById(imageId)
-	if err != nil {
+	if err == sql.ErrNoRows {
+		notFoundError(w, r)
+		return
+	} else if err != nil {
 		internalError(w, r, err)
 		return
 	}
+	if !image.Published && !canSeeErrorCircles(r, image) {
+		notFoundError(w, r)
+		return
+	}
+	image = hideErrorCircles(r, image)
 	/*
 		if !image.published {
 			user, err := getUserFromRequest(r)
@@ -1159,11 +1452,11 @@ var ImageJSONById = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request
 			unauthorized(w, r)
 			return
 		}
-		if user.ID != image.UserId && !user.isInGroup("admin") && !user.isInGroup("editor") {
-			unauthorized(w, r)
+		if !requireUnitAccess(w, r, user, image.UnitId, unitView) {
 			return
 		}
 	}
+	image = hideImageAge(r, image)
 	w.WriteHeader(http.StatusOK)
 	if err := json.NewEncoder(w).Encode(map[string]interface{}{"image": image}); err != nil {
 		panic(err)
@@ -1194,8 +1487,7 @@ var ImageById = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			unauthorized(w, r)
 			return
 		}
-		if user.ID != image.UserId && !user.isInGroup("admin") && !user.isInGroup("editor") {
-			unauthorized(w, r)
+		if !requireUnitAccess(w, r, user, image.UnitId, unitView) {
 			return
 		}
 	}
@@ -1216,10 +1508,17 @@ var ErrorImageById = http.HandlerFunc(func(w http.ResponseWriter, r *http.Reques
 		return
 	}
 	image, err := GetErrorImageById(imageId)
-	if err != nil {
+	if err == sql.ErrNoRows {
+		notFoundError(w, r)
+		return
+	} else if err != nil {
 		internalError(w, r, err)
 		return
 	}
+	if !image.Published && !canSeeErrorCircles(r, image) {
+		notFoundError(w, r)
+		return
+	}
 	/*
 		if !image.published {
 			user, err := getUserFromRequest(r)
@@ -1272,17 +1571,33 @@ var DeleteRow = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		notParsable(w, r, err)
 		return
 	}
-	rowOwnerId, err := GetRowOwnerId(rowId)
-	if err != nil {
+	unitId, err := GetRowUnitId(rowId)
+	if err == sql.ErrNoRows {
+		notFoundError(w, r)
+		return
+	} else if err != nil {
 		internalError(w, r, err)
 		return
 	}
-	if rowOwnerId != user.ID && !user.isInGroup("admin") {
-		unauthorized(w, r)
+	if !requireUnitAccess(w, r, user, unitId, unitEdit) {
 		return
 	}
-	err = RowDelete(rowId)
-	if err != nil {
+	version, ok := ifMatchVersion(w, r)
+	if !ok {
+		return
+	}
+	err = RowDelete(rowId, version, newRevision(unitId, user, "delete row"))
+	if err == errVersionConflict {
+		current, err := GetRowById(rowId)
+		if err == sql.ErrNoRows {
+			notFoundError(w, r)
+		} else if err != nil {
+			internalError(w, r, err)
+		} else {
+			preconditionFailed(w, r, "row", current, current.Version)
+		}
+		return
+	} else if err != nil {
 		internalError(w, r, err)
 		return
 	}
@@ -1327,12 +1642,18 @@ var InsertPageResult = http.HandlerFunc(func(w http.ResponseWriter, r *http.Requ
 		notParsable(w, r, err)
 		return
 	}
+	orders, ok := gradePageResult(w, r, user, &pageResult)
+	if !ok {
+		return
+	}
 	id, err := DbInsertPageResult(user, pageResult)
 	if err != nil {
 		internalError(w, r, err)
 		return
 	}
 	pageResult.Id = id
+	translateChoices(pageResult.QuestionResults, orders, false)
+	evaluateAchievements(user.ID)
 	w.WriteHeader(http.StatusOK)
 	if err := json.NewEncoder(w).Encode(map[string]interface{}{"pageResult": pageResult}); err != nil {
 		panic(err)
@@ -1366,18 +1687,86 @@ var UpdatePageResult = http.HandlerFunc(func(w http.ResponseWriter, r *http.Requ
 		notParsable(w, r, err)
 		return
 	}
-	pageResult.PageId = pageResultId
+	pageResult.Id = pageResultId
+	if len(pageResult.QuestionResults) > 0 {
+		current, err := DbGetPageResult(pageResultId)
+		if err == sql.ErrNoRows || (err == nil && current.UserId != user.ID) {
+			notFoundError(w, r)
+			return
+		} else if err != nil {
+			internalError(w, r, err)
+			return
+		}
+		pageResult.PageId = current.PageId
+	}
+	orders, ok := gradePageResult(w, r, user, &pageResult)
+	if !ok {
+		return
+	}
 	err = DbUpdatePageResult(user, pageResult)
 	if err != nil {
 		internalError(w, r, err)
 		return
 	}
+	translateChoices(pageResult.QuestionResults, orders, false)
+	evaluateAchievements(user.ID)
 	w.WriteHeader(http.StatusOK)
-	if _, err := w.Write([]byte("{}")); err != nil {
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"pageResult": pageResult}); err != nil {
 		panic(err)
 	}
 })
 
+//userOptionOrders returns the optionOrder of every question of the page for the user, or nil if the user may
+//edit the unit and sees the options as stored
+func userOptionOrders(user User, page Page) (map[int][]int, error) {
+	mayEdit, err := mayAccessUnit(user, page.UnitID, unitEdit)
+	if err != nil || mayEdit {
+		return nil, err
+	}
+	orders := make(map[int][]int)
+	for _, question := range page.Questions {
+		orders[question.Id] = optionOrder(question, user.ID)
+	}
+	return orders, nil
+}
+
+//gradePageResult grades the answers to the quiz questions of the page result, so students never need the
+//solutions. Choices refer to the shuffled options the user saw, they are stored as indices of the options.
+//It returns the option orders to show the choices to the user again. On failure the response is already
+//written.
+func gradePageResult(w http.ResponseWriter, r *http.Request, user User, pageResult *PageResult) (map[int][]int, bool) {
+	if pageResult.QuestionResults == nil {
+		pageResult.QuestionResults = make([]QuestionResult, 0)
+	}
+	if len(pageResult.QuestionResults) == 0 {
+		return nil, true
+	}
+	page, err := GetPageById(pageResult.PageId)
+	if err == sql.ErrNoRows {
+		notFoundError(w, r)
+		return nil, false
+	} else if err != nil {
+		internalError(w, r, err)
+		return nil, false
+	}
+	orders, err := userOptionOrders(user, page)
+	if err != nil {
+		internalError(w, r, err)
+		return nil, false
+	}
+	for i := range pageResult.QuestionResults {
+		if pageResult.QuestionResults[i].Choices == nil {
+			pageResult.QuestionResults[i].Choices = make([]int, 0)
+		}
+	}
+	translateChoices(pageResult.QuestionResults, orders, true)
+	if err := gradeQuestionResults(page, pageResult.QuestionResults); err != nil {
+		notParsable(w, r, err)
+		return nil, false
+	}
+	return orders, true
+}
+
 var GetPageResult = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 	user, err := getUserFromRequest(r)
 	if err != nil {
@@ -1398,6 +1787,20 @@ var GetPageResult = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request
 	}
 	if pageResult.UserId != user.ID {
 		unauthorized(w, r)
+		return
+	}
+	if len(pageResult.QuestionResults) > 0 {
+		page, err := GetPageById(pageResult.PageId)
+		if err != nil {
+			internalError(w, r, err)
+			return
+		}
+		orders, err := userOptionOrders(user, page)
+		if err != nil {
+			internalError(w, r, err)
+			return
+		}
+		translateChoices(pageResult.QuestionResults, orders, false)
 	}
 	w.WriteHeader(http.StatusOK)
 	if err := json.NewEncoder(w).Encode(map[string]interface{}{"pageResult": pageResult}); err != nil {
@@ -1426,17 +1829,42 @@ var InsertUnitResult = http.HandlerFunc(func(w http.ResponseWriter, r *http.Requ
 		notParsable(w, r, err)
 		return
 	}
+	if !requireValidUnitResult(w, r, unitResult) {
+		return
+	}
 	err = DbInsertUnitResult(user, unitResult)
 	if err != nil {
 		internalError(w, r, err)
 		return
 	}
+	if !awardUnitFinished(w, r, user, unitResult.UnitId) {
+		return
+	}
+	evaluateAchievements(user.ID)
 	w.WriteHeader(http.StatusOK)
 	if err := json.NewEncoder(w).Encode(map[string]interface{}{"unitResult": unitResult}); err != nil {
 		panic(err)
 	}
 })
 
+//awardUnitFinished awards the points for finishing the unit once the user decided on every argument and answered
+//every question, saving the unit result alone is not enough. On failure the response is already written.
+func awardUnitFinished(w http.ResponseWriter, r *http.Request, user User, unitId int) bool {
+	missing, err := CountMissingDecisions(user.ID, unitId)
+	if err != nil {
+		internalError(w, r, err)
+		return false
+	}
+	if missing > 0 {
+		return true
+	}
+	if _, err := AwardPoints(user.ID, pointsUnitFinished, unitId); err != nil {
+		internalError(w, r, err)
+		return false
+	}
+	return true
+}
+
 var UpdateUnitResult = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 	user, err := getUserFromRequest(r)
 	if err != nil {
@@ -1465,11 +1893,18 @@ var UpdateUnitResult = http.HandlerFunc(func(w http.ResponseWriter, r *http.Requ
 		return
 	}
 	unitResult.UnitId = unitResultId
+	if !requireValidUnitResult(w, r, unitResult) {
+		return
+	}
 	err = DbUpdateUnitResult(user, unitResult)
 	if err != nil {
 		internalError(w, r, err)
 		return
 	}
+	if !awardUnitFinished(w, r, user, unitResult.UnitId) {
+		return
+	}
+	evaluateAchievements(user.ID)
 	w.WriteHeader(http.StatusOK)
 	if _, err := w.Write([]byte("{}")); err != nil {
 		panic(err)
@@ -1483,17 +1918,41 @@ var GetUnitResult = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request
 		notParsable(w, r, err)
 		return
 	}
+	if !requirePublicOrUnitView(w, r, unitId) {
+		return
+	}
 	unitResults, err := DbGetUnitResults(unitId)
 	if err != nil {
 		internalError(w, r, err)
 		return
 	}
+	response := map[string]interface{}{"unitResults": unitResults}
+	//logged in users also get their own decision
+	if user, err := getUserFromRequest(r); err == nil && user.ID > 0 {
+		unitResult, err := DbGetUserUnitResult(user.ID, unitId)
+		if err == nil {
+			response["unitResult"] = unitResult
+		} else if err != sql.ErrNoRows {
+			internalError(w, r, err)
+			return
+		}
+	}
 	w.WriteHeader(http.StatusOK)
-	if err := json.NewEncoder(w).Encode(map[string]interface{}{"unitResults": unitResults}); err != nil {
+	if err := json.NewEncoder(w).Encode(response); err != nil {
 		panic(err)
 	}
 })
 
+//requireValidUnitResult checks the decision and that the user may see the unit. On failure the response is
+//already written.
+func requireValidUnitResult(w http.ResponseWriter, r *http.Request, unitResult UnitResult) bool {
+	if !stringInSlice(unitResult.Decision, []string{decisionPro, decisionCon, decisionUndecided}) {
+		notParsable(w, r, fmt.Errorf("unknown decision %s", unitResult.Decision))
+		return false
+	}
+	return requirePublicOrUnitView(w, r, unitResult.UnitId)
+}
+
 var NewPasswordRequest = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 	var login LoginStruct
 	body, err := readBody(r)
@@ -1524,17 +1983,11 @@ var NewPasswordRequest = http.HandlerFunc(func(w http.ResponseWriter, r *http.Re
 	mailHash, err := HashPWWithSaltB64(login.Email, user.salt)
 	b64MailHash := base64.StdEncoding.EncodeToString(mailHash)
 	if b64MailHash == user.mailHash {
-		token := jwt.New(jwt.SigningMethodHS256)
-		claims := make(jwt.MapClaims)
-
-		claims["groups"] = make([]string, 0)
-		claims["name"] = user.Username
-		claims["exp"] = time.Now().Add(time.Hour * 12).Unix()
-		claims["uid"] = user.ID
-
-		token.Claims = claims
-
-		tokenString, _ := token.SignedString(mySigningKey)
+		tokenString, err := issuePurposeToken(user.ID, purposePasswordReset, time.Hour*12)
+		if err != nil {
+			internalError(w, r, err)
+			return
+		}
 		if err := sendMail(login.Email, conf.AppUrl+"password-recovery/"+tokenString, "Objekte im Kreuzverhör: Passwort wiederherstellen", pwRecoveryTemplate); err != nil {
 			log.Printf("Error sending mail\n")
 			internalError(w, r, err)
@@ -1555,12 +2008,2743 @@ var NewPasswordRequest = http.HandlerFunc(func(w http.ResponseWriter, r *http.Re
 })
 
 var ErrorImages = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-	errorImages, err := GetErrorImages()
+	var errorImages []ErrorImage
+	var err error
+	statusFilter := r.URL.Query().Get("filter[status]")
+	if r.URL.Query().Get("filter[mine]") == "true" {
+		user, err := getUserFromRequest(r)
+		if err != nil {
+			unauthorized(w, r)
+			return
+		}
+		errorImages, err = GetUserErrorImages(user.ID)
+	} else if len(statusFilter) != 0 && statusFilter != moderationApproved {
+		//the moderation queue is only for editors and admins
+		user, err := getUserFromRequest(r)
+		if err != nil || !(user.isInGroup("editor") || user.isInGroup("admin")) {
+			unauthorized(w, r)
+			return
+		}
+		if !stringInSlice(statusFilter, []string{moderationPending, moderationRejected}) {
+			notParsable(w, r, fmt.Errorf("unknown status %s", statusFilter))
+			return
+		}
+		errorImages, err = GetErrorImages(statusFilter)
+	} else {
+		errorImages, err = GetErrorImages(moderationApproved)
+	}
 	if err != nil {
 		internalError(w, r, err)
 		return
 	}
+	for i := range errorImages {
+		errorImages[i] = hideErrorCircles(r, errorImages[i])
+	}
 	if err := json.NewEncoder(w).Encode(map[string]interface{}{"errorImages": errorImages}); err != nil {
 		panic(err)
 	}
 })
+
+var JSONWebKeys = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"keys": signingKeys.publicKeys()}); err != nil {
+		panic(err)
+	}
+})
+
+var Groups = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	groups, err := GetAllGroups()
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"groups": groups}); err != nil {
+		panic(err)
+	}
+})
+
+var GroupById = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	groupId, err := strconv.Atoi(mux.Vars(r)["groupId"])
+	if err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	group, err := GetGroupById(groupId)
+	if err == sql.ErrNoRows {
+		notFoundError(w, r)
+		return
+	} else if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"group": group}); err != nil {
+		panic(err)
+	}
+})
+
+var CreateGroup = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	body, err := readBody(r)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	var objmap map[string]*json.RawMessage
+	if err := json.Unmarshal(body, &objmap); err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	var group Group
+	if err := json.Unmarshal(*objmap["group"], &group); err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	group.Name = strings.TrimSpace(group.Name)
+	if len(group.Name) == 0 {
+		notParsable(w, r, errors.New("empty group name"))
+		return
+	}
+	if exists, err := IsGroupNameInDb(group.Name); err != nil {
+		internalError(w, r, err)
+		return
+	} else if exists {
+		conflict(w, r, "Group already exists")
+		return
+	}
+	group.ID, err = InsertGroup(group)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"group": group}); err != nil {
+		panic(err)
+	}
+})
+
+var UpdateGroup = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	groupId, err := strconv.Atoi(mux.Vars(r)["groupId"])
+	if err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	body, err := readBody(r)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	var objmap map[string]*json.RawMessage
+	if err := json.Unmarshal(body, &objmap); err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	var group Group
+	if err := json.Unmarshal(*objmap["group"], &group); err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	group.ID = groupId
+	group.Name = strings.TrimSpace(group.Name)
+	if len(group.Name) == 0 {
+		notParsable(w, r, errors.New("empty group name"))
+		return
+	}
+	dbGroup, err := GetGroupById(groupId)
+	if err == sql.ErrNoRows {
+		notFoundError(w, r)
+		return
+	} else if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	if dbGroup.Name == group.Name {
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(map[string]interface{}{"group": group}); err != nil {
+			panic(err)
+		}
+		return
+	}
+	if stringInSlice(dbGroup.Name, builtinGroups) {
+		conflict(w, r, "Built-in groups can not be renamed")
+		return
+	}
+	if exists, err := IsGroupNameInDb(group.Name); err != nil {
+		internalError(w, r, err)
+		return
+	} else if exists {
+		conflict(w, r, "Group already exists")
+		return
+	}
+	if err := DbUpdateGroup(group); err != nil {
+		internalError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"group": group}); err != nil {
+		panic(err)
+	}
+})
+
+var DeleteGroup = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	groupId, err := strconv.Atoi(mux.Vars(r)["groupId"])
+	if err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	group, err := GetGroupById(groupId)
+	if err == sql.ErrNoRows {
+		notFoundError(w, r)
+		return
+	} else if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	if stringInSlice(group.Name, builtinGroups) {
+		conflict(w, r, "Built-in groups can not be deleted")
+		return
+	}
+	if err := DbDeleteGroup(groupId); err != nil {
+		internalError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+})
+
+var UnitMembers = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	unitId, err := strconv.Atoi(mux.Vars(r)["unitId"])
+	if err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	user, err := getUserFromRequest(r)
+	if err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	if !requireUnitAccess(w, r, user, unitId, unitView) {
+		return
+	}
+	members, err := GetUnitMembers(unitId)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"members": members}); err != nil {
+		panic(err)
+	}
+})
+
+//isLastOwner checks whether the member is the only owner of its unit, units must always keep at least one owner
+func isLastOwner(member UnitMember) (bool, error) {
+	role, err := GetUnitMemberRole(member.UnitId, member.UserId)
+	if err == sql.ErrNoRows {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if role != roleOwner {
+		return false, nil
+	}
+	owners, err := CountUnitOwners(member.UnitId)
+	if err != nil {
+		return false, err
+	}
+	return owners <= 1, nil
+}
+
+var AddUnitMember = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	unitId, err := strconv.Atoi(mux.Vars(r)["unitId"])
+	if err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	user, err := getUserFromRequest(r)
+	if err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	if !requireUnitAccess(w, r, user, unitId, unitManage) {
+		return
+	}
+	body, err := readBody(r)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	var objmap map[string]*json.RawMessage
+	if err := json.Unmarshal(body, &objmap); err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	var member UnitMember
+	if err := json.Unmarshal(*objmap["member"], &member); err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	member.UnitId = unitId
+	if !isUnitRole(member.Role) {
+		notParsable(w, r, fmt.Errorf("unknown unit role %q", member.Role))
+		return
+	}
+	invited, err := GetUserById(member.UserId)
+	if err == sql.ErrNoRows {
+		notFoundError(w, r)
+		return
+	} else if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	member.Username = invited.Username
+	if _, err := GetUnitMemberRole(unitId, member.UserId); err == nil {
+		conflict(w, r, "User is already member of this unit")
+		return
+	} else if err != sql.ErrNoRows {
+		internalError(w, r, err)
+		return
+	}
+	if err := InsertUnitMember(member); err != nil {
+		internalError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"member": member}); err != nil {
+		panic(err)
+	}
+})
+
+var UpdateUnitMember = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	vars := mux.Vars(r)
+	unitId, err := strconv.Atoi(vars["unitId"])
+	if err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	memberId, err := strconv.Atoi(vars["userId"])
+	if err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	user, err := getUserFromRequest(r)
+	if err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	if !requireUnitAccess(w, r, user, unitId, unitManage) {
+		return
+	}
+	body, err := readBody(r)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	var objmap map[string]*json.RawMessage
+	if err := json.Unmarshal(body, &objmap); err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	var member UnitMember
+	if err := json.Unmarshal(*objmap["member"], &member); err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	member.UnitId = unitId
+	member.UserId = memberId
+	if !isUnitRole(member.Role) {
+		notParsable(w, r, fmt.Errorf("unknown unit role %q", member.Role))
+		return
+	}
+	if _, err := GetUnitMemberRole(unitId, memberId); err == sql.ErrNoRows {
+		notFoundError(w, r)
+		return
+	} else if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	if member.Role != roleOwner {
+		if lastOwner, err := isLastOwner(member); err != nil {
+			internalError(w, r, err)
+			return
+		} else if lastOwner {
+			conflict(w, r, "A unit needs at least one owner")
+			return
+		}
+	}
+	if err := UpdateUnitMemberRole(member); err != nil {
+		internalError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"member": member}); err != nil {
+		panic(err)
+	}
+})
+
+var RemoveUnitMember = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	unitId, err := strconv.Atoi(vars["unitId"])
+	if err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	memberId, err := strconv.Atoi(vars["userId"])
+	if err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	user, err := getUserFromRequest(r)
+	if err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	//members may always leave a unit, removing others needs the right to manage it
+	if memberId != user.ID && !requireUnitAccess(w, r, user, unitId, unitManage) {
+		return
+	}
+	if lastOwner, err := isLastOwner(UnitMember{UnitId: unitId, UserId: memberId}); err != nil {
+		internalError(w, r, err)
+		return
+	} else if lastOwner {
+		conflict(w, r, "A unit needs at least one owner")
+		return
+	}
+	if err := DeleteUnitMember(unitId, memberId); err != nil {
+		internalError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+})
+
+var UnitStatusEvents = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	unitId, err := strconv.Atoi(mux.Vars(r)["unitId"])
+	if err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	user, err := getUserFromRequest(r)
+	if err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	if !requireUnitAccess(w, r, user, unitId, unitView) {
+		return
+	}
+	events, err := GetUnitStatusEvents(unitId)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"statusEvents": events}); err != nil {
+		panic(err)
+	}
+})
+
+var CreateUnitStatusEvent = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	unitId, err := strconv.Atoi(mux.Vars(r)["unitId"])
+	if err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	user, err := getUserFromRequest(r)
+	if err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	body, err := readBody(r)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	var objmap map[string]*json.RawMessage
+	if err := json.Unmarshal(body, &objmap); err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	var event UnitStatusEvent
+	if err := json.Unmarshal(*objmap["statusEvent"], &event); err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	unit, err := GetUnit(unitId)
+	if err == sql.ErrNoRows {
+		notFoundError(w, r)
+		return
+	} else if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	transition, ok := findUnitTransition(unit.Status, event.To)
+	if !ok {
+		conflict(w, r, fmt.Sprintf("Unit can not change from %s to %s", unit.Status, event.To))
+		return
+	}
+	if !requireUnitAccess(w, r, user, unitId, transition.action) {
+		return
+	}
+	event.Comment = strings.TrimSpace(event.Comment)
+	if event.To == statusChangesRequested && len(event.Comment) == 0 {
+		notParsable(w, r, errors.New("requesting changes needs a comment"))
+		return
+	}
+	event.UnitId = unitId
+	event.UserId = user.ID
+	event.Username = user.Username
+	event.From = unit.Status
+	event, ok, err = ChangeUnitStatus(event)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	if !ok {
+		conflict(w, r, "Unit status was changed concurrently")
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"statusEvent": event}); err != nil {
+		panic(err)
+	}
+})
+
+var UpdateUnitSchedule = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	unitId, err := strconv.Atoi(mux.Vars(r)["unitId"])
+	if err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	user, err := getUserFromRequest(r)
+	if err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	if !requireUnitAccess(w, r, user, unitId, unitReview) {
+		return
+	}
+	body, err := readBody(r)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	var objmap map[string]*json.RawMessage
+	if err := json.Unmarshal(body, &objmap); err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	var schedule UnitSchedule
+	if err := json.Unmarshal(*objmap["schedule"], &schedule); err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	if schedule.PublishAt != nil && schedule.UnpublishAt != nil && !schedule.UnpublishAt.After(*schedule.PublishAt) {
+		notParsable(w, r, errors.New("unpublishAt has to be after publishAt"))
+		return
+	}
+	if _, err := GetUnit(unitId); err == sql.ErrNoRows {
+		notFoundError(w, r)
+		return
+	} else if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	if err := DbUpdateUnitSchedule(unitId, schedule); err != nil {
+		internalError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"schedule": schedule}); err != nil {
+		panic(err)
+	}
+})
+
+//newRevision describes the revision an edit of the unit records. It is stored in the transaction of the edit,
+//units created by the edit set their id there.
+func newRevision(unitId int, user User, action string) UnitRevision {
+	return UnitRevision{UnitId: unitId, UserId: user.ID, Action: action}
+}
+
+//getUnitRevision reads the revision from the route and checks that it belongs to the unit of the route and
+//the user may do action on it. On failure the response is already written.
+func getUnitRevision(w http.ResponseWriter, r *http.Request, action unitAction) (UnitRevision, User, bool) {
+	vars := mux.Vars(r)
+	unitId, err := strconv.Atoi(vars["unitId"])
+	if err != nil {
+		notParsable(w, r, err)
+		return UnitRevision{}, User{}, false
+	}
+	revisionId, err := strconv.Atoi(vars["revisionId"])
+	if err != nil {
+		notParsable(w, r, err)
+		return UnitRevision{}, User{}, false
+	}
+	user, err := getUserFromRequest(r)
+	if err != nil {
+		notParsable(w, r, err)
+		return UnitRevision{}, User{}, false
+	}
+	if !requireUnitAccess(w, r, user, unitId, action) {
+		return UnitRevision{}, User{}, false
+	}
+	revision, err := GetUnitRevision(revisionId)
+	if err == sql.ErrNoRows || (err == nil && revision.UnitId != unitId) {
+		notFoundError(w, r)
+		return UnitRevision{}, User{}, false
+	} else if err != nil {
+		internalError(w, r, err)
+		return UnitRevision{}, User{}, false
+	}
+	return revision, user, true
+}
+
+var UnitRevisions = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	unitId, err := strconv.Atoi(mux.Vars(r)["unitId"])
+	if err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	user, err := getUserFromRequest(r)
+	if err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	if !requireUnitAccess(w, r, user, unitId, unitView) {
+		return
+	}
+	revisions, err := GetUnitRevisions(unitId)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"revisions": revisions}); err != nil {
+		panic(err)
+	}
+})
+
+var UnitRevisionById = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	revision, _, ok := getUnitRevision(w, r, unitView)
+	if !ok {
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"revision": revision}); err != nil {
+		panic(err)
+	}
+})
+
+var DiffUnitRevisions = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	from, _, ok := getUnitRevision(w, r, unitView)
+	if !ok {
+		return
+	}
+	toId, err := strconv.Atoi(r.URL.Query().Get("to"))
+	if err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	to, err := GetUnitRevision(toId)
+	if err == sql.ErrNoRows || (err == nil && to.UnitId != from.UnitId) {
+		notFoundError(w, r)
+		return
+	} else if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	diff := diffUnitSnapshots(*from.Snapshot, *to.Snapshot)
+	diff.From = from.ID
+	diff.To = to.ID
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"diff": diff}); err != nil {
+		panic(err)
+	}
+})
+
+var RevertUnit = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	revision, user, ok := getUnitRevision(w, r, unitEdit)
+	if !ok {
+		return
+	}
+	action := fmt.Sprintf("revert to revision %d", revision.ID)
+	if err := RestoreUnit(revision.UnitId, *revision.Snapshot, newRevision(revision.UnitId, user, action)); err != nil {
+		internalError(w, r, err)
+		return
+	}
+	unit, err := GetUnit(revision.UnitId)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"unit": unit}); err != nil {
+		panic(err)
+	}
+})
+
+var RevertPage = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	pageId, err := strconv.Atoi(mux.Vars(r)["pageId"])
+	if err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	revision, user, ok := getUnitRevision(w, r, unitEdit)
+	if !ok {
+		return
+	}
+	var page PageSnapshot
+	found := false
+	for _, p := range revision.Snapshot.Pages {
+		if p.ID == pageId {
+			page = p
+			found = true
+		}
+	}
+	if !found {
+		notFoundError(w, r)
+		return
+	}
+	action := fmt.Sprintf("revert page %d to revision %d", pageId, revision.ID)
+	if err := RestorePage(revision.UnitId, page, newRevision(revision.UnitId, user, action)); err != nil {
+		internalError(w, r, err)
+		return
+	}
+	restored, err := GetPageById(pageId)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"page": restored}); err != nil {
+		panic(err)
+	}
+})
+
+var CloneUnit = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	unitId, err := strconv.Atoi(mux.Vars(r)["unitId"])
+	if err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	user, err := getUserFromRequest(r)
+	if err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	source, err := GetUnit(unitId)
+	if err == sql.ErrNoRows {
+		notFoundError(w, r)
+		return
+	} else if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	//templates are meant to be reused by every editor
+	if !source.IsTemplate && !requireUnitAccess(w, r, user, unitId, unitView) {
+		return
+	}
+	body, err := readBody(r)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	var options UnitCloneOptions
+	if len(body) > 0 {
+		var objmap map[string]*json.RawMessage
+		if err := json.Unmarshal(body, &objmap); err != nil {
+			notParsable(w, r, err)
+			return
+		}
+		if raw, ok := objmap["clone"]; ok && raw != nil {
+			if err := json.Unmarshal(*raw, &options); err != nil {
+				notParsable(w, r, err)
+				return
+			}
+		}
+	}
+	var files clonedFiles
+	clone, err := DbCloneUnit(unitId, user.ID, options, func(clone unitCopy) (clonedFiles, error) {
+		var copyErr error
+		files, copyErr = copyUnitFiles(clone, user.ID)
+		return files, copyErr
+	}, newRevision(0, user, fmt.Sprintf("clone of unit %d", unitId)))
+	if err != nil {
+		//the transaction is rolled back, only the copied files are left over
+		removeClonedFiles(files)
+		internalError(w, r, err)
+		return
+	}
+	unit, err := GetUnit(clone.UnitId)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	w.Header().Set("ETag", etag(unit.Version))
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"unit": unit}); err != nil {
+		panic(err)
+	}
+})
+
+//copyUnitFiles copies the files of all images of a clone into the image folder of the new owner, the same
+//way uploads are stored. The files copied so far are returned even on failure.
+func copyUnitFiles(clone unitCopy, userId int) (clonedFiles, error) {
+	files := clonedFiles{Images: make(map[int]string), RotateImages: make(map[int]string)}
+	userDir := filepath.Join(conf.ImageStorage, strconv.Itoa(userId))
+	for oldId, newId := range clone.Images {
+		image, err := GetImageById(oldId)
+		if err != nil {
+			return files, err
+		}
+		if len(image.path) == 0 {
+			continue
+		}
+		if err := os.MkdirAll(userDir, 0755); err != nil {
+			return files, err
+		}
+		imagePath := filepath.Join(userDir, strconv.Itoa(newId)+filepath.Ext(image.path))
+		files.created = append(files.created, imagePath)
+		if err := copyFile(image.path, imagePath); err != nil {
+			return files, err
+		}
+		idx := strings.LastIndex(image.path, ".")
+		smallPath := image.path[0:idx] + "_small" + image.path[idx:]
+		if _, err := os.Stat(smallPath); err == nil {
+			newIdx := strings.LastIndex(imagePath, ".")
+			newSmallPath := imagePath[0:newIdx] + "_small" + imagePath[newIdx:]
+			files.created = append(files.created, newSmallPath)
+			if err := copyFile(smallPath, newSmallPath); err != nil {
+				return files, err
+			}
+		}
+		files.Images[newId] = imagePath
+	}
+	for oldId, newId := range clone.RotateImages {
+		image, err := GetRotateImageById(oldId)
+		if err != nil {
+			return files, err
+		}
+		if len(image.basepath) == 0 {
+			continue
+		}
+		imageDir := filepath.Join(userDir, strconv.Itoa(newId))
+		files.created = append(files.created, imageDir)
+		if err := os.MkdirAll(imageDir, 0755); err != nil {
+			return files, err
+		}
+		entries, err := ioutil.ReadDir(image.basepath)
+		if err != nil {
+			return files, err
+		}
+		for _, entry := range entries {
+			if entry.IsDir() {
+				continue
+			}
+			filePath := filepath.Join(imageDir, entry.Name())
+			files.created = append(files.created, filePath)
+			if err := copyFile(filepath.Join(image.basepath, entry.Name()), filePath); err != nil {
+				return files, err
+			}
+		}
+		files.RotateImages[newId] = imageDir
+	}
+	return files, nil
+}
+
+//removeClonedFiles removes the files of a failed clone, files before the folders they are in
+func removeClonedFiles(files clonedFiles) {
+	for i := len(files.created) - 1; i >= 0; i-- {
+		if err := os.Remove(files.created[i]); err != nil && !os.IsNotExist(err) {
+			log.Println("removing", files.created[i], "of a failed clone failed:", err)
+		}
+	}
+}
+
+var CiteById = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	citeId, err := strconv.Atoi(mux.Vars(r)["citeId"])
+	if err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	cite, err := GetCiteById(citeId)
+	if err == sql.ErrNoRows {
+		notFoundError(w, r)
+		return
+	} else if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	if !requirePublicOrUnitView(w, r, cite.UnitID) {
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"cite": cite}); err != nil {
+		panic(err)
+	}
+})
+
+//requirePublicOrUnitView lets everybody see published units and only members the others. On failure the
+//response is already written.
+func requirePublicOrUnitView(w http.ResponseWriter, r *http.Request, unitId int) bool {
+	unit, err := GetUnit(unitId)
+	if err == sql.ErrNoRows {
+		notFoundError(w, r)
+		return false
+	} else if err != nil {
+		internalError(w, r, err)
+		return false
+	}
+	if unit.Published {
+		return true
+	}
+	user, err := getUserFromRequest(r)
+	if err != nil {
+		unauthorized(w, r)
+		return false
+	}
+	return requireUnitAccess(w, r, user, unitId, unitView)
+}
+
+//readCite parses the cite from the body. On failure the response is already written.
+func readCite(w http.ResponseWriter, r *http.Request) (Cite, bool) {
+	body, err := readBody(r)
+	if err != nil {
+		internalError(w, r, err)
+		return Cite{}, false
+	}
+	var objmap map[string]*json.RawMessage
+	if err := json.Unmarshal(body, &objmap); err != nil {
+		notParsable(w, r, err)
+		return Cite{}, false
+	}
+	var cite Cite
+	if err := json.Unmarshal(*objmap["cite"], &cite); err != nil {
+		notParsable(w, r, err)
+		return Cite{}, false
+	}
+	cite.Abbrev = strings.TrimSpace(cite.Abbrev)
+	return cite, true
+}
+
+//unitAbbrevs returns the abbrevs used by the cites of the unit except the one with citeId
+func unitAbbrevs(unitId, citeId int) (map[string]bool, error) {
+	cites, err := GetUnitCites(unitId)
+	if err != nil {
+		return nil, err
+	}
+	taken := make(map[string]bool)
+	for _, cite := range cites {
+		if cite.ID != citeId {
+			taken[cite.Abbrev] = true
+		}
+	}
+	return taken, nil
+}
+
+//completeCite generates a missing abbrev and text from the structured data and checks that the cite is
+//valid and its abbrev is unique in the unit. On failure the response is already written.
+func completeCite(w http.ResponseWriter, r *http.Request, cite *Cite) bool {
+	taken, err := unitAbbrevs(cite.UnitID, cite.ID)
+	if err != nil {
+		internalError(w, r, err)
+		return false
+	}
+	if len(cite.Abbrev) == 0 && cite.hasStructure() {
+		cite.Abbrev = generateAbbrev(*cite, taken)
+	}
+	if len(cite.Text) == 0 {
+		if cite.Text, err = formatCite(*cite, styleAPA); err != nil {
+			internalError(w, r, err)
+			return false
+		}
+	}
+	if err := validateCite(*cite); err != nil {
+		notParsable(w, r, err)
+		return false
+	}
+	if taken[cite.Abbrev] {
+		conflict(w, r, fmt.Sprintf("A cite with abbrev %s already exists in this unit", cite.Abbrev))
+		return false
+	}
+	return true
+}
+
+var CreateCite = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	user, err := getUserFromRequest(r)
+	if err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	cite, ok := readCite(w, r)
+	if !ok {
+		return
+	}
+	if !requireUnitAccess(w, r, user, cite.UnitID, unitEdit) {
+		return
+	}
+	if !completeCite(w, r, &cite) {
+		return
+	}
+	cite.ID, err = InsertCite(cite)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"cite": cite}); err != nil {
+		panic(err)
+	}
+})
+
+var UpdateCite = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	citeId, err := strconv.Atoi(mux.Vars(r)["citeId"])
+	if err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	user, err := getUserFromRequest(r)
+	if err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	current, err := GetCiteById(citeId)
+	if err == sql.ErrNoRows {
+		notFoundError(w, r)
+		return
+	} else if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	if !requireUnitAccess(w, r, user, current.UnitID, unitEdit) {
+		return
+	}
+	cite, ok := readCite(w, r)
+	if !ok {
+		return
+	}
+	//cites can not be moved to another unit
+	cite.ID = citeId
+	cite.UnitID = current.UnitID
+	if !completeCite(w, r, &cite) {
+		return
+	}
+	if err := DbUpdateCite(cite); err != nil {
+		internalError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"cite": cite}); err != nil {
+		panic(err)
+	}
+})
+
+var DeleteCite = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	citeId, err := strconv.Atoi(mux.Vars(r)["citeId"])
+	if err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	user, err := getUserFromRequest(r)
+	if err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	cite, err := GetCiteById(citeId)
+	if err == sql.ErrNoRows {
+		notFoundError(w, r)
+		return
+	} else if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	if !requireUnitAccess(w, r, user, cite.UnitID, unitEdit) {
+		return
+	}
+	if err := DbDeleteCite(cite); err != nil {
+		internalError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+})
+
+var UnitBibliography = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	unitId, err := strconv.Atoi(mux.Vars(r)["unitId"])
+	if err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	style := r.URL.Query().Get("style")
+	if len(style) == 0 {
+		style = styleAPA
+	} else if !stringInSlice(style, citationStyles) {
+		notParsable(w, r, fmt.Errorf("unknown citation style %s", style))
+		return
+	}
+	if !requirePublicOrUnitView(w, r, unitId) {
+		return
+	}
+	cites, err := GetUnitCites(unitId)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	markdown, err := GetUnitMarkdown(unitId)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	bibliography, err := buildBibliography(cites, markdown, style)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"bibliography": bibliography}); err != nil {
+		panic(err)
+	}
+})
+
+var ImportCites = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	unitId, err := strconv.Atoi(mux.Vars(r)["unitId"])
+	if err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	user, err := getUserFromRequest(r)
+	if err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	if !requireUnitAccess(w, r, user, unitId, unitEdit) {
+		return
+	}
+	var data []byte
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		r.ParseMultipartForm(4 * mb)
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			notParsable(w, r, err)
+			return
+		}
+		defer file.Close()
+		data, err = ioutil.ReadAll(io.LimitReader(file, 4*mb))
+		if err != nil {
+			internalError(w, r, err)
+			return
+		}
+	} else if data, err = readBody(r); err != nil {
+		internalError(w, r, err)
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if len(format) == 0 {
+		//Zotero exports start with @ for BibTeX and with [ for CSL-JSON
+		if strings.HasPrefix(strings.TrimSpace(string(data)), "@") {
+			format = formatBibTeX
+		} else {
+			format = formatCSLJSON
+		}
+	}
+	var cites []Cite
+	switch format {
+	case formatBibTeX:
+		cites, err = importBibTeX(string(data))
+	case formatCSLJSON:
+		cites, err = importCSLJSON(data)
+	default:
+		err = fmt.Errorf("unknown format %s", format)
+	}
+	if err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	taken, err := unitAbbrevs(unitId, 0)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	errs := make([]FieldError, 0)
+	for idx := range cites {
+		cites[idx].UnitID = unitId
+		cites[idx].Abbrev = generateAbbrev(cites[idx], taken)
+		taken[cites[idx].Abbrev] = true
+		if len(cites[idx].Text) == 0 {
+			if cites[idx].Text, err = formatCite(cites[idx], styleAPA); err != nil {
+				internalError(w, r, err)
+				return
+			}
+		}
+		if err := validateCite(cites[idx]); err != nil {
+			errs = append(errs, FieldError{fmt.Sprintf("cites.%d", idx), err.Error()})
+		}
+	}
+	if len(errs) > 0 {
+		invalidFields(w, r, errs)
+		return
+	}
+	if cites, err = InsertCites(cites); err != nil {
+		internalError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"cites": cites}); err != nil {
+		panic(err)
+	}
+})
+
+var ExportCites = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	unitId, err := strconv.Atoi(mux.Vars(r)["unitId"])
+	if err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	if !requirePublicOrUnitView(w, r, unitId) {
+		return
+	}
+	cites, err := GetUnitCites(unitId)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	switch r.URL.Query().Get("format") {
+	case formatBibTeX:
+		w.Header().Set("Content-Type", "application/x-bibtex; charset=UTF-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"unit-%d.bib\"", unitId))
+		w.WriteHeader(http.StatusOK)
+		if _, err := w.Write([]byte(exportBibTeX(cites))); err != nil {
+			panic(err)
+		}
+	case formatCSLJSON, "":
+		data, err := exportCSLJSON(cites)
+		if err != nil {
+			internalError(w, r, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/vnd.citationstyles.csl+json; charset=UTF-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"unit-%d.json\"", unitId))
+		w.WriteHeader(http.StatusOK)
+		if _, err := w.Write(data); err != nil {
+			panic(err)
+		}
+	default:
+		notParsable(w, r, fmt.Errorf("unknown format %s", r.URL.Query().Get("format")))
+	}
+})
+
+//analyticsUnit reads the unit of an analytics route, only owners and admins may see its analytics. On
+//failure the response is already written.
+func analyticsUnit(w http.ResponseWriter, r *http.Request) (int, bool) {
+	unitId, err := strconv.Atoi(mux.Vars(r)["unitId"])
+	if err != nil {
+		notParsable(w, r, err)
+		return 0, false
+	}
+	user, err := getUserFromRequest(r)
+	if err != nil {
+		notParsable(w, r, err)
+		return 0, false
+	}
+	return unitId, requireUnitAccess(w, r, user, unitId, unitManage)
+}
+
+//writeAnalytics answers with JSON wrapped in key or, with ?format=csv, with a CSV file
+func writeAnalytics(w http.ResponseWriter, r *http.Request, unitId int, key string, data interface{}, table csvTable) {
+	if r.URL.Query().Get("format") == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=UTF-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"unit-%d-%s.csv\"", unitId, key))
+		w.WriteHeader(http.StatusOK)
+		if err := table.write(w); err != nil {
+			log.Println(err)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{key: data}); err != nil {
+		panic(err)
+	}
+}
+
+var RowAnalytics = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	unitId, ok := analyticsUnit(w, r)
+	if !ok {
+		return
+	}
+	stats, err := GetRowDecisionCounts(unitId)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	writeAnalytics(w, r, unitId, "rows", stats, rowDecisionsTable(stats))
+})
+
+var PageAnalytics = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	unitId, ok := analyticsUnit(w, r)
+	if !ok {
+		return
+	}
+	completion, err := GetPageCompletion(unitId)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	writeAnalytics(w, r, unitId, "pages", completion, pageCompletionTable(completion))
+})
+
+var ShiftAnalytics = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	unitId, ok := analyticsUnit(w, r)
+	if !ok {
+		return
+	}
+	shifts, err := GetDecisionShifts(unitId)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	writeAnalytics(w, r, unitId, "shifts", shifts, decisionShiftTable(shifts))
+})
+
+var TrendAnalytics = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	bucket := r.URL.Query().Get("bucket")
+	if len(bucket) == 0 {
+		bucket = "week"
+	} else if !stringInSlice(bucket, trendBuckets) {
+		notParsable(w, r, fmt.Errorf("unknown bucket %s", bucket))
+		return
+	}
+	unitId, ok := analyticsUnit(w, r)
+	if !ok {
+		return
+	}
+	trends, err := GetDecisionTrends(unitId, bucket)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	writeAnalytics(w, r, unitId, "trends", trends, decisionTrendTable(trends))
+})
+
+var Leaderboard = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	window := r.URL.Query().Get("window")
+	if len(window) == 0 {
+		window = leaderboardAll
+	} else if !stringInSlice(window, []string{leaderboardWeek, leaderboardSemester, leaderboardAll}) {
+		notParsable(w, r, fmt.Errorf("unknown window %s", window))
+		return
+	}
+	page, size := 1, leaderboardPageSize
+	var err error
+	if pageStr := r.URL.Query().Get("page[number]"); len(pageStr) > 0 {
+		if page, err = strconv.Atoi(pageStr); err != nil || page < 1 {
+			notParsable(w, r, fmt.Errorf("invalid page number %s", pageStr))
+			return
+		}
+	}
+	if sizeStr := r.URL.Query().Get("page[size]"); len(sizeStr) > 0 {
+		if size, err = strconv.Atoi(sizeStr); err != nil || size < 1 || size > leaderboardMaxPageSize {
+			notParsable(w, r, fmt.Errorf("invalid page size %s", sizeStr))
+			return
+		}
+	}
+	entries, total, err := GetLeaderboard(leaderboardStart(window, time.Now()), size, (page-1)*size)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	meta := map[string]interface{}{"window": window, "page": page, "size": size, "total": total}
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"leaderboard": entries, "meta": meta}); err != nil {
+		panic(err)
+	}
+})
+
+var Achievements = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	achievements, err := GetAchievements()
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"achievements": achievements}); err != nil {
+		panic(err)
+	}
+})
+
+var AchievementById = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	achievementId, err := strconv.Atoi(mux.Vars(r)["achievementId"])
+	if err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	achievement, err := GetAchievementById(achievementId)
+	if err == sql.ErrNoRows {
+		notFoundError(w, r)
+		return
+	} else if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"achievement": achievement}); err != nil {
+		panic(err)
+	}
+})
+
+//readAchievement parses and validates the achievement of the request body. On failure the response is
+//already written.
+func readAchievement(w http.ResponseWriter, r *http.Request) (Achievement, bool) {
+	body, err := readBody(r)
+	if err != nil {
+		internalError(w, r, err)
+		return Achievement{}, false
+	}
+	var objmap map[string]*json.RawMessage
+	if err := json.Unmarshal(body, &objmap); err != nil {
+		notParsable(w, r, err)
+		return Achievement{}, false
+	}
+	raw, ok := objmap["achievement"]
+	if !ok || raw == nil {
+		notParsable(w, r, errors.New("missing achievement"))
+		return Achievement{}, false
+	}
+	var achievement Achievement
+	if err := json.Unmarshal(*raw, &achievement); err != nil {
+		notParsable(w, r, err)
+		return Achievement{}, false
+	}
+	achievement.Title = strings.TrimSpace(achievement.Title)
+	if err := validateAchievement(achievement); err != nil {
+		notParsable(w, r, err)
+		return Achievement{}, false
+	}
+	return achievement, true
+}
+
+var CreateAchievement = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	achievement, ok := readAchievement(w, r)
+	if !ok {
+		return
+	}
+	var err error
+	achievement.Id, err = InsertAchievement(achievement)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"achievement": achievement}); err != nil {
+		panic(err)
+	}
+})
+
+var UpdateAchievement = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	achievementId, err := strconv.Atoi(mux.Vars(r)["achievementId"])
+	if err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	achievement, ok := readAchievement(w, r)
+	if !ok {
+		return
+	}
+	achievement.Id = achievementId
+	if err := DbUpdateAchievement(achievement); err == sql.ErrNoRows {
+		notFoundError(w, r)
+		return
+	} else if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"achievement": achievement}); err != nil {
+		panic(err)
+	}
+})
+
+var DeleteAchievement = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	achievementId, err := strconv.Atoi(mux.Vars(r)["achievementId"])
+	if err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	if err := DbDeleteAchievement(achievementId); err == sql.ErrNoRows {
+		notFoundError(w, r)
+		return
+	} else if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+})
+
+//canSeeErrorCircles tells whether the requesting user may see the solution of the error image, which only its
+//creator, editors and admins may
+func canSeeErrorCircles(r *http.Request, image ErrorImage) bool {
+	user, err := getUserFromRequest(r)
+	if err != nil {
+		return false
+	}
+	return user.ID == image.UserId || user.isInGroup("editor") || user.isInGroup("admin")
+}
+
+//errorImageEarnsPoints tells whether finding errors in the image is worth points. Only approved and published
+//images count, and never for users who can see where the circles are.
+func errorImageEarnsPoints(r *http.Request, image ErrorImage) bool {
+	return image.Published && image.ModerationStatus == moderationApproved && !canSeeErrorCircles(r, image)
+}
+
+//hideErrorCircles replaces the circles by their number for players, they have to find them with the attempts API
+func hideErrorCircles(r *http.Request, image ErrorImage) ErrorImage {
+	image.CircleCount = len(image.ErrorCircles)
+	if !canSeeErrorCircles(r, image) {
+		image.ErrorCircles = make([]Circle, 0)
+	}
+	return image
+}
+
+//ownErrorImageAttempt reads the attempt of the route, players only get to see their own attempts. On failure
+//the response is already written.
+func ownErrorImageAttempt(w http.ResponseWriter, r *http.Request) (User, ErrorImageAttempt, bool) {
+	attemptId, err := strconv.Atoi(mux.Vars(r)["attemptId"])
+	if err != nil {
+		notParsable(w, r, err)
+		return User{}, ErrorImageAttempt{}, false
+	}
+	user, err := getUserFromRequest(r)
+	if err != nil {
+		notParsable(w, r, err)
+		return User{}, ErrorImageAttempt{}, false
+	}
+	attempt, err := GetErrorImageAttempt(attemptId)
+	if err == sql.ErrNoRows {
+		notFoundError(w, r)
+		return User{}, ErrorImageAttempt{}, false
+	} else if err != nil {
+		internalError(w, r, err)
+		return User{}, ErrorImageAttempt{}, false
+	}
+	if attempt.UserId != user.ID {
+		notFoundError(w, r)
+		return User{}, ErrorImageAttempt{}, false
+	}
+	return user, attempt, true
+}
+
+var CreateErrorImageAttempt = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	errorImageId, err := strconv.Atoi(mux.Vars(r)["errorImageId"])
+	if err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	user, err := getUserFromRequest(r)
+	if err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	errorImage, err := GetErrorImageById(errorImageId)
+	if err == sql.ErrNoRows {
+		notFoundError(w, r)
+		return
+	} else if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	if !errorImage.Published && !canSeeErrorCircles(r, errorImage) {
+		notFoundError(w, r)
+		return
+	}
+	attempt, err := InsertErrorImageAttempt(errorImageId, user.ID)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"errorImageAttempt": attempt}); err != nil {
+		panic(err)
+	}
+})
+
+var ErrorImageAttemptById = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	_, attempt, ok := ownErrorImageAttempt(w, r)
+	if !ok {
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"errorImageAttempt": attempt}); err != nil {
+		panic(err)
+	}
+})
+
+var CreateErrorImageClick = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	user, attempt, ok := ownErrorImageAttempt(w, r)
+	if !ok {
+		return
+	}
+	body, err := readBody(r)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	var objmap map[string]*json.RawMessage
+	if err := json.Unmarshal(body, &objmap); err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	raw, ok := objmap["click"]
+	if !ok || raw == nil {
+		notParsable(w, r, errors.New("missing click"))
+		return
+	}
+	var click ErrorImageClick
+	if err := json.Unmarshal(*raw, &click); err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	image, err := GetErrorImageById(attempt.ErrorImageId)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	attempt, circleId, err := RecordErrorImageClick(attempt.Id, click, errorImageEarnsPoints(r, image))
+	if err == errAttemptCompleted {
+		conflict(w, r, "All errors are already found")
+		return
+	} else if err == errTooManyMisses {
+		conflict(w, r, fmt.Sprintf("The attempt missed %d times, start a new one", maxMissedClicks))
+		return
+	} else if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	click.Hit = circleId > 0
+	if attempt.CompletedAt != nil {
+		evaluateAchievements(user.ID)
+	}
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"click": click, "errorImageAttempt": attempt}); err != nil {
+		panic(err)
+	}
+})
+
+//canSeeImageAge is true for editors and admins, everyone else has to guess the age in the dating quiz
+func canSeeImageAge(r *http.Request) bool {
+	user, err := getUserFromRequest(r)
+	return err == nil && (user.isInGroup("editor") || user.isInGroup("admin"))
+}
+
+//hideImageAge removes the age of images for everyone but editors and admins, students have to guess it in the
+//dating quiz
+func hideImageAge(r *http.Request, image Image) Image {
+	if canSeeImageAge(r) {
+		return image
+	}
+	image.Age = 0
+	image.Imprecision = 0
+	return image
+}
+
+//ownDatingRound reads the round of the route, students only get to see their own rounds. On failure the
+//response is already written.
+func ownDatingRound(w http.ResponseWriter, r *http.Request) (User, DatingRound, bool) {
+	roundId, err := strconv.Atoi(mux.Vars(r)["roundId"])
+	if err != nil {
+		notParsable(w, r, err)
+		return User{}, DatingRound{}, false
+	}
+	user, err := getUserFromRequest(r)
+	if err != nil {
+		notParsable(w, r, err)
+		return User{}, DatingRound{}, false
+	}
+	round, err := GetDatingRound(roundId)
+	if err == sql.ErrNoRows {
+		notFoundError(w, r)
+		return User{}, DatingRound{}, false
+	} else if err != nil {
+		internalError(w, r, err)
+		return User{}, DatingRound{}, false
+	}
+	if round.UserId != user.ID {
+		notFoundError(w, r)
+		return User{}, DatingRound{}, false
+	}
+	return user, round, true
+}
+
+var DatingRounds = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	user, err := getUserFromRequest(r)
+	if err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	rounds, err := GetUserDatingRounds(user.ID)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	//the best round is the completed one with the highest share of the possible score
+	meta := map[string]interface{}{"bestRound": nil, "bestScore": 0.0}
+	best := -1.0
+	for _, round := range rounds {
+		if round.CompletedAt == nil || round.MaxScore == 0 {
+			continue
+		}
+		if share := round.Score / float64(round.MaxScore); share > best {
+			best = share
+			meta["bestRound"] = round.Id
+			meta["bestScore"] = share
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"datingRounds": rounds, "meta": meta}); err != nil {
+		panic(err)
+	}
+})
+
+var CreateDatingRound = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	user, err := getUserFromRequest(r)
+	if err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	body, err := readBody(r)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	options := struct {
+		UnitId   int `json:"unit"`
+		CourseId int `json:"course"`
+		Size     int `json:"size"`
+	}{}
+	//the body is optional, without it a round of datingRoundSize images from all visible units is started
+	if len(strings.TrimSpace(string(body))) > 0 {
+		var objmap map[string]*json.RawMessage
+		if err := json.Unmarshal(body, &objmap); err != nil {
+			notParsable(w, r, err)
+			return
+		}
+		if raw, ok := objmap["datingRound"]; ok && raw != nil {
+			if err := json.Unmarshal(*raw, &options); err != nil {
+				notParsable(w, r, err)
+				return
+			}
+		}
+	}
+	if options.Size == 0 {
+		options.Size = datingRoundSize
+	} else if options.Size < 1 || options.Size > datingRoundMaxSize {
+		notParsable(w, r, fmt.Errorf("size must be between 1 and %d", datingRoundMaxSize))
+		return
+	}
+	if options.UnitId != 0 && !requirePublicOrUnitView(w, r, options.UnitId) {
+		return
+	}
+	if options.CourseId != 0 {
+		if _, _, ok := courseAccess(w, r, options.CourseId, false); !ok {
+			return
+		}
+	}
+	round, err := DbCreateDatingRound(user.ID, options.UnitId, options.CourseId, options.Size)
+	if err == errNoDatingImages {
+		notParsable(w, r, err)
+		return
+	} else if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"datingRound": round}); err != nil {
+		panic(err)
+	}
+})
+
+var DatingRoundById = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	_, round, ok := ownDatingRound(w, r)
+	if !ok {
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"datingRound": round}); err != nil {
+		panic(err)
+	}
+})
+
+var CreateDatingAnswer = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	user, round, ok := ownDatingRound(w, r)
+	if !ok {
+		return
+	}
+	body, err := readBody(r)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	var objmap map[string]*json.RawMessage
+	if err := json.Unmarshal(body, &objmap); err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	raw, ok := objmap["answer"]
+	if !ok || raw == nil {
+		notParsable(w, r, errors.New("missing answer"))
+		return
+	}
+	var request struct {
+		ImageId  int  `json:"image"`
+		Estimate *int `json:"estimate"`
+	}
+	if err := json.Unmarshal(*raw, &request); err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	if request.Estimate == nil {
+		notParsable(w, r, errors.New("missing estimate"))
+		return
+	}
+	answer, err := DateImage(round.Id, request.ImageId, *request.Estimate)
+	if err == sql.ErrNoRows {
+		notFoundError(w, r)
+		return
+	} else if err == errAlreadyDated {
+		conflict(w, r, "Image already dated")
+		return
+	} else if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	//who can see the age earns no points for it
+	if *answer.Score == 1 && !canSeeImageAge(r) {
+		if _, err := AwardPoints(user.ID, pointsImageDated, answer.ImageId); err != nil {
+			internalError(w, r, err)
+			return
+		}
+	}
+	round, err = GetDatingRound(round.Id)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	if round.CompletedAt != nil {
+		evaluateAchievements(user.ID)
+	}
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"answer": answer, "datingRound": round}); err != nil {
+		panic(err)
+	}
+})
+
+//resubmitErrorImage puts an error image changed by a student back into the moderation queue, changes of
+//editors and admins keep the moderation status. On failure the response is already written.
+func resubmitErrorImage(w http.ResponseWriter, r *http.Request, user User, errorImage ErrorImage) (ErrorImage, bool) {
+	if user.isInGroup("editor") || user.isInGroup("admin") || errorImage.ModerationStatus == moderationPending {
+		return errorImage, true
+	}
+	if err := ResubmitErrorImage(errorImage.ID); err != nil {
+		internalError(w, r, err)
+		return errorImage, false
+	}
+	errorImage.Published = false
+	errorImage.ModerationStatus = moderationPending
+	errorImage.ModerationReason = ""
+	return errorImage, true
+}
+
+var ModerateErrorImage = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	errorImageId, err := strconv.Atoi(mux.Vars(r)["errorImageId"])
+	if err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	user, err := getUserFromRequest(r)
+	if err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	body, err := readBody(r)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	var objmap map[string]*json.RawMessage
+	if err := json.Unmarshal(body, &objmap); err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	raw, ok := objmap["moderation"]
+	if !ok || raw == nil {
+		notParsable(w, r, errors.New("missing moderation"))
+		return
+	}
+	var moderation struct {
+		Status string `json:"status"`
+		Reason string `json:"reason"`
+	}
+	if err := json.Unmarshal(*raw, &moderation); err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	moderation.Reason = strings.TrimSpace(moderation.Reason)
+	var message string
+	switch moderation.Status {
+	case moderationApproved:
+		message = "Dein Fehlerbild wurde freigegeben."
+	case moderationRejected:
+		if len(moderation.Reason) == 0 {
+			notParsable(w, r, errors.New("a rejection needs a reason"))
+			return
+		}
+		message = "Dein Fehlerbild wurde abgelehnt: " + moderation.Reason
+	default:
+		notParsable(w, r, fmt.Errorf("unknown status %s", moderation.Status))
+		return
+	}
+	if err := DbModerateErrorImage(errorImageId, user.ID, moderation.Status, moderation.Reason, message); err == sql.ErrNoRows {
+		notFoundError(w, r)
+		return
+	} else if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	errorImage, err := GetErrorImageById(errorImageId)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	//created error images only count for achievements once they are approved
+	if errorImage.ModerationStatus == moderationApproved {
+		evaluateAchievements(errorImage.UserId)
+	}
+	errorImage.CircleCount = len(errorImage.ErrorCircles)
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"errorImage": errorImage}); err != nil {
+		panic(err)
+	}
+})
+
+var Notifications = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	user, err := getUserFromRequest(r)
+	if err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	notifications, err := GetUserNotifications(user.ID, r.URL.Query().Get("filter[unread]") == "true")
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"notifications": notifications}); err != nil {
+		panic(err)
+	}
+})
+
+var UpdateNotification = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	notificationId, err := strconv.Atoi(mux.Vars(r)["notificationId"])
+	if err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	user, err := getUserFromRequest(r)
+	if err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	body, err := readBody(r)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	var objmap map[string]*json.RawMessage
+	if err := json.Unmarshal(body, &objmap); err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	raw, ok := objmap["notification"]
+	if !ok || raw == nil {
+		notParsable(w, r, errors.New("missing notification"))
+		return
+	}
+	var notification Notification
+	if err := json.Unmarshal(*raw, &notification); err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	if err := MarkNotificationRead(notificationId, user.ID, notification.Read); err == sql.ErrNoRows {
+		notFoundError(w, r)
+		return
+	} else if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+})
+
+//courseAccess reads the course and checks that the user owns it or, unless manage is set, is a member of it.
+//Admins may access every course. On failure the response is already written.
+func courseAccess(w http.ResponseWriter, r *http.Request, courseId int, manage bool) (User, Course, bool) {
+	user, err := getUserFromRequest(r)
+	if err != nil {
+		notParsable(w, r, err)
+		return User{}, Course{}, false
+	}
+	course, err := GetCourseById(courseId)
+	if err == sql.ErrNoRows {
+		notFoundError(w, r)
+		return User{}, Course{}, false
+	} else if err != nil {
+		internalError(w, r, err)
+		return User{}, Course{}, false
+	}
+	if course.UserId == user.ID || user.isInGroup("admin") {
+		return user, course, true
+	}
+	if !manage {
+		member, err := IsCourseMember(courseId, user.ID)
+		if err != nil {
+			internalError(w, r, err)
+			return User{}, Course{}, false
+		}
+		if member {
+			//only teachers hand out the join code
+			course.JoinCode = ""
+			return user, course, true
+		}
+	}
+	notFoundError(w, r)
+	return User{}, Course{}, false
+}
+
+//readCourse parses the course of the request body and checks that the teacher may see all its units. On failure
+//the response is already written.
+func readCourse(w http.ResponseWriter, r *http.Request) (Course, bool) {
+	body, err := readBody(r)
+	if err != nil {
+		internalError(w, r, err)
+		return Course{}, false
+	}
+	var objmap map[string]*json.RawMessage
+	if err := json.Unmarshal(body, &objmap); err != nil {
+		notParsable(w, r, err)
+		return Course{}, false
+	}
+	raw, ok := objmap["course"]
+	if !ok || raw == nil {
+		notParsable(w, r, errors.New("missing course"))
+		return Course{}, false
+	}
+	var course Course
+	if err := json.Unmarshal(*raw, &course); err != nil {
+		notParsable(w, r, err)
+		return Course{}, false
+	}
+	course.Title = strings.TrimSpace(course.Title)
+	if len(course.Title) == 0 {
+		notParsable(w, r, errors.New("empty title"))
+		return Course{}, false
+	}
+	if course.Units == nil {
+		course.Units = make([]int, 0)
+	}
+	seen := make(map[int]bool)
+	for _, unitId := range course.Units {
+		if seen[unitId] {
+			notParsable(w, r, fmt.Errorf("unit %d is assigned twice", unitId))
+			return Course{}, false
+		}
+		seen[unitId] = true
+		if !requirePublicOrUnitView(w, r, unitId) {
+			return Course{}, false
+		}
+	}
+	return course, true
+}
+
+//newJoinCode generates a join code that is not used by another course yet
+func newJoinCode() (string, error) {
+	for {
+		code, err := generateJoinCode()
+		if err != nil {
+			return "", err
+		}
+		if exists, err := IsJoinCodeInDb(code); err != nil {
+			return "", err
+		} else if !exists {
+			return code, nil
+		}
+	}
+}
+
+var Courses = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	user, err := getUserFromRequest(r)
+	if err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	courses, err := GetUserCourses(user.ID)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	for i := range courses {
+		if courses[i].UserId != user.ID {
+			courses[i].JoinCode = ""
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"courses": courses}); err != nil {
+		panic(err)
+	}
+})
+
+var CourseById = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	courseId, err := strconv.Atoi(mux.Vars(r)["courseId"])
+	if err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	_, course, ok := courseAccess(w, r, courseId, false)
+	if !ok {
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"course": course}); err != nil {
+		panic(err)
+	}
+})
+
+var CreateCourse = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	user, err := getUserFromRequest(r)
+	if err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	course, ok := readCourse(w, r)
+	if !ok {
+		return
+	}
+	course.UserId = user.ID
+	if course.JoinCode, err = newJoinCode(); err != nil {
+		internalError(w, r, err)
+		return
+	}
+	courseId, err := InsertCourse(course)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	course, err = GetCourseById(courseId)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"course": course}); err != nil {
+		panic(err)
+	}
+})
+
+var UpdateCourse = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	courseId, err := strconv.Atoi(mux.Vars(r)["courseId"])
+	if err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	if _, _, ok := courseAccess(w, r, courseId, true); !ok {
+		return
+	}
+	course, ok := readCourse(w, r)
+	if !ok {
+		return
+	}
+	course.Id = courseId
+	if err := DbUpdateCourse(course); err != nil {
+		internalError(w, r, err)
+		return
+	}
+	course, err = GetCourseById(courseId)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"course": course}); err != nil {
+		panic(err)
+	}
+})
+
+var DeleteCourse = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	courseId, err := strconv.Atoi(mux.Vars(r)["courseId"])
+	if err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	if _, _, ok := courseAccess(w, r, courseId, true); !ok {
+		return
+	}
+	if err := DbDeleteCourse(courseId); err != nil {
+		internalError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+})
+
+var RenewJoinCode = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	courseId, err := strconv.Atoi(mux.Vars(r)["courseId"])
+	if err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	_, course, ok := courseAccess(w, r, courseId, true)
+	if !ok {
+		return
+	}
+	if course.JoinCode, err = newJoinCode(); err != nil {
+		internalError(w, r, err)
+		return
+	}
+	if err := SetCourseJoinCode(courseId, course.JoinCode); err != nil {
+		internalError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"course": course}); err != nil {
+		panic(err)
+	}
+})
+
+var JoinCourse = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	user, err := getUserFromRequest(r)
+	if err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	body, err := readBody(r)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	var objmap map[string]*json.RawMessage
+	if err := json.Unmarshal(body, &objmap); err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	raw, ok := objmap["courseMembership"]
+	if !ok || raw == nil {
+		notParsable(w, r, errors.New("missing courseMembership"))
+		return
+	}
+	var membership struct {
+		JoinCode string `json:"joinCode"`
+	}
+	if err := json.Unmarshal(*raw, &membership); err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	course, err := GetCourseByJoinCode(strings.ToUpper(strings.TrimSpace(membership.JoinCode)))
+	if err == sql.ErrNoRows {
+		notFoundError(w, r)
+		return
+	} else if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	if err := InsertCourseMember(course.Id, user.ID); err != nil {
+		internalError(w, r, err)
+		return
+	}
+	if course, err = GetCourseById(course.Id); err != nil {
+		internalError(w, r, err)
+		return
+	}
+	if course.UserId != user.ID {
+		course.JoinCode = ""
+	}
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"course": course}); err != nil {
+		panic(err)
+	}
+})
+
+var CourseMembers = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	courseId, err := strconv.Atoi(mux.Vars(r)["courseId"])
+	if err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	if _, _, ok := courseAccess(w, r, courseId, true); !ok {
+		return
+	}
+	members, err := GetCourseMembers(courseId)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"members": members}); err != nil {
+		panic(err)
+	}
+})
+
+//RemoveCourseMember lets teachers remove students from their course and students leave a course
+var RemoveCourseMember = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	courseId, err := strconv.Atoi(vars["courseId"])
+	if err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	memberId, err := strconv.Atoi(vars["userId"])
+	if err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	user, err := getUserFromRequest(r)
+	if err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	if memberId != user.ID {
+		if _, _, ok := courseAccess(w, r, courseId, true); !ok {
+			return
+		}
+	}
+	if err := DbRemoveCourseMember(courseId, memberId); err == sql.ErrNoRows {
+		notFoundError(w, r)
+		return
+	} else if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+})
+
+var CourseProgress = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	courseId, err := strconv.Atoi(mux.Vars(r)["courseId"])
+	if err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	if _, _, ok := courseAccess(w, r, courseId, true); !ok {
+		return
+	}
+	progress, err := GetCourseProgress(courseId)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"progress": progress}); err != nil {
+		panic(err)
+	}
+})
+
+//assignmentAccess loads the assignment for its teacher or, unless manage is set, for a member of its course.
+//Teachers get the assignment without a status. On failure the response is already written.
+func assignmentAccess(w http.ResponseWriter, r *http.Request, manage bool) (User, Assignment, bool) {
+	assignmentId, err := strconv.Atoi(mux.Vars(r)["assignmentId"])
+	if err != nil {
+		notParsable(w, r, err)
+		return User{}, Assignment{}, false
+	}
+	user, err := getUserFromRequest(r)
+	if err != nil {
+		notParsable(w, r, err)
+		return User{}, Assignment{}, false
+	}
+	assignment, err := GetAssignmentById(assignmentId, user.ID, true)
+	if err == sql.ErrNoRows {
+		notFoundError(w, r)
+		return User{}, Assignment{}, false
+	} else if err != nil {
+		internalError(w, r, err)
+		return User{}, Assignment{}, false
+	}
+	_, course, ok := courseAccess(w, r, assignment.CourseId, manage)
+	if !ok {
+		return User{}, Assignment{}, false
+	}
+	if course.UserId == user.ID || user.isInGroup("admin") {
+		assignment.Status, assignment.SubmittedAt, assignment.Overdue = "", nil, false
+	}
+	return user, assignment, true
+}
+
+//readAssignment parses the assignment of the request body and checks that the teacher may see its unit. On
+//failure the response is already written.
+func readAssignment(w http.ResponseWriter, r *http.Request) (Assignment, bool) {
+	body, err := readBody(r)
+	if err != nil {
+		internalError(w, r, err)
+		return Assignment{}, false
+	}
+	var objmap map[string]*json.RawMessage
+	if err := json.Unmarshal(body, &objmap); err != nil {
+		notParsable(w, r, err)
+		return Assignment{}, false
+	}
+	raw, ok := objmap["assignment"]
+	if !ok || raw == nil {
+		notParsable(w, r, errors.New("missing assignment"))
+		return Assignment{}, false
+	}
+	var assignment Assignment
+	if err := json.Unmarshal(*raw, &assignment); err != nil {
+		notParsable(w, r, err)
+		return Assignment{}, false
+	}
+	assignment.Title = strings.TrimSpace(assignment.Title)
+	if len(assignment.Title) == 0 {
+		notParsable(w, r, errors.New("empty title"))
+		return Assignment{}, false
+	}
+	if assignment.DueAt.IsZero() {
+		notParsable(w, r, errors.New("missing dueAt"))
+		return Assignment{}, false
+	}
+	if !requirePublicOrUnitView(w, r, assignment.UnitId) {
+		return Assignment{}, false
+	}
+	return assignment, true
+}
+
+var Assignments = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	user, err := getUserFromRequest(r)
+	if err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	assignments, err := GetUserAssignments(user.ID)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"assignments": assignments}); err != nil {
+		panic(err)
+	}
+})
+
+var AssignmentById = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	_, assignment, ok := assignmentAccess(w, r, false)
+	if !ok {
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"assignment": assignment}); err != nil {
+		panic(err)
+	}
+})
+
+var CreateAssignment = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	assignment, ok := readAssignment(w, r)
+	if !ok {
+		return
+	}
+	user, _, ok := courseAccess(w, r, assignment.CourseId, true)
+	if !ok {
+		return
+	}
+	assignmentId, err := InsertAssignment(assignment)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	assignment, err = GetAssignmentById(assignmentId, user.ID, false)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"assignment": assignment}); err != nil {
+		panic(err)
+	}
+})
+
+//UpdateAssignment changes unit, title, description and due date, an assignment stays in its course
+var UpdateAssignment = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	user, current, ok := assignmentAccess(w, r, true)
+	if !ok {
+		return
+	}
+	assignment, ok := readAssignment(w, r)
+	if !ok {
+		return
+	}
+	assignment.Id = current.Id
+	if err := DbUpdateAssignment(assignment); err != nil {
+		internalError(w, r, err)
+		return
+	}
+	assignment, err := GetAssignmentById(current.Id, user.ID, false)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"assignment": assignment}); err != nil {
+		panic(err)
+	}
+})
+
+var DeleteAssignment = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	_, assignment, ok := assignmentAccess(w, r, true)
+	if !ok {
+		return
+	}
+	if err := DbDeleteAssignment(assignment.Id); err != nil {
+		internalError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+})
+
+//SubmitAssignment hands in the assignment once the student decided the unit and every argument in it. A
+//submission after the due date is accepted and marked late.
+var SubmitAssignment = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	user, assignment, ok := assignmentAccess(w, r, false)
+	if !ok {
+		return
+	}
+	member, err := IsCourseMember(assignment.CourseId, user.ID)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	if !member {
+		notFoundError(w, r)
+		return
+	}
+	missing, err := CountMissingDecisions(user.ID, assignment.UnitId)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	if missing > 0 {
+		conflict(w, r, fmt.Sprintf("%d decisions are missing", missing))
+		return
+	}
+	inserted, err := InsertAssignmentSubmission(assignment.Id, user.ID)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	if !inserted {
+		conflict(w, r, "Assignment already submitted")
+		return
+	}
+	assignment, err = GetAssignmentById(assignment.Id, user.ID, true)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"assignment": assignment}); err != nil {
+		panic(err)
+	}
+})
+
+//AssignmentSubmissions is the grading view with the status and the row decisions of every student
+var AssignmentSubmissions = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	_, assignment, ok := assignmentAccess(w, r, true)
+	if !ok {
+		return
+	}
+	submissions, err := GetAssignmentSubmissions(assignment)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"assignment": assignment, "submissions": submissions}); err != nil {
+		panic(err)
+	}
+})
+
+//readOrder parses the list of ids under key from the request body. On failure the response is already written.
+func readOrder(w http.ResponseWriter, r *http.Request, key string) ([]int, bool) {
+	body, err := readBody(r)
+	if err != nil {
+		internalError(w, r, err)
+		return nil, false
+	}
+	var objmap map[string]*json.RawMessage
+	if err := json.Unmarshal(body, &objmap); err != nil {
+		notParsable(w, r, err)
+		return nil, false
+	}
+	raw, ok := objmap[key]
+	if !ok || raw == nil {
+		notParsable(w, r, fmt.Errorf("missing %s", key))
+		return nil, false
+	}
+	var ids []int
+	if err := json.Unmarshal(*raw, &ids); err != nil {
+		notParsable(w, r, err)
+		return nil, false
+	}
+	return ids, true
+}
+
+//ReorderRows sets the order of all rows of a page, the body lists the row ids in the new order
+var ReorderRows = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	pageId, err := strconv.Atoi(mux.Vars(r)["pageId"])
+	if err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	unitId, err := GetPageUnitId(pageId)
+	if err == sql.ErrNoRows {
+		notFoundError(w, r)
+		return
+	} else if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	user, err := getUserFromRequest(r)
+	if err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	if !requireUnitAccess(w, r, user, unitId, unitEdit) {
+		return
+	}
+	rowIds, ok := readOrder(w, r, "rows")
+	if !ok {
+		return
+	}
+	version, ok := ifMatchVersion(w, r)
+	if !ok {
+		return
+	}
+	_, err = SetRowOrder(pageId, version, rowIds, newRevision(unitId, user, "reorder rows"))
+	if err == errVersionConflict {
+		current, err := GetPageById(pageId)
+		if err != nil {
+			internalError(w, r, err)
+			return
+		}
+		preconditionFailed(w, r, "page", current, current.Version)
+		return
+	} else if err == errInvalidOrder {
+		invalidFields(w, r, []FieldError{{"rows", err.Error()}})
+		return
+	} else if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	page, err := GetPageById(pageId)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	w.Header().Set("ETag", etag(page.Version))
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"page": page}); err != nil {
+		panic(err)
+	}
+})
+
+//ReorderPages sets the order of all pages of a unit, the body lists the page ids in the new order
+var ReorderPages = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	unitId, err := strconv.Atoi(mux.Vars(r)["unitId"])
+	if err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	user, err := getUserFromRequest(r)
+	if err != nil {
+		notParsable(w, r, err)
+		return
+	}
+	if !requireUnitAccess(w, r, user, unitId, unitEdit) {
+		return
+	}
+	pageIds, ok := readOrder(w, r, "pages")
+	if !ok {
+		return
+	}
+	version, ok := ifMatchVersion(w, r)
+	if !ok {
+		return
+	}
+	_, err = SetPageOrder(unitId, version, pageIds, newRevision(unitId, user, "reorder pages"))
+	if err == errVersionConflict {
+		current, err := GetUnit(unitId)
+		if err == sql.ErrNoRows {
+			notFoundError(w, r)
+		} else if err != nil {
+			internalError(w, r, err)
+		} else {
+			preconditionFailed(w, r, "unit", current, current.Version)
+		}
+		return
+	} else if err == errInvalidOrder {
+		invalidFields(w, r, []FieldError{{"pages", err.Error()}})
+		return
+	} else if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	unit, err := GetUnit(unitId)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	w.Header().Set("ETag", etag(unit.Version))
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"unit": unit}); err != nil {
+		panic(err)
+	}
+})
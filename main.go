@@ -22,20 +22,38 @@ type SMTPConfig struct {
 	From     string
 }
 
+type SigningKeyConfig struct {
+	ID             string
+	Algorithm      string
+	Secret         string
+	SecretEnv      string
+	PrivateKeyFile string
+	PublicKeyFile  string
+}
+
+type JWTConfig struct {
+	CurrentKey         string
+	Keys               []SigningKeyConfig
+	AccessTokenMinutes int
+	RefreshTokenHours  int
+}
+
 type Config struct {
-	UseTLS       bool
-	HTTPPort     int
-	HTTPSPort    int
-	PemFile      string
-	KeyFile      string
-	DBName       string
-	DBUser       string
-	DBPassword   string
-	ImageStorage string
-	StaticFolder string
-	AppUrl       string
-	LogFile      string
-	MailConfig   SMTPConfig
+	UseTLS          bool
+	HTTPPort        int
+	HTTPSPort       int
+	PemFile         string
+	KeyFile         string
+	DBName          string
+	DBUser          string
+	DBPassword      string
+	ImageStorage    string
+	StaticFolder    string
+	AppUrl          string
+	LogFile         string
+	MailConfig      SMTPConfig
+	JWT             JWTConfig
+	ScheduleSeconds int
 }
 
 var conf Config
@@ -49,6 +67,12 @@ func main() {
 		fmt.Println(err)
 		os.Exit(-1)
 	}
+	var err error
+	if signingKeys, err = loadKeyring(conf.JWT); err != nil {
+		fmt.Println("Error loading JWT signing keys")
+		fmt.Println(err)
+		os.Exit(-1)
+	}
 	lJack := lumberjack.Logger{
 		Filename:   conf.LogFile,
 		MaxSize:    10, // megabytes
@@ -57,6 +81,7 @@ func main() {
 	}
 	log.SetOutput(&lJack)
 	initDB(conf.DBName, conf.DBUser, conf.DBPassword)
+	go runScheduler(scheduleInterval())
 
 	router := NewRouter()
 	http.Handle("/", router)
@@ -1,15 +1,78 @@
 package main
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"time"
+)
+
+//states of the editorial workflow of a unit
+const (
+	statusDraft            = "draft"
+	statusSubmitted        = "submitted"
+	statusChangesRequested = "changes-requested"
+	statusApproved         = "approved"
+	statusPublished        = "published"
+	statusArchived         = "archived"
+)
+
+type statusTransition struct {
+	from   string
+	to     string
+	action unitAction
+}
+
+//unitTransitions lists every allowed status change of a unit together with the permission it needs
+var unitTransitions = []statusTransition{
+	{statusDraft, statusSubmitted, unitEdit},
+	{statusSubmitted, statusDraft, unitEdit},
+	{statusChangesRequested, statusSubmitted, unitEdit},
+	{statusSubmitted, statusChangesRequested, unitReview},
+	{statusSubmitted, statusApproved, unitReview},
+	{statusApproved, statusChangesRequested, unitReview},
+	{statusApproved, statusPublished, unitReview},
+	{statusPublished, statusArchived, unitReview},
+	{statusArchived, statusDraft, unitEdit},
+}
+
+func findUnitTransition(from, to string) (statusTransition, bool) {
+	for _, transition := range unitTransitions {
+		if transition.from == from && transition.to == to {
+			return transition, true
+		}
+	}
+	return statusTransition{}, false
+}
 
 type ErrorImage struct {
-	path           string
-	CorrectImageId int      `json:"correctImage"`
-	Scale          float64  `json:"scale"`
-	ErrorCircles   []Circle `json:"errorCircles"`
-	UserId         int      `json:"user"`
-	ID             int      `json:"id" db:"id"`
-	Published      bool     `json:"published"`
+	path             string
+	CorrectImageId   int      `json:"correctImage"`
+	Scale            float64  `json:"scale"`
+	ErrorCircles     []Circle `json:"errorCircles"`
+	CircleCount      int      `json:"circleCount"`
+	UserId           int      `json:"user"`
+	ID               int      `json:"id" db:"id"`
+	Published        bool     `json:"published"`
+	ModerationStatus string   `json:"moderationStatus"`
+	ModerationReason string   `json:"moderationReason,omitempty"`
+}
+
+//moderation states of error images, only approved ones are published
+const (
+	moderationPending  = "pending"
+	moderationApproved = "approved"
+	moderationRejected = "rejected"
+)
+
+//Notification tells a user about something that happened to their content, e.g. the moderation of an error image
+type Notification struct {
+	UserId    int        `json:"user"`
+	Kind      string     `json:"kind"`
+	Message   string     `json:"message"`
+	RefId     int        `json:"ref,omitempty"`
+	CreatedAt time.Time  `json:"createdAt"`
+	ReadAt    *time.Time `json:"readAt"`
+	Read      bool       `json:"read"`
+	Id        int        `json:"id"`
 }
 
 type Circle struct {
@@ -33,7 +96,7 @@ type Image struct {
 }
 
 type RotateImage struct {
-	basepath  string `json:"basepath" db:"basepath"`
+	basepath  string
 	Num       int    `json:"numImages" db:"num"`
 	Caption   string `json:"caption" db:"caption"`
 	Credits   string `json:"credits" db:"credits"`
@@ -54,6 +117,8 @@ type Row struct {
 	RightIsArgument bool   `json:"right_is_argument" db:"right_is_argument"`
 	ID              int    `json:"id" db:"row_id"`
 	UserId          int    `json:"-" db:"user_id"`
+	Version         int    `json:"version" db:"version"`
+	Position        int    `json:"position" db:"position"`
 }
 
 type Page struct {
@@ -64,20 +129,113 @@ type Page struct {
 	ID           int    `json:"id" db:"id"`
 	userId       int
 	published    bool
-	PageResultID int `json:"pageResult" db:"page_result_id"`
+	PageResultID int        `json:"pageResult" db:"page_result_id"`
+	Version      int        `json:"version" db:"version"`
+	Position     int        `json:"position" db:"position"`
+	Questions    []Question `json:"questions,omitempty"`
 }
 
 type Unit struct {
-	Title       string `json:"title" db:"title"`
-	UnitImageID int    `json:"rotateImage" db:"rotate_image_id"`
-	PageIds     []int  `json:"pages" db:"pageids"`
-	Published   bool   `json:"published" db:"published"`
-	ColorScheme int    `json:"color_scheme" db:"color_scheme"`
-	UserId      int    `json:"user" db:"userid"`
-	ImageIds    []int  `json:"images" db:"image_ids"`
-	CiteIds     []int  `json:"cites" db:"cite_ids"`
-	FrontImage  int    `json:"front_image" db:"front_image"`
-	ID          int    `json:"id" db:"id"`
+	Title       string     `json:"title" db:"title"`
+	UnitImageID int        `json:"rotateImage" db:"rotate_image_id"`
+	PageIds     []int      `json:"pages" db:"pageids"`
+	Published   bool       `json:"published" db:"published"`
+	ColorScheme int        `json:"color_scheme" db:"color_scheme"`
+	UserId      int        `json:"user" db:"userid"`
+	ImageIds    []int      `json:"images" db:"image_ids"`
+	CiteIds     []int      `json:"cites" db:"cite_ids"`
+	FrontImage  int        `json:"front_image" db:"front_image"`
+	ID          int        `json:"id" db:"id"`
+	Status      string     `json:"status" db:"status"`
+	PublishAt   *time.Time `json:"publishAt" db:"publish_at"`
+	UnpublishAt *time.Time `json:"unpublishAt" db:"unpublish_at"`
+	Version     int        `json:"version" db:"version"`
+	IsTemplate  bool       `json:"template" db:"is_template"`
+}
+
+type UnitCloneOptions struct {
+	Title    string `json:"title"`
+	Template bool   `json:"template"`
+}
+
+//unitCopy maps the images of a cloned unit to their copies, so their files can be copied too
+type unitCopy struct {
+	UnitId       int
+	Images       map[int]int
+	RotateImages map[int]int
+}
+
+//clonedFiles are the files copied for a clone. Images and RotateImages map the copies to their new paths,
+//created lists every file and folder written, so they can be removed if the clone fails.
+type clonedFiles struct {
+	Images       map[int]string
+	RotateImages map[int]string
+	created      []string
+}
+
+type UnitSchedule struct {
+	PublishAt   *time.Time `json:"publishAt"`
+	UnpublishAt *time.Time `json:"unpublishAt"`
+}
+
+type Cite struct {
+	Abbrev    string   `json:"abbrev" db:"abbrev"`
+	Text      string   `json:"text" db:"cite_text"`
+	UnitID    int      `json:"unit" db:"unit_id"`
+	ID        int      `json:"id" db:"cite_id"`
+	Type      string   `json:"type" db:"cite_type"`
+	Authors   []string `json:"authors" db:"authors"`
+	Title     string   `json:"title" db:"title"`
+	Year      int      `json:"year" db:"year"`
+	Publisher string   `json:"publisher" db:"publisher"`
+	URL       string   `json:"url" db:"url"`
+	DOI       string   `json:"doi" db:"doi"`
+}
+
+type UnitStatusEvent struct {
+	UnitId    int       `json:"unit"`
+	UserId    int       `json:"user"`
+	Username  string    `json:"name"`
+	From      string    `json:"from"`
+	To        string    `json:"to"`
+	Comment   string    `json:"comment"`
+	CreatedAt time.Time `json:"createdAt"`
+	ID        int       `json:"id"`
+}
+
+//UnitSnapshot is the content of a unit at one point in time as it is stored in a revision
+type UnitSnapshot struct {
+	Title       string         `json:"title"`
+	UnitImageID int            `json:"rotateImage"`
+	ColorScheme int            `json:"color_scheme"`
+	FrontImage  int            `json:"front_image"`
+	Pages       []PageSnapshot `json:"pages"`
+}
+
+type PageSnapshot struct {
+	Title     string     `json:"title"`
+	PageType  string     `json:"page_type"`
+	ID        int        `json:"id"`
+	Position  int        `json:"position"`
+	Rows      []Row      `json:"rows"`
+	Questions []Question `json:"questions,omitempty"`
+}
+
+type UnitRevision struct {
+	UnitId    int           `json:"unit"`
+	UserId    int           `json:"user"`
+	Username  string        `json:"name"`
+	Action    string        `json:"action"`
+	CreatedAt time.Time     `json:"createdAt"`
+	ID        int           `json:"id"`
+	Snapshot  *UnitSnapshot `json:"snapshot,omitempty"`
+}
+
+type UnitMember struct {
+	UnitId   int    `json:"unit"`
+	UserId   int    `json:"user"`
+	Username string `json:"name"`
+	Role     string `json:"role"`
 }
 
 type Result struct {
@@ -88,13 +246,21 @@ type Result struct {
 }
 
 type PageResult struct {
-	RowResults []Result `json:"rowResults"`
-	PageId     int      `json:"page"`
-	UnitId     int      `json:"unit"`
-	UserId     int      `json:"user"`
-	Id         int      `json:"id"`
+	RowResults      []Result         `json:"rowResults"`
+	PageId          int              `json:"page"`
+	UnitId          int              `json:"unit"`
+	UserId          int              `json:"user"`
+	Id              int              `json:"id"`
+	QuestionResults []QuestionResult `json:"questionResults"`
 }
 
+//decisions a student can take at the end of a unit
+const (
+	decisionPro       = "pro"
+	decisionCon       = "con"
+	decisionUndecided = "undecided"
+)
+
 type UnitResult struct {
 	Decision string `json:"decision"`
 	UnitId   int    `json:"id"`
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+//page types consisting of rows, see quiz.go for the page types consisting of questions
+const (
+	pageTypeDefault = "default"
+	pageTypeText    = "text"
+)
+
+//kinds of the fields in the page type registry
+const (
+	fieldMarkdown = "markdown"
+	fieldBoolean  = "boolean"
+	fieldImage    = "image"
+	fieldText     = "text"
+	fieldTexts    = "texts"
+	fieldIndices  = "indices"
+)
+
+//PageField describes a field of the rows or questions of a page type. A field with RequiredBy is required if that
+//boolean field is set and must be empty otherwise.
+type PageField struct {
+	Name       string `json:"name"`
+	Kind       string `json:"kind"`
+	Required   bool   `json:"required"`
+	RequiredBy string `json:"requiredBy,omitempty"`
+}
+
+//PageType describes which fields the rows or questions of a page use. A page has either rows or questions,
+//fields a page type does not use must be empty.
+type PageType struct {
+	Name           string      `json:"name"`
+	Title          string      `json:"title"`
+	RowFields      []PageField `json:"rowFields"`
+	QuestionFields []PageField `json:"questionFields"`
+	MinOptions     int         `json:"minOptions,omitempty"`
+	//checkQuestion returns the field and the message of a problem the field descriptions can not express
+	checkQuestion func(question Question) (string, string)
+}
+
+var rowFieldNames = []string{"left_markdown", "left_has_image", "leftImage", "left_is_argument", "right_markdown", "right_has_image", "rightImage", "right_is_argument"}
+
+var questionFieldNames = []string{"prompt", "options", "correct", "accepted"}
+
+var imageFields = []PageField{
+	{"left_markdown", fieldMarkdown, false, ""},
+	{"left_has_image", fieldBoolean, false, ""},
+	{"leftImage", fieldImage, false, "left_has_image"},
+	{"right_markdown", fieldMarkdown, false, ""},
+	{"right_has_image", fieldBoolean, false, ""},
+	{"rightImage", fieldImage, false, "right_has_image"},
+}
+
+var choiceFields = []PageField{
+	{"prompt", fieldText, true, ""},
+	{"options", fieldTexts, true, ""},
+	{"correct", fieldIndices, true, ""},
+}
+
+//pageTypes is the registry of all page types, GET /pageTypes hands it to the editor
+var pageTypes = []PageType{
+	{
+		Name:      pageTypeDefault,
+		Title:     "Zwei Spalten mit Argumenten",
+		RowFields: append(append([]PageField{}, imageFields...), PageField{"left_is_argument", fieldBoolean, false, ""}, PageField{"right_is_argument", fieldBoolean, false, ""}),
+	},
+	{
+		Name:      pageTypeText,
+		Title:     "Text und Bilder ohne Argumente",
+		RowFields: imageFields,
+	},
+	{
+		Name:           pageTypeSingleChoice,
+		Title:          "Einfachauswahl",
+		QuestionFields: choiceFields,
+		MinOptions:     2,
+		checkQuestion: func(question Question) (string, string) {
+			if len(question.Correct) != 1 {
+				return "correct", "exactly one option must be correct"
+			}
+			return "", ""
+		},
+	},
+	{
+		Name:           pageTypeMultipleChoice,
+		Title:          "Mehrfachauswahl",
+		QuestionFields: choiceFields,
+		MinOptions:     2,
+	},
+	{
+		Name:           pageTypeOrdering,
+		Title:          "Reihenfolge",
+		QuestionFields: choiceFields,
+		MinOptions:     2,
+		checkQuestion: func(question Question) (string, string) {
+			if len(question.Correct) != len(question.Options) {
+				return "correct", "the correct order must contain every option"
+			}
+			return "", ""
+		},
+	},
+	{
+		Name:           pageTypeFreeText,
+		Title:          "Freitext",
+		QuestionFields: []PageField{{"prompt", fieldText, true, ""}, {"accepted", fieldTexts, true, ""}},
+	},
+}
+
+func findPageType(name string) (PageType, bool) {
+	for _, pageType := range pageTypes {
+		if pageType.Name == name {
+			return pageType, true
+		}
+	}
+	return PageType{}, false
+}
+
+func isQuizPage(name string) bool {
+	pageType, ok := findPageType(name)
+	return ok && len(pageType.QuestionFields) > 0
+}
+
+func rowValues(row Row) map[string]interface{} {
+	return map[string]interface{}{
+		"left_markdown":     row.LeftMarkdown,
+		"left_has_image":    row.LeftHasImage,
+		"leftImage":         row.LeftImage,
+		"left_is_argument":  row.LeftIsArgument,
+		"right_markdown":    row.RightMarkdown,
+		"right_has_image":   row.RightHasImage,
+		"rightImage":        row.RightImage,
+		"right_is_argument": row.RightIsArgument,
+	}
+}
+
+func questionValues(question Question) map[string]interface{} {
+	return map[string]interface{}{
+		"prompt":   question.Prompt,
+		"options":  question.Options,
+		"correct":  question.Correct,
+		"accepted": question.Accepted,
+	}
+}
+
+func isEmptyValue(value interface{}) bool {
+	switch v := value.(type) {
+	case string:
+		return strings.TrimSpace(v) == ""
+	case bool:
+		return !v
+	case int:
+		return v == 0
+	case []string:
+		return len(v) == 0
+	case []int:
+		return len(v) == 0
+	}
+	return value == nil
+}
+
+//checkFields appends an error for every field in values that violates the field descriptions. Images must
+//belong to the unit of the page.
+func checkFields(errs []FieldError, prefix, pageType string, fields []PageField, names []string, values map[string]interface{}, images map[int]bool) []FieldError {
+	used := make(map[string]bool)
+	for _, field := range fields {
+		used[field.Name] = true
+	}
+	for _, name := range names {
+		if !used[name] && !isEmptyValue(values[name]) {
+			errs = append(errs, FieldError{prefix + name, fmt.Sprintf("not used by page type %q", pageType)})
+		}
+	}
+	for _, field := range fields {
+		value := values[field.Name]
+		empty := isEmptyValue(value)
+		switch {
+		case field.Required && empty:
+			errs = append(errs, FieldError{prefix + field.Name, "required"})
+		case field.RequiredBy != "" && values[field.RequiredBy] == true && empty:
+			errs = append(errs, FieldError{prefix + field.Name, "required if " + field.RequiredBy + " is set"})
+		case field.RequiredBy != "" && values[field.RequiredBy] != true && !empty:
+			errs = append(errs, FieldError{prefix + field.Name, "only allowed if " + field.RequiredBy + " is set"})
+		case field.Kind == fieldImage && !empty && !images[value.(int)]:
+			errs = append(errs, FieldError{prefix + field.Name, "no image of this unit"})
+		case field.Kind == fieldTexts:
+			for i, text := range value.([]string) {
+				if strings.TrimSpace(text) == "" {
+					errs = append(errs, FieldError{fmt.Sprintf("%s%s.%d", prefix, field.Name, i), "empty"})
+				}
+			}
+		}
+	}
+	return errs
+}
+
+//validatePage checks the rows and questions of the page against its page type. images are the ids of the images
+//of the unit.
+func validatePage(page Page, images map[int]bool) []FieldError {
+	errs := make([]FieldError, 0)
+	pageType, ok := findPageType(page.PageType)
+	if !ok {
+		return append(errs, FieldError{"page_type", fmt.Sprintf("unknown page type %q", page.PageType)})
+	}
+	if len(pageType.RowFields) == 0 && len(page.Rows) > 0 {
+		errs = append(errs, FieldError{"rows", fmt.Sprintf("page type %q has no rows", pageType.Name)})
+	}
+	if len(pageType.QuestionFields) == 0 && len(page.Questions) > 0 {
+		errs = append(errs, FieldError{"questions", fmt.Sprintf("page type %q has no questions", pageType.Name)})
+	}
+	if len(pageType.QuestionFields) > 0 && len(page.Questions) == 0 {
+		errs = append(errs, FieldError{"questions", "at least one question is required"})
+	}
+	for i, row := range page.Rows {
+		errs = checkFields(errs, fmt.Sprintf("rows.%d.", i), pageType.Name, pageType.RowFields, rowFieldNames, rowValues(row), images)
+	}
+	for i, question := range page.Questions {
+		prefix := fmt.Sprintf("questions.%d.", i)
+		before := len(errs)
+		errs = checkFields(errs, prefix, pageType.Name, pageType.QuestionFields, questionFieldNames, questionValues(question), images)
+		if len(errs) > before {
+			continue
+		}
+		if len(question.Options) < pageType.MinOptions {
+			errs = append(errs, FieldError{prefix + "options", fmt.Sprintf("at least %d options are required", pageType.MinOptions)})
+		} else if !distinctOptions(question.Correct, len(question.Options)) {
+			errs = append(errs, FieldError{prefix + "correct", "options out of range or repeated"})
+		} else if pageType.checkQuestion != nil {
+			if field, message := pageType.checkQuestion(question); message != "" {
+				errs = append(errs, FieldError{prefix + field, message})
+			}
+		}
+	}
+	return errs
+}
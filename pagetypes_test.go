@@ -0,0 +1,129 @@
+package main
+
+import "testing"
+
+// TestPageTypeRegistry checks the registry against the fields validatePage knows, a typo there would silently
+// allow or forbid a field
+func TestPageTypeRegistry(t *testing.T) {
+	names := make(map[string]bool)
+	for _, pageType := range pageTypes {
+		if names[pageType.Name] {
+			t.Errorf("page type %q is registered twice", pageType.Name)
+		}
+		names[pageType.Name] = true
+		if (len(pageType.RowFields) > 0) == (len(pageType.QuestionFields) > 0) {
+			t.Errorf("page type %q must have either rows or questions", pageType.Name)
+		}
+		for _, group := range []struct {
+			fields []PageField
+			known  []string
+		}{{pageType.RowFields, rowFieldNames}, {pageType.QuestionFields, questionFieldNames}} {
+			kinds := make(map[string]string)
+			for _, field := range group.fields {
+				if !stringInSlice(field.Name, group.known) {
+					t.Errorf("page type %q: unknown field %q", pageType.Name, field.Name)
+				}
+				kinds[field.Name] = field.Kind
+			}
+			for _, field := range group.fields {
+				if field.RequiredBy != "" && kinds[field.RequiredBy] != fieldBoolean {
+					t.Errorf("page type %q: %q is required by %q, which is no boolean field of the page type", pageType.Name, field.Name, field.RequiredBy)
+				}
+			}
+		}
+	}
+	for _, name := range []string{pageTypeDefault, pageTypeText, pageTypeSingleChoice, pageTypeMultipleChoice, pageTypeOrdering, pageTypeFreeText} {
+		if !names[name] {
+			t.Errorf("page type %q is not registered", name)
+		}
+	}
+}
+
+// fieldErrors maps the fields of the errors to their messages
+func fieldErrors(errs []FieldError) map[string]string {
+	messages := make(map[string]string)
+	for _, err := range errs {
+		messages[err.Field] = err.Message
+	}
+	return messages
+}
+
+func TestValidatePageRows(t *testing.T) {
+	images := map[int]bool{5: true, 6: true}
+	page := Page{PageType: pageTypeDefault, Rows: []Row{
+		{LeftMarkdown: "ok", RightHasImage: true, RightImage: 5, RightIsArgument: true},
+		{LeftHasImage: true},
+		{RightImage: 6},
+		{LeftHasImage: true, LeftImage: 99},
+	}}
+	got := fieldErrors(validatePage(page, images))
+	want := map[string]string{
+		"rows.1.leftImage":  "required if left_has_image is set",
+		"rows.2.rightImage": "only allowed if right_has_image is set",
+		"rows.3.leftImage":  "no image of this unit",
+	}
+	if len(got) != len(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	for field, message := range want {
+		if got[field] != message {
+			t.Errorf("%s: got %q, want %q", field, got[field], message)
+		}
+	}
+
+	page.PageType = pageTypeText
+	page.Rows = page.Rows[:1]
+	if got := fieldErrors(validatePage(page, images)); len(got) != 1 || got["rows.0.right_is_argument"] == "" {
+		t.Errorf("arguments on a text page: got %v", got)
+	}
+}
+
+func TestValidatePageQuestions(t *testing.T) {
+	valid := map[string]Question{
+		pageTypeSingleChoice:   {Prompt: "?", Options: []string{"a", "b"}, Correct: []int{1}},
+		pageTypeMultipleChoice: {Prompt: "?", Options: []string{"a", "b", "c"}, Correct: []int{0, 2}},
+		pageTypeOrdering:       {Prompt: "?", Options: []string{"a", "b", "c"}, Correct: []int{2, 0, 1}},
+		pageTypeFreeText:       {Prompt: "?", Accepted: []string{"a"}},
+	}
+	for pageType, question := range valid {
+		if errs := validatePage(Page{PageType: pageType, Questions: []Question{question}}, nil); len(errs) > 0 {
+			t.Errorf("%s: valid question rejected with %v", pageType, errs)
+		}
+		if errs := fieldErrors(validatePage(Page{PageType: pageType}, nil)); errs["questions"] == "" {
+			t.Errorf("%s: a quiz without questions is accepted", pageType)
+		}
+	}
+
+	invalid := []struct {
+		pageType string
+		question Question
+		field    string
+	}{
+		{pageTypeSingleChoice, Question{Prompt: "?", Options: []string{"a", "b"}, Correct: []int{0, 1}}, "questions.0.correct"},
+		{pageTypeSingleChoice, Question{Prompt: "?", Options: []string{"a"}, Correct: []int{0}}, "questions.0.options"},
+		{pageTypeMultipleChoice, Question{Prompt: "?", Options: []string{"a", "b"}, Correct: []int{1, 1}}, "questions.0.correct"},
+		{pageTypeMultipleChoice, Question{Prompt: "?", Options: []string{"a", "b"}, Correct: []int{2}}, "questions.0.correct"},
+		{pageTypeMultipleChoice, Question{Prompt: "?", Options: []string{"a", " "}, Correct: []int{0}}, "questions.0.options.1"},
+		{pageTypeOrdering, Question{Prompt: "?", Options: []string{"a", "b", "c"}, Correct: []int{2, 0}}, "questions.0.correct"},
+		{pageTypeFreeText, Question{Prompt: "?", Options: []string{"a"}, Accepted: []string{"a"}}, "questions.0.options"},
+		{pageTypeFreeText, Question{Prompt: " ", Accepted: []string{"a"}}, "questions.0.prompt"},
+	}
+	for _, test := range invalid {
+		errs := fieldErrors(validatePage(Page{PageType: test.pageType, Questions: []Question{test.question}}, nil))
+		if len(errs) != 1 || errs[test.field] == "" {
+			t.Errorf("%s %+v: got %v, want an error for %s", test.pageType, test.question, errs, test.field)
+		}
+	}
+}
+
+func TestValidatePageMixedContent(t *testing.T) {
+	if errs := fieldErrors(validatePage(Page{PageType: pageTypeDefault, Questions: []Question{{Prompt: "?"}}}, nil)); errs["questions"] == "" {
+		t.Errorf("questions on a row page: got %v", errs)
+	}
+	if errs := fieldErrors(validatePage(Page{PageType: pageTypeFreeText, Rows: []Row{{}}, Questions: []Question{{Prompt: "?", Accepted: []string{"a"}}}}, nil)); errs["rows"] == "" {
+		t.Errorf("rows on a quiz page: got %v", errs)
+	}
+	if errs := fieldErrors(validatePage(Page{PageType: "timeline"}, nil)); errs["page_type"] == "" {
+		t.Errorf("unknown page type: got %v", errs)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"time"
+)
+
+//events for which the server awards points, every event is awarded only once per user and referenced object
+const (
+	pointsErrorCircleFound = "error-circle-found"
+	pointsImageDated       = "image-dated"
+	pointsUnitFinished     = "unit-finished"
+	pointsLegacy           = "legacy"
+)
+
+var pointValues = map[string]int{
+	pointsErrorCircleFound: 10,
+	pointsImageDated:       20,
+	pointsUnitFinished:     50,
+}
+
+//time windows of the leaderboard
+const (
+	leaderboardWeek     = "week"
+	leaderboardSemester = "semester"
+	leaderboardAll      = "all"
+)
+
+const (
+	leaderboardPageSize    = 20
+	leaderboardMaxPageSize = 100
+)
+
+type PointEvent struct {
+	UserId    int       `json:"user"`
+	Event     string    `json:"event"`
+	RefId     int       `json:"ref"`
+	Points    int       `json:"points"`
+	CreatedAt time.Time `json:"createdAt"`
+	Id        int       `json:"id"`
+}
+
+type LeaderboardEntry struct {
+	Rank     int    `json:"rank"`
+	UserId   int    `json:"user"`
+	Username string `json:"name"`
+	Points   int    `json:"points"`
+}
+
+//leaderboardStart returns the first moment counted in the window. Weeks start on monday, semesters follow the
+//school year and start on the first of february and august.
+func leaderboardStart(window string, now time.Time) time.Time {
+	switch window {
+	case leaderboardWeek:
+		day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+		return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+	case leaderboardSemester:
+		switch {
+		case now.Month() >= time.August:
+			return time.Date(now.Year(), time.August, 1, 0, 0, 0, 0, now.Location())
+		case now.Month() >= time.February:
+			return time.Date(now.Year(), time.February, 1, 0, 0, 0, 0, now.Location())
+		default:
+			return time.Date(now.Year()-1, time.August, 1, 0, 0, 0, 0, now.Location())
+		}
+	}
+	return time.Time{}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLeaderboardStartWeek(t *testing.T) {
+	monday := time.Date(2026, time.October, 12, 0, 0, 0, 0, time.UTC)
+	//every moment up to sunday night belongs to the week starting monday at midnight
+	for day := 0; day < 7; day++ {
+		for _, now := range []time.Time{monday.AddDate(0, 0, day), monday.AddDate(0, 0, day+1).Add(-time.Nanosecond)} {
+			if start := leaderboardStart(leaderboardWeek, now); !start.Equal(monday) {
+				t.Errorf("%v: week starts %v, want %v", now, start, monday)
+			}
+		}
+	}
+	if start := leaderboardStart(leaderboardWeek, monday.Add(-time.Nanosecond)); !start.Equal(monday.AddDate(0, 0, -7)) {
+		t.Errorf("sunday before: week starts %v", start)
+	}
+}
+
+func TestLeaderboardStartAtLocalMidnight(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip("no time zone data:", err)
+	}
+	//summer time starts on sunday, march 29th 2026, so that week has only 167 hours
+	tests := map[time.Time]time.Time{
+		time.Date(2026, time.March, 29, 12, 0, 0, 0, berlin):  time.Date(2026, time.March, 23, 0, 0, 0, 0, berlin),
+		time.Date(2026, time.March, 31, 1, 0, 0, 0, berlin):   time.Date(2026, time.March, 30, 0, 0, 0, 0, berlin),
+		time.Date(2026, time.February, 1, 0, 0, 0, 0, berlin): time.Date(2026, time.January, 26, 0, 0, 0, 0, berlin),
+	}
+	for now, want := range tests {
+		if start := leaderboardStart(leaderboardWeek, now); !start.Equal(want) {
+			t.Errorf("%v: week starts %v, want %v", now, start, want)
+		}
+	}
+	if start := leaderboardStart(leaderboardSemester, time.Date(2026, time.February, 1, 0, 30, 0, 0, berlin)); !start.Equal(time.Date(2026, time.February, 1, 0, 0, 0, 0, berlin)) {
+		t.Errorf("half an hour into the semester it starts %v, even though it is still january in UTC", start)
+	}
+}
+
+func TestLeaderboardStartSemester(t *testing.T) {
+	at := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		name  string
+		now   time.Time
+		start time.Time
+	}{
+		{"first day of the summer semester", at(2026, time.February, 1), at(2026, time.February, 1)},
+		{"last moment of the summer semester", at(2026, time.August, 1).Add(-time.Nanosecond), at(2026, time.February, 1)},
+		{"first day of the winter semester", at(2026, time.August, 1), at(2026, time.August, 1)},
+		{"winter semester in december", at(2026, time.December, 31), at(2026, time.August, 1)},
+		{"winter semester in january belongs to the year before", at(2027, time.January, 31), at(2026, time.August, 1)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if start := leaderboardStart(leaderboardSemester, test.now); !start.Equal(test.start) {
+				t.Errorf("got %v, want %v", start, test.start)
+			}
+		})
+	}
+}
+
+func TestLeaderboardStartAll(t *testing.T) {
+	for _, window := range []string{leaderboardAll, ""} {
+		if start := leaderboardStart(window, time.Now()); !start.IsZero() {
+			t.Errorf("window %q starts %v, want no start", window, start)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+)
+
+//page types consisting of questions graded by the server, see pagetypes.go for their fields
+const (
+	pageTypeSingleChoice   = "single-choice"
+	pageTypeMultipleChoice = "multiple-choice"
+	pageTypeOrdering       = "ordering"
+	pageTypeFreeText       = "free-text"
+)
+
+//Question is one question of a quiz page. Correct holds the indices of the correct options, for ordering
+//questions all indices in the correct order. Accepted lists the accepted answers of a free text question.
+//Both are removed before a question is shown to students.
+type Question struct {
+	Prompt   string   `json:"prompt"`
+	Options  []string `json:"options"`
+	Correct  []int    `json:"correct,omitempty"`
+	Accepted []string `json:"accepted,omitempty"`
+	Id       int      `json:"id"`
+	seed     int64
+}
+
+//QuestionResult is the answer of a student to a question. Choices are option indices, for ordering questions
+//in the chosen order. Correct is set by the server.
+type QuestionResult struct {
+	QuestionId int    `json:"question"`
+	Choices    []int  `json:"choices"`
+	Text       string `json:"text"`
+	Correct    bool   `json:"correct"`
+}
+
+//distinctOptions tells whether all indices are options and none is repeated
+func distinctOptions(indices []int, options int) bool {
+	seen := make(map[int]bool)
+	for _, index := range indices {
+		if index < 0 || index >= options || seen[index] {
+			return false
+		}
+		seen[index] = true
+	}
+	return true
+}
+
+//hideSolutions removes everything from the questions that would give away the answers
+func hideSolutions(questions []Question) {
+	for i := range questions {
+		questions[i].Correct = nil
+		questions[i].Accepted = nil
+	}
+}
+
+//optionOrder is the order in which a user who can not see the solutions gets the options of the question, the
+//option at position i is Options[order[i]]. Authors tend to enter ordering questions in the correct order, so
+//the options are shuffled with the secret seed of the question and the user, the order stays the same on reload.
+func optionOrder(question Question, userId int) []int {
+	return rand.New(rand.NewSource(question.seed*31 + int64(userId))).Perm(len(question.Options))
+}
+
+//shuffleOptions puts the options of the questions into the order the user sees them in, see optionOrder
+func shuffleOptions(questions []Question, userId int) {
+	for i, question := range questions {
+		order := optionOrder(question, userId)
+		questions[i].Options = make([]string, len(order))
+		for position, option := range order {
+			questions[i].Options[position] = question.Options[option]
+		}
+	}
+}
+
+//translateChoices maps the choices of the results between the positions a user saw and the stored options,
+//orders holds the optionOrder of every question. toStored maps positions to options, otherwise options are
+//mapped to positions. Choices which are no option are kept, grading rejects them.
+func translateChoices(results []QuestionResult, orders map[int][]int, toStored bool) {
+	for i, result := range results {
+		order, ok := orders[result.QuestionId]
+		if !ok {
+			continue
+		}
+		mapping := make(map[int]int)
+		for position, option := range order {
+			if toStored {
+				mapping[position] = option
+			} else {
+				mapping[option] = position
+			}
+		}
+		choices := make([]int, len(result.Choices))
+		for j, choice := range result.Choices {
+			if mapped, ok := mapping[choice]; ok {
+				choices[j] = mapped
+			} else {
+				choices[j] = choice
+			}
+		}
+		results[i].Choices = choices
+	}
+}
+
+func normalizeAnswer(answer string) string {
+	return strings.ToLower(strings.Join(strings.Fields(answer), " "))
+}
+
+//gradeAnswer tells whether the answer is correct. Multiple choice answers are only correct if they contain
+//exactly the correct options, free text answers are compared ignoring case and whitespace.
+func gradeAnswer(pageType string, question Question, result QuestionResult) bool {
+	switch pageType {
+	case pageTypeFreeText:
+		text := normalizeAnswer(result.Text)
+		for _, accepted := range question.Accepted {
+			if normalizeAnswer(accepted) == text {
+				return true
+			}
+		}
+		return false
+	case pageTypeMultipleChoice:
+		if len(result.Choices) != len(question.Correct) || !distinctOptions(result.Choices, len(question.Options)) {
+			return false
+		}
+		for _, choice := range result.Choices {
+			found := false
+			for _, correct := range question.Correct {
+				found = found || choice == correct
+			}
+			if !found {
+				return false
+			}
+		}
+		return true
+	}
+	//single choice and ordering answers must match the solution exactly
+	if len(result.Choices) != len(question.Correct) {
+		return false
+	}
+	for i := range result.Choices {
+		if result.Choices[i] != question.Correct[i] {
+			return false
+		}
+	}
+	return true
+}
+
+//gradeQuestionResults grades the answers to the questions of a quiz page. Answers to questions of other pages
+//are an error.
+func gradeQuestionResults(page Page, results []QuestionResult) error {
+	questions := make(map[int]Question)
+	for _, question := range page.Questions {
+		questions[question.Id] = question
+	}
+	seen := make(map[int]bool)
+	for i, result := range results {
+		question, ok := questions[result.QuestionId]
+		if !ok || seen[result.QuestionId] {
+			return fmt.Errorf("question %d is not on page %d or answered twice", result.QuestionId, page.ID)
+		}
+		seen[result.QuestionId] = true
+		results[i].Correct = gradeAnswer(page.PageType, question, result)
+	}
+	return nil
+}
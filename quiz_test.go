@@ -0,0 +1,145 @@
+package main
+
+import "testing"
+
+func TestGradeAnswer(t *testing.T) {
+	t.Run("single choice", func(t *testing.T) {
+		question := Question{Options: []string{"1848", "1871", "1918"}, Correct: []int{1}}
+		if !gradeAnswer(pageTypeSingleChoice, question, QuestionResult{Choices: []int{1}}) {
+			t.Error("the correct option is graded wrong")
+		}
+		for _, choices := range [][]int{{0}, {1, 1}, {}, nil, {-1}} {
+			if gradeAnswer(pageTypeSingleChoice, question, QuestionResult{Choices: choices}) {
+				t.Errorf("%v is graded correct", choices)
+			}
+		}
+	})
+	t.Run("multiple choice", func(t *testing.T) {
+		question := Question{Options: []string{"a", "b", "c", "d"}, Correct: []int{3, 0}}
+		if !gradeAnswer(pageTypeMultipleChoice, question, QuestionResult{Choices: []int{0, 3}}) {
+			t.Error("the correct options in another order are graded wrong")
+		}
+		//repeating a correct option must not make up for a missing one
+		for _, choices := range [][]int{{0}, {0, 0}, {0, 3, 1}, {0, 4}, {3, 3}} {
+			if gradeAnswer(pageTypeMultipleChoice, question, QuestionResult{Choices: choices}) {
+				t.Errorf("%v is graded correct", choices)
+			}
+		}
+	})
+	t.Run("ordering", func(t *testing.T) {
+		question := Question{Options: []string{"Reformation", "Aufklärung", "Französische Revolution"}, Correct: []int{0, 1, 2}}
+		if !gradeAnswer(pageTypeOrdering, question, QuestionResult{Choices: []int{0, 1, 2}}) {
+			t.Error("the correct order is graded wrong")
+		}
+		for _, choices := range [][]int{{1, 0, 2}, {0, 1}, {0, 1, 2, 2}} {
+			if gradeAnswer(pageTypeOrdering, question, QuestionResult{Choices: choices}) {
+				t.Errorf("%v is graded correct", choices)
+			}
+		}
+	})
+	t.Run("free text", func(t *testing.T) {
+		question := Question{Accepted: []string{"Otto von Bismarck", " bismarck"}}
+		for _, text := range []string{"Otto von Bismarck", "otto  VON\tbismarck", "Bismarck\n"} {
+			if !gradeAnswer(pageTypeFreeText, question, QuestionResult{Text: text}) {
+				t.Errorf("%q is graded wrong", text)
+			}
+		}
+		for _, text := range []string{"", "Bismarck, Otto von", "Ottovon Bismarck"} {
+			if gradeAnswer(pageTypeFreeText, question, QuestionResult{Text: text}) {
+				t.Errorf("%q is graded correct", text)
+			}
+		}
+	})
+}
+
+func TestGradeQuestionResults(t *testing.T) {
+	page := Page{ID: 7, PageType: pageTypeSingleChoice, Questions: []Question{
+		{Options: []string{"a", "b"}, Correct: []int{0}, Id: 1},
+		{Options: []string{"a", "b"}, Correct: []int{1}, Id: 2},
+	}}
+	//the client's own verdict is overwritten
+	results := []QuestionResult{{QuestionId: 2, Choices: []int{0}, Correct: true}, {QuestionId: 1, Choices: []int{0}}}
+	if err := gradeQuestionResults(page, results); err != nil {
+		t.Fatal(err)
+	}
+	if results[0].Correct || !results[1].Correct {
+		t.Errorf("got %+v", results)
+	}
+	if err := gradeQuestionResults(page, []QuestionResult{{QuestionId: 3}}); err == nil {
+		t.Error("an answer to a question of another page is accepted")
+	}
+	if err := gradeQuestionResults(page, []QuestionResult{{QuestionId: 1}, {QuestionId: 1, Choices: []int{0}}}); err == nil {
+		t.Error("a question answered twice is accepted")
+	}
+}
+
+func TestHideSolutions(t *testing.T) {
+	questions := []Question{{Prompt: "?", Options: []string{"a", "b"}, Correct: []int{1}, Id: 1}, {Prompt: "!", Accepted: []string{"x"}, Id: 2}}
+	hideSolutions(questions)
+	for _, question := range questions {
+		if question.Correct != nil || question.Accepted != nil {
+			t.Errorf("question %d still has its solution: %+v", question.Id, question)
+		}
+	}
+	if len(questions[0].Options) != 2 || questions[0].Prompt != "?" {
+		t.Errorf("the question itself is gone: %+v", questions[0])
+	}
+}
+
+//TestShuffledOptionsAreGradedAsStored answers an ordering question in the order a student sees it and checks
+//that the answer is graded against the stored solution and shown to the student in their order again
+func TestShuffledOptionsAreGradedAsStored(t *testing.T) {
+	question := Question{Options: []string{"1517", "1618", "1789", "1848", "1871"}, Correct: []int{0, 1, 2, 3, 4}, Id: 9, seed: 4711}
+	page := Page{PageType: pageTypeOrdering, Questions: []Question{question}}
+	shown := []Question{question}
+	shuffleOptions(shown, 3)
+	again := []Question{question}
+	shuffleOptions(again, 3)
+	for i := range shown[0].Options {
+		if shown[0].Options[i] != again[0].Options[i] {
+			t.Fatalf("the order changed on reload: %v and %v", shown[0].Options, again[0].Options)
+		}
+	}
+
+	//the student sorts the years they see
+	position := make(map[string]int)
+	for i, option := range shown[0].Options {
+		position[option] = i
+	}
+	choices := make([]int, 0)
+	for _, year := range question.Options {
+		choices = append(choices, position[year])
+	}
+	orders := map[int][]int{question.Id: optionOrder(question, 3)}
+	results := []QuestionResult{{QuestionId: question.Id, Choices: choices}}
+	translateChoices(results, orders, true)
+	if err := gradeQuestionResults(page, results); err != nil || !results[0].Correct {
+		t.Errorf("the correct order is graded %v (%v) after mapping %v to %v", results[0].Correct, err, choices, results[0].Choices)
+	}
+	translateChoices(results, orders, false)
+	for i := range choices {
+		if results[0].Choices[i] != choices[i] {
+			t.Fatalf("the student gets %v back instead of %v", results[0].Choices, choices)
+		}
+	}
+}
+
+func TestOptionOrderIsAPermutation(t *testing.T) {
+	question := Question{Options: make([]string, 6), seed: 12345}
+	differs := false
+	for userId := 0; userId < 50; userId++ {
+		order := optionOrder(question, userId)
+		if !distinctOptions(order, len(question.Options)) || len(order) != len(question.Options) {
+			t.Fatalf("user %d: %v is no order of the options", userId, order)
+		}
+		for i, option := range order {
+			differs = differs || option != i
+		}
+	}
+	if !differs {
+		t.Error("no user sees the options shuffled")
+	}
+	if order := optionOrder(Question{Accepted: []string{"x"}}, 1); len(order) != 0 {
+		t.Errorf("free text question: got %v", order)
+	}
+}
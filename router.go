@@ -21,23 +21,23 @@ func NewRouter() *mux.Router {
 	//register public routes, no middleware needed
 	for _, route := range publicRoutes {
 		var handler http.Handler
-		handler = jwtOptionalMiddleware.Handler(route.Handler)
+		handler = jwtOptionalMiddleware.Handler(NewRequireSession(route.Handler))
 		registerRoute(api, route, handler)
 	}
 	//register auth routes, only need to be logged in
 	for _, route := range authRoutes {
 		var handler http.Handler
-		handler = jwtRequiredMiddleware.Handler(route.Handler)
+		handler = jwtRequiredMiddleware.Handler(NewRequireSession(route.Handler))
 		registerRoute(api, route, handler)
 	}
 	for _, route := range editorRoutes {
 		var handler http.Handler
-		handler = jwtRequiredMiddleware.Handler(NewRequireRole(route.Handler, "editor"))
+		handler = jwtRequiredMiddleware.Handler(NewRequireSession(NewRequireRole(route.Handler, "editor")))
 		registerRoute(api, route, handler)
 	}
 	for _, route := range adminRoutes {
 		var handler http.Handler
-		handler = jwtRequiredMiddleware.Handler(NewRequireRole(route.Handler, "admin"))
+		handler = jwtRequiredMiddleware.Handler(NewRequireSession(NewRequireRole(route.Handler, "admin")))
 		registerRoute(api, route, handler)
 	}
 
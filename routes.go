@@ -18,9 +18,63 @@ var adminRoutes = Routes{
 		"/units/{unitId}",
 		DeleteUnit,
 	},
+	Route{
+		"GetGroups",
+		"GET",
+		"/groups",
+		Groups,
+	},
+	Route{
+		"GroupById",
+		"GET",
+		"/groups/{groupId}",
+		GroupById,
+	},
+	Route{
+		"CreateGroup",
+		"POST",
+		"/groups",
+		CreateGroup,
+	},
+	Route{
+		"UpdateGroup",
+		"PUT",
+		"/groups/{groupId}",
+		UpdateGroup,
+	},
+	Route{
+		"DeleteGroup",
+		"DELETE",
+		"/groups/{groupId}",
+		DeleteGroup,
+	},
+	Route{
+		"CreateAchievement",
+		"POST",
+		"/achievements",
+		CreateAchievement,
+	},
+	Route{
+		"UpdateAchievement",
+		"PUT",
+		"/achievements/{achievementId}",
+		UpdateAchievement,
+	},
+	Route{
+		"DeleteAchievement",
+		"DELETE",
+		"/achievements/{achievementId}",
+		DeleteAchievement,
+	},
 }
 
 var editorRoutes = Routes{
+	Route{
+		"CloneUnit",
+		"POST",
+		"/units/{unitId}/clone",
+		CloneUnit,
+	},
 	Route{
 		"UnitCreate",
 		"POST",
@@ -51,6 +105,18 @@ var editorRoutes = Routes{
 		"/pages/{pageId}",
 		DeletePage,
 	},
+	Route{
+		"ReorderRows",
+		"PUT",
+		"/pages/{pageId}/rows/order",
+		ReorderRows,
+	},
+	Route{
+		"ReorderPages",
+		"PUT",
+		"/units/{unitId}/pages/order",
+		ReorderPages,
+	},
 	Route{
 		"DeleteRow",
 		"DELETE",
@@ -58,16 +124,70 @@ var editorRoutes = Routes{
 		DeleteRow,
 	},
 	Route{
-		"CreateErrorImage",
+		"CreateCourse",
 		"POST",
-		"/errorImages",
-		CreateErrorImage,
+		"/courses",
+		CreateCourse,
 	},
 	Route{
-		"UploadErrorImage",
+		"UpdateCourse",
 		"PUT",
-		"/errorImages/{errorImageId}",
-		UploadOrUpdateErrorImage,
+		"/courses/{courseId}",
+		UpdateCourse,
+	},
+	Route{
+		"DeleteCourse",
+		"DELETE",
+		"/courses/{courseId}",
+		DeleteCourse,
+	},
+	Route{
+		"RenewJoinCode",
+		"POST",
+		"/courses/{courseId}/joinCode",
+		RenewJoinCode,
+	},
+	Route{
+		"CourseMembers",
+		"GET",
+		"/courses/{courseId}/members",
+		CourseMembers,
+	},
+	Route{
+		"CourseProgress",
+		"GET",
+		"/courses/{courseId}/progress",
+		CourseProgress,
+	},
+	Route{
+		"CreateAssignment",
+		"POST",
+		"/assignments",
+		CreateAssignment,
+	},
+	Route{
+		"UpdateAssignment",
+		"PUT",
+		"/assignments/{assignmentId}",
+		UpdateAssignment,
+	},
+	Route{
+		"DeleteAssignment",
+		"DELETE",
+		"/assignments/{assignmentId}",
+		DeleteAssignment,
+	},
+	Route{
+		"AssignmentSubmissions",
+		"GET",
+		"/assignments/{assignmentId}/submissions",
+		AssignmentSubmissions,
+	},
+	Route{
+		"ModerateErrorImage",
+		"POST",
+		"/errorImages/{errorImageId}/moderation",
+		ModerateErrorImage,
 	},
 	Route{
 		"CreateImage",
@@ -150,6 +270,246 @@ var authRoutes = Routes{
 		"/users/{userId}",
 		UpdateUser,
 	},
+	Route{
+		"UnitMembers",
+		"GET",
+		"/units/{unitId}/members",
+		UnitMembers,
+	},
+	Route{
+		"AddUnitMember",
+		"POST",
+		"/units/{unitId}/members",
+		AddUnitMember,
+	},
+	Route{
+		"UpdateUnitMember",
+		"PUT",
+		"/units/{unitId}/members/{userId}",
+		UpdateUnitMember,
+	},
+	Route{
+		"RemoveUnitMember",
+		"DELETE",
+		"/units/{unitId}/members/{userId}",
+		RemoveUnitMember,
+	},
+	Route{
+		"UnitStatusEvents",
+		"GET",
+		"/units/{unitId}/statusEvents",
+		UnitStatusEvents,
+	},
+	Route{
+		"CreateUnitStatusEvent",
+		"POST",
+		"/units/{unitId}/statusEvents",
+		CreateUnitStatusEvent,
+	},
+	Route{
+		"UpdateUnitSchedule",
+		"PUT",
+		"/units/{unitId}/schedule",
+		UpdateUnitSchedule,
+	},
+	Route{
+		"UnitRevisions",
+		"GET",
+		"/units/{unitId}/revisions",
+		UnitRevisions,
+	},
+	Route{
+		"UnitRevisionById",
+		"GET",
+		"/units/{unitId}/revisions/{revisionId}",
+		UnitRevisionById,
+	},
+	Route{
+		"DiffUnitRevisions",
+		"GET",
+		"/units/{unitId}/revisions/{revisionId}/diff",
+		DiffUnitRevisions,
+	},
+	Route{
+		"RevertUnit",
+		"POST",
+		"/units/{unitId}/revisions/{revisionId}/revert",
+		RevertUnit,
+	},
+	Route{
+		"RevertPage",
+		"POST",
+		"/units/{unitId}/revisions/{revisionId}/pages/{pageId}/revert",
+		RevertPage,
+	},
+	Route{
+		"CreateCite",
+		"POST",
+		"/cites",
+		CreateCite,
+	},
+	Route{
+		"UpdateCite",
+		"PUT",
+		"/cites/{citeId}",
+		UpdateCite,
+	},
+	Route{
+		"DeleteCite",
+		"DELETE",
+		"/cites/{citeId}",
+		DeleteCite,
+	},
+	Route{
+		"ImportCites",
+		"POST",
+		"/units/{unitId}/cites/import",
+		ImportCites,
+	},
+	Route{
+		"Courses",
+		"GET",
+		"/courses",
+		Courses,
+	},
+	Route{
+		"CourseById",
+		"GET",
+		"/courses/{courseId}",
+		CourseById,
+	},
+	Route{
+		"JoinCourse",
+		"POST",
+		"/courseMemberships",
+		JoinCourse,
+	},
+	Route{
+		"RemoveCourseMember",
+		"DELETE",
+		"/courses/{courseId}/members/{userId}",
+		RemoveCourseMember,
+	},
+	Route{
+		"Assignments",
+		"GET",
+		"/assignments",
+		Assignments,
+	},
+	Route{
+		"AssignmentById",
+		"GET",
+		"/assignments/{assignmentId}",
+		AssignmentById,
+	},
+	Route{
+		"SubmitAssignment",
+		"POST",
+		"/assignments/{assignmentId}/submission",
+		SubmitAssignment,
+	},
+	Route{
+		"CreateErrorImage",
+		"POST",
+		"/errorImages",
+		CreateErrorImage,
+	},
+	Route{
+		"UploadErrorImage",
+		"PUT",
+		"/errorImages/{errorImageId}",
+		UploadOrUpdateErrorImage,
+	},
+	Route{
+		"Notifications",
+		"GET",
+		"/notifications",
+		Notifications,
+	},
+	Route{
+		"UpdateNotification",
+		"PUT",
+		"/notifications/{notificationId}",
+		UpdateNotification,
+	},
+	Route{
+		"CreateErrorImageAttempt",
+		"POST",
+		"/errorImages/{errorImageId}/attempts",
+		CreateErrorImageAttempt,
+	},
+	Route{
+		"ErrorImageAttemptById",
+		"GET",
+		"/errorImageAttempts/{attemptId}",
+		ErrorImageAttemptById,
+	},
+	Route{
+		"CreateErrorImageClick",
+		"POST",
+		"/errorImageAttempts/{attemptId}/clicks",
+		CreateErrorImageClick,
+	},
+	Route{
+		"DatingRounds",
+		"GET",
+		"/datingRounds",
+		DatingRounds,
+	},
+	Route{
+		"CreateDatingRound",
+		"POST",
+		"/datingRounds",
+		CreateDatingRound,
+	},
+	Route{
+		"DatingRoundById",
+		"GET",
+		"/datingRounds/{roundId}",
+		DatingRoundById,
+	},
+	Route{
+		"CreateDatingAnswer",
+		"POST",
+		"/datingRounds/{roundId}/answers",
+		CreateDatingAnswer,
+	},
+	Route{
+		"Leaderboard",
+		"GET",
+		"/leaderboard",
+		Leaderboard,
+	},
+	Route{
+		"RowAnalytics",
+		"GET",
+		"/units/{unitId}/analytics/rows",
+		RowAnalytics,
+	},
+	Route{
+		"PageAnalytics",
+		"GET",
+		"/units/{unitId}/analytics/pages",
+		PageAnalytics,
+	},
+	Route{
+		"ShiftAnalytics",
+		"GET",
+		"/units/{unitId}/analytics/shifts",
+		ShiftAnalytics,
+	},
+	Route{
+		"TrendAnalytics",
+		"GET",
+		"/units/{unitId}/analytics/trends",
+		TrendAnalytics,
+	},
+	Route{
+		"Logout",
+		"POST",
+		"/logout",
+		LogoutHandler,
+	},
 }
 
 var publicRoutes = Routes{
@@ -165,6 +525,24 @@ var publicRoutes = Routes{
 		"/unitResults/{unitId}",
 		GetUnitResult,
 	},
+	Route{
+		"CiteById",
+		"GET",
+		"/cites/{citeId}",
+		CiteById,
+	},
+	Route{
+		"UnitBibliography",
+		"GET",
+		"/units/{unitId}/bibliography",
+		UnitBibliography,
+	},
+	Route{
+		"ExportCites",
+		"GET",
+		"/units/{unitId}/cites/export",
+		ExportCites,
+	},
 	Route{
 		"PublishedUnits",
 		"GET",
@@ -189,6 +567,24 @@ var publicRoutes = Routes{
 		"/pages/{pageId}",
 		PageById,
 	},
+	Route{
+		"PageTypes",
+		"GET",
+		"/pageTypes",
+		PageTypes,
+	},
+	Route{
+		"Achievements",
+		"GET",
+		"/achievements",
+		Achievements,
+	},
+	Route{
+		"AchievementById",
+		"GET",
+		"/achievements/{achievementId}",
+		AchievementById,
+	},
 	Route{
 		"UserById",
 		"GET",
@@ -243,6 +639,30 @@ var publicRoutes = Routes{
 		"/login",
 		LoginHandler,
 	},
+	Route{
+		"ConfirmMail",
+		"POST",
+		"/confirmations/{token}",
+		ConfirmMail,
+	},
+	Route{
+		"ResetPassword",
+		"POST",
+		"/passwordResets/{token}",
+		ResetPassword,
+	},
+	Route{
+		"RefreshToken",
+		"POST",
+		"/tokens/refresh",
+		RefreshTokenHandler,
+	},
+	Route{
+		"JSONWebKeys",
+		"GET",
+		"/jwks",
+		JSONWebKeys,
+	},
 	Route{
 		"Register",
 		"POST",
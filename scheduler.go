@@ -0,0 +1,43 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+const defaultScheduleInterval = time.Minute
+
+func scheduleInterval() time.Duration {
+	if conf.ScheduleSeconds > 0 {
+		return time.Duration(conf.ScheduleSeconds) * time.Second
+	}
+	return defaultScheduleInterval
+}
+
+//runScheduler publishes and archives units according to their schedule. The visibility checks honor the
+//schedule on their own, the scheduler only keeps the status and its history in line with it.
+func runScheduler(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		applySchedule()
+		<-ticker.C
+	}
+}
+
+func applySchedule() {
+	if events, err := PublishScheduledUnits(); err != nil {
+		log.Println("publishing scheduled units failed:", err)
+	} else {
+		for _, event := range events {
+			log.Println("published unit", event.UnitId, "as scheduled")
+		}
+	}
+	if events, err := ArchiveExpiredUnits(); err != nil {
+		log.Println("archiving expired units failed:", err)
+	} else {
+		for _, event := range events {
+			log.Println("archived unit", event.UnitId, "as scheduled")
+		}
+	}
+}
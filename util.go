@@ -2,12 +2,15 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 )
 
 const mb = 1024 * 1024
@@ -46,6 +49,73 @@ func unauthorized(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func conflict(w http.ResponseWriter, r *http.Request, message string) {
+	w.WriteHeader(http.StatusConflict)
+	apiErr := jsonErr{Code: http.StatusConflict, Message: message}
+	if err := json.NewEncoder(w).Encode(apiErr); err != nil {
+		panic(err)
+	}
+}
+
+func etag(version int) string {
+	return fmt.Sprintf("\"%d\"", version)
+}
+
+//ifMatchVersion reads the version from the If-Match header, a header which is no version yields -1 so it never
+//matches. A missing header and * are rejected, both would overwrite changes of others. On failure the response
+//is already written.
+func ifMatchVersion(w http.ResponseWriter, r *http.Request) (version int, ok bool) {
+	header := r.Header.Get("If-Match")
+	if len(header) == 0 {
+		preconditionRequired(w, r)
+		return 0, false
+	}
+	header = strings.TrimPrefix(strings.TrimSpace(header), "W/")
+	if header == "*" {
+		badRequest(w, r, "If-Match must contain the current version, * is not accepted")
+		return 0, false
+	}
+	version, err := strconv.Atoi(strings.Trim(header, "\""))
+	if err != nil {
+		return -1, true
+	}
+	return version, true
+}
+
+//invalidFields answers with 422 and the invalid fields, unlike notParsable the client learns what to fix
+func invalidFields(w http.ResponseWriter, r *http.Request, errs []FieldError) {
+	w.WriteHeader(422)
+	apiErr := validationErr{Code: 422, Message: "Invalid fields.", Errors: errs}
+	if err := json.NewEncoder(w).Encode(apiErr); err != nil {
+		panic(err)
+	}
+}
+
+func badRequest(w http.ResponseWriter, r *http.Request, message string) {
+	w.WriteHeader(http.StatusBadRequest)
+	apiErr := jsonErr{Code: http.StatusBadRequest, Message: message}
+	if err := json.NewEncoder(w).Encode(apiErr); err != nil {
+		panic(err)
+	}
+}
+
+func preconditionRequired(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusPreconditionRequired)
+	apiErr := jsonErr{Code: http.StatusPreconditionRequired, Message: "If-Match header with the current version is required"}
+	if err := json.NewEncoder(w).Encode(apiErr); err != nil {
+		panic(err)
+	}
+}
+
+//preconditionFailed answers a stale If-Match with the current state of the object, wrapped in key
+func preconditionFailed(w http.ResponseWriter, r *http.Request, key string, current interface{}, version int) {
+	w.Header().Set("ETag", etag(version))
+	w.WriteHeader(http.StatusPreconditionFailed)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{key: current}); err != nil {
+		panic(err)
+	}
+}
+
 func notParsable(w http.ResponseWriter, r *http.Request, err error) {
 	w.WriteHeader(422)
 	log.Println(err)
@@ -74,6 +144,23 @@ func notAcceptable(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
 func sendImage(w http.ResponseWriter, r *http.Request, imagePath string) {
 	if _, err := os.Stat(imagePath); err == nil {
 		file, err := os.Open(imagePath)
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIfMatchVersion(t *testing.T) {
+	check := func(header string, wantVersion int, wantOk bool, wantStatus int) {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodPut, "/api/units/1", nil)
+		if header != "" {
+			r.Header.Set("If-Match", header)
+		}
+		w := httptest.NewRecorder()
+		version, ok := ifMatchVersion(w, r)
+		if version != wantVersion || ok != wantOk || w.Code != wantStatus {
+			t.Errorf("If-Match %q: got version %d, ok %v, status %d", header, version, ok, w.Code)
+		}
+	}
+	check(`"7"`, 7, true, http.StatusOK)
+	check(`W/"7"`, 7, true, http.StatusOK)
+	check(` "0" `, 0, true, http.StatusOK)
+	//a list of versions or garbage is a version that never matches, the client gets the current state with 412
+	check(`"6", "7"`, -1, true, http.StatusOK)
+	check(`abc`, -1, true, http.StatusOK)
+	check(``, 0, false, http.StatusPreconditionRequired)
+	check(`*`, 0, false, http.StatusBadRequest)
+	check(` * `, 0, false, http.StatusBadRequest)
+}